@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -9,13 +10,25 @@ import (
 
 	"github.com/lucrnz/ripvex/internal/cleanup"
 	"github.com/lucrnz/ripvex/internal/cli"
+	"github.com/lucrnz/ripvex/internal/redact"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run executes the CLI and returns the process exit code. It is separate from
+// main so deferred cleanup runs before os.Exit.
+func run() int {
 	// Set up signal handling for graceful shutdown
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	// Without a SIGPIPE handler the runtime kills the process on the first
+	// write to a closed stdout/stderr. Ignoring it turns those writes into
+	// EPIPE errors, so a vanished stderr reader only silences diagnostics.
+	signal.Ignore(syscall.SIGPIPE)
+
 	// Create cleanup tracker for temporary files
 	tracker := cleanup.NewTracker()
 	defer tracker.Cleanup()
@@ -25,9 +38,15 @@ func main() {
 		// Check if error is due to context cancellation (interrupt)
 		if ctx.Err() == context.Canceled {
 			fmt.Fprintln(os.Stderr, "\nInterrupted")
-			os.Exit(130) // Standard exit code for SIGINT
+			return cli.ExitInterrupted
+		}
+		// Downstream reader of -O - went away (e.g. "| head"): exit like SIGPIPE would
+		if errors.Is(err, syscall.EPIPE) {
+			return 141
 		}
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		// Errors may quote a URL with credentials
+		fmt.Fprintln(os.Stderr, redact.Text(err.Error()))
+		return cli.ExitCode(err)
 	}
+	return cli.ExitOK
 }
@@ -3,14 +3,23 @@ module github.com/lucrnz/ripvex
 go 1.25.5
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/dustin/go-humanize v1.0.1
 	github.com/klauspost/compress v1.18.2
+	github.com/klauspost/pgzip v1.2.6
+	github.com/pierrec/lz4/v4 v4.1.22
 	github.com/spf13/cobra v1.8.1
+	github.com/spf13/pflag v1.0.5
 	github.com/ulikunitz/xz v0.5.15
+	github.com/xhit/go-str2duration/v2 v2.1.0
+	golang.org/x/crypto v0.45.0
+	golang.org/x/sys v0.38.0
+	golang.org/x/term v0.37.0
+	golang.org/x/text v0.31.0
+	lukechampine.com/blake3 v1.4.1
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
-	github.com/xhit/go-str2duration/v2 v2.1.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 )
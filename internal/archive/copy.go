@@ -3,6 +3,9 @@ package archive
 import (
 	"context"
 	"io"
+	"os"
+
+	"github.com/lucrnz/ripvex/internal/progress"
 )
 
 // copyWithContext copies up to size bytes from src to dst while periodically
@@ -48,3 +51,40 @@ func copyWithContext(ctx context.Context, dst io.Writer, src io.Reader, size int
 
 	return written, nil
 }
+
+// progressReader reports every read to a progress bar
+type progressReader struct {
+	r   io.Reader
+	bar *progress.Bar
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.bar.Update(int64(n))
+	return n, err
+}
+
+// startProgress sets the total of opts.Progress and starts it, if configured
+func startProgress(total int64, opts ExtractOptions) {
+	if opts.Progress == nil {
+		return
+	}
+	opts.Progress.Total = total
+	opts.Progress.Start()
+}
+
+// withFileProgress starts opts.Progress and wraps an archive file so reads
+// advance it. Progress is measured in bytes consumed from disk, so the
+// percentage is known up front even for compressed tarballs.
+func withFileProgress(f *os.File, opts ExtractOptions) io.Reader {
+	r := opts.stats.archiveReader(f)
+	if opts.Progress == nil {
+		return r
+	}
+	var total int64
+	if info, err := f.Stat(); err == nil {
+		total = info.Size()
+	}
+	startProgress(total, opts)
+	return &progressReader{r: r, bar: opts.Progress}
+}
@@ -1,10 +1,20 @@
 package archive
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"mime"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// DetectSize is the number of leading bytes Detect reads: 262 for the tar
+// ustar check and 32774 for the ISO 9660 volume descriptor
+const DetectSize = isoMagicOffset + 5 // len("CD001")
+
 // Detect reads the magic bytes from a file to determine its archive type
 func Detect(path string) (Type, error) {
 	f, err := os.Open(path)
@@ -13,50 +23,253 @@ func Detect(path string) (Type, error) {
 	}
 	defer f.Close()
 
-	// Read enough bytes to detect all formats (need 262 for tar ustar check).
 	// Use ReadFull to avoid short reads misclassifying valid archives.
-	buf := make([]byte, 262)
+	buf := make([]byte, DetectSize)
 	n, err := io.ReadFull(f, buf)
 	if err != nil {
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
 			return Unknown, err
 		}
 	}
-	buf = buf[:n]
+	return DetectBytes(buf[:n]), nil
+}
 
+// DetectBytes determines the archive type from the leading bytes of a file.
+// At least 262 bytes are needed to recognize a plain tar archive, and 32774
+// for an ISO 9660 image.
+func DetectBytes(buf []byte) Type {
 	// Check ZIP: PK\x03\x04
 	if len(buf) >= 4 && buf[0] == 0x50 && buf[1] == 0x4B && buf[2] == 0x03 && buf[3] == 0x04 {
-		return Zip, nil
+		return Zip
 	}
 
 	// Check GZIP: \x1f\x8b
 	if len(buf) >= 2 && buf[0] == 0x1F && buf[1] == 0x8B {
-		return Gzip, nil
+		return Gzip
 	}
 
 	// Check BZIP2: BZh
 	if len(buf) >= 3 && buf[0] == 0x42 && buf[1] == 0x5A && buf[2] == 0x68 {
-		return Bzip2, nil
+		return Bzip2
 	}
 
 	// Check XZ: \xFD7zXZ\x00
 	if len(buf) >= 6 && buf[0] == 0xFD && buf[1] == 0x37 && buf[2] == 0x7A &&
 		buf[3] == 0x58 && buf[4] == 0x5A && buf[5] == 0x00 {
-		return Xz, nil
+		return Xz
+	}
+
+	// Check ZSTD: \x28\xB5\x2F\xFD, possibly after skippable frames (pzstd)
+	if isZstd(buf) {
+		return Zstd
 	}
 
-	// Check ZSTD: \x28\xB5\x2F\xFD
-	if len(buf) >= 4 && buf[0] == 0x28 && buf[1] == 0xB5 && buf[2] == 0x2F && buf[3] == 0xFD {
-		return Zstd, nil
+	// Check LZ4: \x04\x22\x4D\x18, possibly after skippable frames, or the
+	// legacy format of lz4 -l (\x02\x21\x4C\x18)
+	if lz4 := skipFrames(buf); bytes.HasPrefix(lz4, lz4Magic) || bytes.HasPrefix(lz4, lz4LegacyMagic) {
+		return Lz4
 	}
 
 	// Check TAR: ustar at offset 257
 	if len(buf) >= 262 {
 		ustar := string(buf[257:262])
 		if ustar == "ustar" {
-			return Tar, nil
+			return Tar
 		}
 	}
 
-	return Unknown, nil
+	// Check ISO 9660: CD001 in the first volume descriptor (sector 16). Hybrid
+	// images start with a boot sector, so this is checked last.
+	if len(buf) >= isoMagicOffset+len(isoMagic) && bytes.Equal(buf[isoMagicOffset:isoMagicOffset+len(isoMagic)], isoMagic) {
+		return ISO9660
+	}
+
+	// Brotli has no magic bytes: check last whether buf decompresses to a
+	// tar header. A bare brotli file cannot be told from random data.
+	if CompressedTar(Brotli, buf) {
+		return Brotli
+	}
+
+	return Unknown
+}
+
+// Sources of the type returned by DetectWithFallback
+const (
+	DetectedByMagic       = "magic"
+	DetectedByExtension   = "extension"
+	DetectedByContentType = "content_type"
+)
+
+// DetectWithFallback detects the archive type of the file at path from its
+// magic bytes like Detect. When they match no type, it falls back to the
+// extension of path, then to contentType, the Content-Type the file was
+// served with ("" if none). It also returns which of them gave the type.
+func DetectWithFallback(path, contentType string) (Type, string, error) {
+	t, err := Detect(path)
+	if err != nil || t != Unknown {
+		return t, DetectedByMagic, err
+	}
+	if t := TypeFromName(path); t != Unknown {
+		return t, DetectedByExtension, nil
+	}
+	if t := TypeFromContentType(contentType); t != Unknown {
+		return t, DetectedByContentType, nil
+	}
+	return Unknown, "", nil
+}
+
+// extensionTypes maps file extensions to the archive type they suggest
+var extensionTypes = map[string]Type{
+	".zip":  Zip,
+	".jar":  Zip,
+	".war":  Zip,
+	".whl":  Zip,
+	".tar":  Tar,
+	".gz":   Gzip,
+	".gzip": Gzip,
+	".tgz":  Gzip,
+	".bz2":  Bzip2,
+	".tbz":  Bzip2,
+	".tbz2": Bzip2,
+	".xz":   Xz,
+	".txz":  Xz,
+	".zst":  Zstd,
+	".zstd": Zstd,
+	".tzst": Zstd,
+	".iso":  ISO9660,
+	".lz4":  Lz4,
+	".br":   Brotli,
+}
+
+// TypeFromName returns the archive type suggested by the extension of name,
+// or Unknown. The compressed type of a compressed tarball is returned.
+func TypeFromName(name string) Type {
+	return extensionTypes[strings.ToLower(filepath.Ext(name))]
+}
+
+// contentTypes maps Content-Types to the archive type they announce
+var contentTypes = map[string]Type{
+	"application/zip":              Zip,
+	"application/x-zip-compressed": Zip,
+	"application/java-archive":     Zip,
+	"application/x-tar":            Tar,
+	"application/gzip":             Gzip,
+	"application/x-gzip":           Gzip,
+	"application/x-gtar":           Gzip,
+	"application/x-bzip2":          Bzip2,
+	"application/x-xz":             Xz,
+	"application/zstd":             Zstd,
+	"application/x-lz4":            Lz4,
+	"application/x-iso9660-image":  ISO9660,
+	"application/x-brotli":         Brotli,
+}
+
+// TypeFromContentType returns the archive type announced by a Content-Type
+// header value, or Unknown
+func TypeFromContentType(contentType string) Type {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Unknown
+	}
+	return contentTypes[strings.ToLower(mediaType)]
+}
+
+// Types lists the archive types Extract supports
+var Types = []Type{Zip, Tar, Gzip, Bzip2, Xz, Zstd, ISO9660, Lz4, Brotli}
+
+// ParseType returns the archive type named name, as printed by Type.String
+func ParseType(name string) (Type, error) {
+	names := make([]string, len(Types))
+	for i, t := range Types {
+		if strings.EqualFold(name, t.String()) {
+			return t, nil
+		}
+		names[i] = t.String()
+	}
+	return Unknown, fmt.Errorf("unsupported archive type %q: must be one of %s", name, strings.Join(names, ", "))
+}
+
+var (
+	zstdMagic      = []byte{0x28, 0xB5, 0x2F, 0xFD}
+	lz4Magic       = []byte{0x04, 0x22, 0x4D, 0x18}
+	lz4LegacyMagic = []byte{0x02, 0x21, 0x4C, 0x18}
+)
+
+// isZstd reports whether buf starts with a zstd frame, possibly after
+// skippable frames
+func isZstd(buf []byte) bool {
+	return bytes.HasPrefix(skipFrames(buf), zstdMagic)
+}
+
+// skipFrames steps over the skippable frames (magic \x5?\x2A\x4D\x18 and a
+// 4-byte length) at the start of buf. zstd and LZ4 share them, and pzstd
+// writes one before every frame. It returns nil when a frame runs past buf.
+func skipFrames(buf []byte) []byte {
+	for len(buf) >= 8 && buf[0]&0xF0 == 0x50 && buf[1] == 0x2A && buf[2] == 0x4D && buf[3] == 0x18 {
+		skip := uint64(binary.LittleEndian.Uint32(buf[4:8]))
+		if skip > uint64(len(buf)-8) {
+			return nil
+		}
+		buf = buf[8+skip:]
+	}
+	return buf
+}
+
+// Executable formats recognized by DetectExecutable
+const (
+	ExecutableELF      = "elf"
+	ExecutableAppImage = "appimage"
+	ExecutableMachO    = "mach-o"
+	ExecutablePE       = "pe"
+)
+
+// DetectExecutable reports the format of a self-contained binary (ELF,
+// AppImage, Mach-O or PE), or "" if the file is not one
+func DetectExecutable(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 64)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	buf = buf[:n]
+
+	switch {
+	case bytes.HasPrefix(buf, []byte("\x7fELF")):
+		// AppImages are ELF runtimes with "AI" and the type in e_ident padding
+		if len(buf) >= 11 && buf[8] == 'A' && buf[9] == 'I' && (buf[10] == 1 || buf[10] == 2) {
+			return ExecutableAppImage, nil
+		}
+		return ExecutableELF, nil
+	case len(buf) >= 4 && isMachOMagic(binary.BigEndian.Uint32(buf)):
+		return ExecutableMachO, nil
+	case len(buf) >= 8 && binary.BigEndian.Uint32(buf) == 0xCAFEBABE:
+		// Universal binaries share their magic with Java class files; a fat
+		// header holds a small architecture count where a class file has
+		// its version (45 or more)
+		if binary.BigEndian.Uint32(buf[4:8]) < 45 {
+			return ExecutableMachO, nil
+		}
+	case len(buf) >= 64 && buf[0] == 'M' && buf[1] == 'Z':
+		// The DOS stub points to the "PE\0\0" signature
+		sig := make([]byte, 4)
+		if _, err := f.ReadAt(sig, int64(binary.LittleEndian.Uint32(buf[60:64]))); err == nil && string(sig) == "PE\x00\x00" {
+			return ExecutablePE, nil
+		}
+	}
+	return "", nil
+}
+
+// isMachOMagic reports whether magic starts a thin Mach-O binary, in either byte order
+func isMachOMagic(magic uint32) bool {
+	switch magic {
+	case 0xFEEDFACE, 0xFEEDFACF, 0xCEFAEDFE, 0xCFFAEDFE:
+		return true
+	}
+	return false
 }
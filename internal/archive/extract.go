@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 
 	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/logging"
 	"github.com/lucrnz/ripvex/internal/util"
 )
 
@@ -19,7 +20,35 @@ func Extract(ctx context.Context, tracker *cleanup.Tracker, path string, archive
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
+	if opts.Progress != nil {
+		defer opts.Progress.Stop()
+	}
+	opts.stats = newExtractStats(ctx)
+	defer opts.stats.finish()
+	opts = withUmask(opts)
+	opts.manifest = newManifestCheck(opts.Manifest)
+
+	if opts.StripTopLevel {
+		dir, n, err := topLevelDir(ctx, path, archiveType, opts)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			logging.FromContext(ctx).Info("extract_toplevel_stripped", "dir", dir, "strip_components", n)
+		} else {
+			logging.FromContext(ctx).Info("extract_toplevel_not_found", "hint", "entries do not share a single top-level directory; extracting them as they are")
+		}
+		opts.StripComponents = n
+	}
+
+	if err := extractType(ctx, tracker, path, archiveType, opts); err != nil {
+		return err
+	}
+	return opts.manifest.finish(opts)
+}
 
+// extractType extracts the archive at path with the extractor for its type
+func extractType(ctx context.Context, tracker *cleanup.Tracker, path string, archiveType Type, opts ExtractOptions) error {
 	switch archiveType {
 	case Zip:
 		return extractZip(ctx, tracker, path, opts)
@@ -33,11 +62,37 @@ func Extract(ctx context.Context, tracker *cleanup.Tracker, path string, archive
 		return extractXzTar(ctx, tracker, path, opts)
 	case Zstd:
 		return extractZstdTar(ctx, tracker, path, opts)
+	case ISO9660:
+		return extractISO9660(ctx, tracker, path, opts)
+	case Lz4:
+		return extractLz4Tar(ctx, tracker, path, opts)
+	case Brotli:
+		return extractBrotliTar(ctx, tracker, path, opts)
 	default:
 		return fmt.Errorf("unsupported archive type: %s", archiveType)
 	}
 }
 
+// destination resolves opts.DestDir, or the working directory when it is
+// empty, to an absolute path without symlinks. Every path extracted to is
+// built on it, and being absolute lets the os package add the \\?\ prefix
+// on Windows for paths longer than MAX_PATH.
+func destination(opts ExtractOptions) (string, error) {
+	dir := opts.DestDir
+	if dir == "" {
+		dir = "."
+	}
+	destDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	destDir, err = filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve destination path: %w", err)
+	}
+	return destDir, nil
+}
+
 // extractTarFromFile extracts a plain tar archive from a file
 func extractTarFromFile(ctx context.Context, tracker *cleanup.Tracker, path string, opts ExtractOptions) error {
 	f, err := os.Open(path)
@@ -46,26 +101,35 @@ func extractTarFromFile(ctx context.Context, tracker *cleanup.Tracker, path stri
 	}
 	defer f.Close()
 
-	return extractTar(ctx, tracker, f, opts)
+	return extractTar(ctx, tracker, withFileProgress(f, opts), opts)
+}
+
+// removeForLink removes an existing file at path, which a hard link is
+// about to replace
+func removeForLink(root *extractRoot, path string) error {
+	if err := root.remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove existing path for hard link: %w", err)
+	}
+	return nil
 }
 
 // extractTar extracts a tar archive from a reader with zip slip protection
 func extractTar(ctx context.Context, tracker *cleanup.Tracker, r io.Reader, opts ExtractOptions) error {
-	destDir, err := filepath.Abs(".")
+	root, err := openRoot(&opts)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
-	}
-	destDir, err = filepath.EvalSymlinks(destDir)
-	if err != nil {
-		return fmt.Errorf("failed to resolve destination path: %w", err)
+		return err
 	}
+	defer root.close()
+	destDir := root.dir
 
-	tr := tar.NewReader(r)
+	tr := tar.NewReader(opts.stats.streamReader(r))
 	type pendingLink struct {
 		destPath   string
 		linkTarget string
 	}
 	var pendingLinks []pendingLink
+	var copies pendingCopies
+	var dirs pendingDirs
 	var extracted int64
 
 	for {
@@ -87,35 +151,53 @@ func extractTar(ctx context.Context, tracker *cleanup.Tracker, r io.Reader, opts
 		if name == "" {
 			continue // Skip entries that are entirely stripped
 		}
+		if ok, err := admitEntry(ctx, name, header.Typeflag == tar.TypeDir, opts); !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if name, err = entryName(ctx, name, opts); err != nil {
+			return err
+		}
 
-		// Zip slip protection
+		// Zip slip protection. The root enforces it; this reports it clearly.
 		destPath := filepath.Join(destDir, name)
 		if !util.IsPathSafe(destPath, destDir) {
 			return fmt.Errorf("tar slip detected: %s", name)
 		}
-		if _, err := util.ResolvePathWithinBase(destPath, destDir); err != nil {
-			return fmt.Errorf("tar path contains unsafe symlink for %s: %w", name, err)
-		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
-			if err := os.MkdirAll(destPath, 0755); err != nil {
+			if err := root.mkdirAll(destPath, 0755); err != nil {
 				return fmt.Errorf("failed to create directory: %w", err)
 			}
+			dirs.add(destPath, header.FileInfo().Mode(), header.ModTime, opts)
 
 		case tar.TypeReg:
 			if header.Size < 0 {
 				return fmt.Errorf("invalid file size for %s", name)
 			}
+			if !admitExisting(ctx, name, destPath, header.ModTime, opts) {
+				continue
+			}
+			if err := checkFileSize(name, header.Size, opts); err != nil {
+				return err
+			}
 			if opts.MaxBytes > 0 && extracted+header.Size > opts.MaxBytes {
-				return fmt.Errorf("extraction exceeded maximum size limit of %s", util.HumanReadableBytes(opts.MaxBytes))
+				return fmt.Errorf("%w of %s", ErrMaxBytes, util.HumanReadableBytes(opts.MaxBytes))
+			}
+
+			h, err := opts.manifest.hash(name)
+			if err != nil {
+				return err
 			}
 
-			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			if err := root.mkdirAll(filepath.Dir(destPath), 0755); err != nil {
 				return fmt.Errorf("failed to create parent directory: %w", err)
 			}
 
-			outFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			outFile, err := root.openFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 			if err != nil {
 				return fmt.Errorf("failed to create file: %w", err)
 			}
@@ -124,7 +206,7 @@ func extractTar(ctx context.Context, tracker *cleanup.Tracker, r io.Reader, opts
 				tracker.Register(destPath)
 			}
 
-			written, err := copyWithContext(ctx, outFile, tr, header.Size)
+			written, err := copyWithContext(ctx, opts.manifest.writer(opts.stats.fileWriter(outFile), h), tr, header.Size)
 			if err == io.EOF {
 				err = nil // CopyN returns EOF when source has fewer bytes than limit
 			}
@@ -143,40 +225,55 @@ func extractTar(ctx context.Context, tracker *cleanup.Tracker, r io.Reader, opts
 			}
 			extracted += written
 			if opts.MaxBytes > 0 && extracted > opts.MaxBytes {
-				os.Remove(destPath)
+				root.remove(destPath)
 				if tracker != nil {
 					tracker.Unregister(destPath)
 				}
-				return fmt.Errorf("extraction exceeded maximum size limit of %s", util.HumanReadableBytes(opts.MaxBytes))
+				return fmt.Errorf("%w of %s", ErrMaxBytes, util.HumanReadableBytes(opts.MaxBytes))
+			}
+			if err := opts.manifest.verify(name, h); err != nil {
+				return err
 			}
 
-			// Preserve executable bit if set in archive
-			if header.Mode&0111 != 0 {
-				if err := os.Chmod(destPath, 0755); err != nil {
-					return fmt.Errorf("failed to set executable permission: %w", err)
-				}
+			if err := applyFileMode(destPath, header.FileInfo().Mode(), opts); err != nil {
+				return err
+			}
+			if err := applyFileTime(destPath, header.ModTime, opts); err != nil {
+				return err
 			}
 
 		case tar.TypeSymlink:
 			// Do NOT apply strip-components to symlink targets.
 			// Symlink targets are relative to the symlink's filesystem location,
 			// not relative to the archive root structure.
-			linkname := header.Linkname
+			linkname, err := entryName(ctx, header.Linkname, opts)
+			if err != nil {
+				return err
+			}
+			if !admitExisting(ctx, name, destPath, header.ModTime, opts) {
+				continue
+			}
 
 			// Validate symlink target doesn't escape (including ancestor symlinks)
 			targetPath := filepath.Join(filepath.Dir(destPath), linkname)
 			if _, err := util.ResolvePathWithinBase(targetPath, destDir); err != nil {
 				return fmt.Errorf("symlink escape detected: %s -> %s: %w", name, linkname, err)
 			}
+			if ok, err := admitLink(ctx, name, destPath, targetPath, false, &copies, opts); !ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
 
 			// Remove existing symlink if present
-			os.Remove(destPath)
+			root.remove(destPath)
 
-			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			if err := root.mkdirAll(filepath.Dir(destPath), 0755); err != nil {
 				return fmt.Errorf("failed to create parent directory for symlink: %w", err)
 			}
 
-			if err := os.Symlink(linkname, destPath); err != nil {
+			if err := root.symlink(linkname, destPath); err != nil {
 				return fmt.Errorf("failed to create symlink: %w", err)
 			}
 			// Register symlink for cleanup
@@ -190,19 +287,42 @@ func extractTar(ctx context.Context, tracker *cleanup.Tracker, r io.Reader, opts
 			if linkname == "" {
 				continue // Skip hard links with invalid targets after stripping
 			}
+			// The target must have been admitted too, or the link would dangle
+			if ok, err := admitEntry(ctx, linkname, false, opts); !ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if linkname, err = entryName(ctx, linkname, opts); err != nil {
+				return err
+			}
+			if !admitExisting(ctx, name, destPath, header.ModTime, opts) {
+				continue
+			}
 
-			// Hard links - validate target exists within destDir (including symlink walk)
+			// Hard links - the target must be within destDir; the root
+			// resolves its symlinks when the link is created
 			linkTarget := filepath.Join(destDir, linkname)
-			if _, err := util.ResolvePathWithinBase(linkTarget, destDir); err != nil {
-				return fmt.Errorf("hard link escape detected: %s -> %s: %w", name, linkname, err)
+			if !util.IsPathSafe(linkTarget, destDir) {
+				return fmt.Errorf("hard link escape detected: %s -> %s", name, linkname)
+			}
+			if ok, err := admitLink(ctx, name, destPath, linkTarget, true, &copies, opts); !ok {
+				if err != nil {
+					return err
+				}
+				continue
 			}
 
-			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			if err := root.mkdirAll(filepath.Dir(destPath), 0755); err != nil {
 				return fmt.Errorf("failed to create parent directory for hard link: %w", err)
 			}
 
-			if _, err := os.Stat(linkTarget); err == nil {
-				if err := os.Link(linkTarget, destPath); err != nil {
+			if _, err := root.stat(linkTarget); err == nil {
+				if err := removeForLink(root, destPath); err != nil {
+					return err
+				}
+				if err := root.link(linkTarget, destPath); err != nil {
 					return fmt.Errorf("failed to create hard link: %w", err)
 				}
 				// Register hard link for cleanup
@@ -223,22 +343,19 @@ func extractTar(ctx context.Context, tracker *cleanup.Tracker, r io.Reader, opts
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
-		if _, err := util.ResolvePathWithinBase(pl.destPath, destDir); err != nil {
-			return fmt.Errorf("hard link escape detected (deferred dest): %w", err)
-		}
-		if _, err := util.ResolvePathWithinBase(pl.linkTarget, destDir); err != nil {
-			return fmt.Errorf("hard link escape detected (deferred target): %w", err)
-		}
-		if err := os.MkdirAll(filepath.Dir(pl.destPath), 0755); err != nil {
+		if err := root.mkdirAll(filepath.Dir(pl.destPath), 0755); err != nil {
 			return fmt.Errorf("failed to create parent directory for hard link: %w", err)
 		}
-		if _, err := os.Stat(pl.linkTarget); err != nil {
+		if _, err := root.stat(pl.linkTarget); err != nil {
 			if errors.Is(err, os.ErrNotExist) {
 				return fmt.Errorf("hard link target not found: %s", pl.linkTarget)
 			}
 			return fmt.Errorf("failed to stat hard link target: %w", err)
 		}
-		if err := os.Link(pl.linkTarget, pl.destPath); err != nil {
+		if err := removeForLink(root, pl.destPath); err != nil {
+			return err
+		}
+		if err := root.link(pl.linkTarget, pl.destPath); err != nil {
 			return fmt.Errorf("failed to create hard link: %w", err)
 		}
 		// Register hard link for cleanup
@@ -247,5 +364,8 @@ func extractTar(ctx context.Context, tracker *cleanup.Tracker, r io.Reader, opts
 		}
 	}
 
-	return nil
+	if err := copies.apply(ctx, tracker, root, &extracted, opts); err != nil {
+		return err
+	}
+	return dirs.apply(opts)
 }
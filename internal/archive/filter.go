@@ -0,0 +1,119 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// admitEntry applies AllowPaths and MaxDepth to an entry name (after
+// strip-components). It returns false for an entry to skip, or an error
+// wrapping ErrEntryNotAllowed unless opts.Lenient is set.
+func admitEntry(ctx context.Context, name string, dir bool, opts ExtractOptions) (bool, error) {
+	name = strings.Trim(path.Clean("/"+strings.ReplaceAll(name, `\`, "/")), "/")
+	reason := ""
+	switch {
+	case opts.MaxDepth > 0 && strings.Count(name, "/")+1 > opts.MaxDepth:
+		reason = fmt.Sprintf("deeper than %d path components", opts.MaxDepth)
+	case len(opts.AllowPaths) > 0 && !allowedPath(opts.AllowPaths, name, dir):
+		reason = "outside the allowed paths"
+	}
+	if reason == "" {
+		return true, nil
+	}
+	if !opts.Lenient {
+		return false, fmt.Errorf("%w: %s is %s", ErrEntryNotAllowed, name, reason)
+	}
+	logging.FromContext(ctx).Debug("extract_entry_skipped", "entry", name, "reason", reason)
+	return false, nil
+}
+
+// admitExisting applies opts.Overwrite to an entry whose destination,
+// destPath, may already exist. mtime is the entry's timestamp, zero if the
+// archive has none. It returns false for an entry to skip.
+func admitExisting(ctx context.Context, name, destPath string, mtime time.Time, opts ExtractOptions) bool {
+	if opts.Overwrite == OverwriteAlways {
+		return true
+	}
+	info, err := opts.root.lstat(destPath)
+	if err != nil {
+		return true // Missing; any other error surfaces when writing
+	}
+	reason := "already exists"
+	if opts.Overwrite == OverwriteKeepNewer {
+		if mtime.IsZero() || !info.ModTime().After(mtime) {
+			return true
+		}
+		reason = "the existing file is newer"
+	}
+	logging.FromContext(ctx).Debug("extract_entry_skipped", "entry", name, "reason", reason)
+	return false
+}
+
+// checkFileSize applies opts.MaxFileBytes to an entry of size bytes
+func checkFileSize(name string, size int64, opts ExtractOptions) error {
+	if opts.MaxFileBytes > 0 && size > opts.MaxFileBytes {
+		return fmt.Errorf("%w of %s: %s is %s", ErrMaxFileBytes, util.HumanReadableBytes(opts.MaxFileBytes), name, util.HumanReadableBytes(size))
+	}
+	return nil
+}
+
+// allowedPath reports whether name matches one of patterns. A directory is
+// also allowed when a pattern could match something below it, so "bin/**"
+// admits "bin" and "share/doc/*" admits "share".
+func allowedPath(patterns []string, name string, dir bool) bool {
+	segments := strings.Split(name, "/")
+	for _, p := range patterns {
+		if matchSegments(strings.Split(strings.Trim(p, "/"), "/"), segments, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// matches any number of segments and other segments use path.Match syntax.
+// With ancestor set, a name that runs out before the pattern also matches.
+func matchSegments(pattern, name []string, ancestor bool) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		if ancestor {
+			return true
+		}
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:], false) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return ancestor
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:], ancestor)
+}
+
+// ValidateAllowPaths checks the syntax of AllowPaths patterns
+func ValidateAllowPaths(patterns []string) error {
+	for _, p := range patterns {
+		if strings.Trim(p, "/") == "" {
+			return fmt.Errorf("empty pattern")
+		}
+		for _, segment := range strings.Split(strings.Trim(p, "/"), "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
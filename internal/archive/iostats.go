@@ -0,0 +1,118 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// ioStatsInterval is how often extract_io_stats records are logged
+const ioStatsInterval = 2 * time.Second
+
+// extractStats counts the archive bytes read, the bytes they decompress to
+// and the time spent writing files, and logs them at debug level to show
+// whether decompression or the disk limits an extraction
+type extractStats struct {
+	logger       *slog.Logger
+	start        time.Time
+	lastLog      time.Time
+	archiveRead  int64 // Bytes consumed from the archive file
+	decompressed int64 // Bytes of the decompressed tar stream
+	written      int64
+	writeTime    time.Duration
+}
+
+// newExtractStats returns nil unless debug logging is enabled
+func newExtractStats(ctx context.Context) *extractStats {
+	logger := logging.FromContext(ctx)
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return nil
+	}
+	now := time.Now()
+	return &extractStats{logger: logger, start: now, lastLog: now}
+}
+
+// archiveReader counts the bytes read from the archive file
+func (s *extractStats) archiveReader(r io.Reader) io.Reader {
+	if s == nil {
+		return r
+	}
+	return &countingReader{r: r, n: &s.archiveRead}
+}
+
+// streamReader counts the bytes of the decompressed stream
+func (s *extractStats) streamReader(r io.Reader) io.Reader {
+	if s == nil {
+		return r
+	}
+	return &countingReader{r: r, n: &s.decompressed}
+}
+
+// fileWriter times writes to an extracted file
+func (s *extractStats) fileWriter(w io.Writer) io.Writer {
+	if s == nil {
+		return w
+	}
+	return &statsWriter{w: w, s: s}
+}
+
+// finish logs the totals for the whole extraction
+func (s *extractStats) finish() {
+	if s != nil {
+		s.log("extract_io_stats_total")
+	}
+}
+
+func (s *extractStats) log(msg string) {
+	attrs := []any{
+		"elapsed", time.Since(s.start).Round(time.Millisecond).String(),
+		"archive_bytes_read", s.archiveRead,
+		"bytes_written", s.written,
+		"write_time", s.writeTime.Round(time.Millisecond).String(),
+	}
+	if s.writeTime > 0 {
+		attrs = append(attrs, "write_speed", util.HumanReadableBytes(int64(float64(s.written)/s.writeTime.Seconds()))+"/s")
+	}
+	// Zip and ISO entries are not read through one stream, so only tarballs report a ratio
+	if s.decompressed > 0 && s.archiveRead > 0 {
+		attrs = append(attrs,
+			"decompressed_bytes", s.decompressed,
+			"decompression_ratio", float64(int(float64(s.decompressed)/float64(s.archiveRead)*100))/100,
+		)
+	}
+	s.logger.Debug(msg, attrs...)
+}
+
+// countingReader adds the bytes read from r to n
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// statsWriter times writes and logs a record once per ioStatsInterval
+type statsWriter struct {
+	w io.Writer
+	s *extractStats
+}
+
+func (sw *statsWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := sw.w.Write(p)
+	sw.s.writeTime += time.Since(start)
+	sw.s.written += int64(n)
+	if time.Since(sw.s.lastLog) >= ioStatsInterval {
+		sw.s.lastLog = time.Now()
+		sw.s.log("extract_io_stats")
+	}
+	return n, err
+}
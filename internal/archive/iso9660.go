@@ -0,0 +1,640 @@
+package archive
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// ISO 9660 (ECMA-119) layout
+const (
+	isoSectorSize      = 2048
+	isoDescriptorStart = 16 * isoSectorSize
+	isoMagicOffset     = isoDescriptorStart + 1 // "CD001" follows the descriptor type byte
+	isoMaxDescriptors  = 64                     // Real images have a handful before the terminator
+	isoMaxContinuation = 16                     // Rock Ridge CE hops followed per record
+)
+
+// Volume descriptor types
+const (
+	isoPrimaryDescriptor       = 1
+	isoSupplementaryDescriptor = 2
+	isoTerminatorDescriptor    = 255
+)
+
+// Directory record flags
+const (
+	isoFlagDirectory   = 0x02
+	isoFlagMultiExtent = 0x80 // More records of the same file follow
+)
+
+var isoMagic = []byte("CD001")
+
+// isoExtent is one contiguous run of a file's data
+type isoExtent struct {
+	lba    uint32
+	length uint32
+}
+
+// isoEntry is a file, directory or symlink resolved from the image
+type isoEntry struct {
+	path    string // Slash-separated path inside the image
+	dir     bool
+	extents []isoExtent
+	size    int64
+	mode    uint32 // Rock Ridge POSIX mode, 0 if the image has none
+	modTime time.Time
+	symlink string // Rock Ridge symlink target
+}
+
+// isoImage reads directory trees from an ISO 9660 image
+type isoImage struct {
+	f         *os.File
+	size      int64
+	blockSize int64
+	rockRidge bool
+	suspSkip  int // Bytes to skip at the start of each System Use area (SUSP "SP")
+	joliet    bool
+}
+
+// extractISO9660 extracts the files of an ISO 9660 disk image. Rock Ridge
+// names, modes and symlinks are used when present, then Joliet names, then
+// the plain ISO 9660 names without their ";1" version suffix.
+func extractISO9660(ctx context.Context, tracker *cleanup.Tracker, path string, opts ExtractOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open disk image: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat disk image: %w", err)
+	}
+
+	img := &isoImage{f: f, size: info.Size(), blockSize: isoSectorSize}
+	root, err := img.readDescriptors()
+	if err != nil {
+		return err
+	}
+	entries, err := img.walk(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	dest, err := openRoot(&opts)
+	if err != nil {
+		return err
+	}
+	defer dest.close()
+
+	if opts.Progress != nil {
+		var total int64
+		for _, e := range entries {
+			if !e.dir && e.symlink == "" {
+				total += e.size
+			}
+		}
+		startProgress(total, opts)
+	}
+
+	var extracted int64
+	var copies pendingCopies
+	var dirs pendingDirs
+	for _, e := range entries {
+		// Check for cancellation before processing each entry
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := img.extractEntry(ctx, tracker, e, dest, opts, &extracted, &copies, &dirs); err != nil {
+			return err
+		}
+	}
+	if err := copies.apply(ctx, tracker, dest, &extracted, opts); err != nil {
+		return err
+	}
+	return dirs.apply(opts)
+}
+
+// readDescriptors scans the volume descriptors and returns the root
+// directory of the tree with the best names: Rock Ridge, Joliet, then plain
+func (img *isoImage) readDescriptors() (isoEntry, error) {
+	var primary, joliet []byte
+	buf := make([]byte, isoSectorSize)
+	for i := 0; i < isoMaxDescriptors; i++ {
+		if _, err := img.f.ReadAt(buf, isoDescriptorStart+int64(i)*isoSectorSize); err != nil {
+			return isoEntry{}, fmt.Errorf("iso9660: failed to read volume descriptor: %w", err)
+		}
+		if string(buf[1:6]) != string(isoMagic) {
+			return isoEntry{}, fmt.Errorf("iso9660: invalid volume descriptor %d", i)
+		}
+		switch buf[0] {
+		case isoPrimaryDescriptor:
+			if primary == nil {
+				primary = append([]byte(nil), buf...)
+			}
+		case isoSupplementaryDescriptor:
+			// Joliet is a supplementary descriptor with a UCS-2 escape sequence
+			switch string(buf[88:91]) {
+			case "%/@", "%/C", "%/E":
+				if joliet == nil {
+					joliet = append([]byte(nil), buf...)
+				}
+			}
+		}
+		if buf[0] == isoTerminatorDescriptor {
+			break
+		}
+	}
+	if primary == nil {
+		return isoEntry{}, fmt.Errorf("iso9660: no primary volume descriptor")
+	}
+
+	switch bs := int64(binary.LittleEndian.Uint16(primary[128:130])); bs {
+	case 512, 1024, 2048:
+		img.blockSize = bs
+	default:
+		return isoEntry{}, fmt.Errorf("iso9660: unsupported logical block size %d", bs)
+	}
+
+	root, err := img.rootRecord(primary)
+	if err != nil {
+		return isoEntry{}, err
+	}
+	if img.detectRockRidge(root) {
+		return root, nil
+	}
+	if joliet != nil {
+		img.joliet = true
+		return img.rootRecord(joliet)
+	}
+	return root, nil
+}
+
+// rootRecord parses the root directory record embedded in a volume descriptor
+func (img *isoImage) rootRecord(descriptor []byte) (isoEntry, error) {
+	rec := descriptor[156 : 156+34]
+	lba := binary.LittleEndian.Uint32(rec[2:6]) + uint32(rec[1])
+	length := binary.LittleEndian.Uint32(rec[10:14])
+	if err := img.checkExtent(lba, length); err != nil {
+		return isoEntry{}, err
+	}
+	return isoEntry{dir: true, extents: []isoExtent{{lba, length}}, size: int64(length)}, nil
+}
+
+// detectRockRidge looks for the SUSP "SP" entry in the root's "." record,
+// which announces Rock Ridge extensions on the primary tree
+func (img *isoImage) detectRockRidge(root isoEntry) bool {
+	buf := make([]byte, isoSectorSize)
+	if _, err := img.f.ReadAt(buf, int64(root.extents[0].lba)*img.blockSize); err != nil {
+		return false
+	}
+	recLen := int(buf[0])
+	if recLen < 34+7 {
+		return false
+	}
+	// "." has a one-byte identifier plus a padding byte
+	su := buf[34:recLen]
+	if len(su) >= 7 && string(su[0:2]) == "SP" && su[4] == 0xBE && su[5] == 0xEF {
+		img.rockRidge = true
+		img.suspSkip = int(su[6])
+		return true
+	}
+	return false
+}
+
+// checkExtent rejects extents that point outside the image. Empty files and
+// symlinks may carry any location, so they are not checked.
+func (img *isoImage) checkExtent(lba, length uint32) error {
+	if length > 0 && int64(lba)*img.blockSize+int64(length) > img.size {
+		return fmt.Errorf("iso9660: extent at block %d is beyond the end of the image", lba)
+	}
+	return nil
+}
+
+// walk lists every entry below root, parents before children. Each
+// directory extent is read once, so a malicious image cannot loop.
+func (img *isoImage) walk(ctx context.Context, root isoEntry) ([]isoEntry, error) {
+	var entries []isoEntry
+	visited := map[uint32]bool{root.extents[0].lba: true}
+	queue := []isoEntry{root}
+	for len(queue) > 0 {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		dir := queue[0]
+		queue = queue[1:]
+
+		children, err := img.readDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			if child.dir {
+				lba := child.extents[0].lba
+				if visited[lba] {
+					return nil, fmt.Errorf("iso9660: directory loop at %s", child.path)
+				}
+				visited[lba] = true
+				queue = append(queue, child)
+			}
+			entries = append(entries, child)
+		}
+	}
+	return entries, nil
+}
+
+// readDir parses the records of one directory
+func (img *isoImage) readDir(dir isoEntry) ([]isoEntry, error) {
+	data := make([]byte, dir.size)
+	if _, err := img.f.ReadAt(data, int64(dir.extents[0].lba)*img.blockSize); err != nil {
+		return nil, fmt.Errorf("iso9660: failed to read directory %s: %w", dir.path, err)
+	}
+
+	var entries []isoEntry
+	var pending *isoEntry // Multi-extent file still collecting records
+	for pos := 0; pos < len(data); {
+		recLen := int(data[pos])
+		if recLen == 0 {
+			// Records never cross a sector boundary; the rest of the sector is padding
+			pos = (pos/isoSectorSize + 1) * isoSectorSize
+			continue
+		}
+		if recLen < 34 || pos+recLen > len(data) {
+			return nil, fmt.Errorf("iso9660: malformed directory record in %s", dir.path)
+		}
+		rec := data[pos : pos+recLen]
+		pos += recLen
+
+		nameLen := int(rec[32])
+		if 33+nameLen > recLen {
+			return nil, fmt.Errorf("iso9660: malformed directory record in %s", dir.path)
+		}
+		ident := rec[33 : 33+nameLen]
+		if nameLen == 1 && (ident[0] == 0 || ident[0] == 1) {
+			continue // "." and ".."
+		}
+
+		lba := binary.LittleEndian.Uint32(rec[2:6]) + uint32(rec[1])
+		length := binary.LittleEndian.Uint32(rec[10:14])
+		flags := rec[25]
+		if err := img.checkExtent(lba, length); err != nil {
+			return nil, err
+		}
+
+		if pending != nil {
+			pending.extents = append(pending.extents, isoExtent{lba, length})
+			pending.size += int64(length)
+			if flags&isoFlagMultiExtent == 0 {
+				entries = append(entries, *pending)
+				pending = nil
+			}
+			continue
+		}
+
+		e := isoEntry{
+			dir:     flags&isoFlagDirectory != 0,
+			extents: []isoExtent{{lba, length}},
+			size:    int64(length),
+			modTime: isoRecordTime(rec[18:25]),
+		}
+		name := img.decodeName(ident, e.dir)
+		if img.rockRidge {
+			suStart := 33 + nameLen
+			if nameLen%2 == 0 {
+				suStart++ // Padding keeps the System Use area at an even offset
+			}
+			rr, err := img.parseRockRidge(rec, suStart)
+			if err != nil {
+				return nil, fmt.Errorf("iso9660: %s: %w", name, err)
+			}
+			if rr.relocated {
+				continue // Listed again where its CL child link points
+			}
+			if rr.name != "" {
+				name = rr.name
+			}
+			e.mode = rr.mode
+			e.symlink = rr.symlink
+			if rr.childLink != 0 {
+				// Deep directory relocated by mkisofs; its length is in its own "." record
+				length, err := img.dotLength(rr.childLink)
+				if err != nil {
+					return nil, err
+				}
+				e.dir = true
+				e.extents = []isoExtent{{rr.childLink, length}}
+				e.size = int64(length)
+			}
+		}
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+			return nil, fmt.Errorf("iso9660: invalid file name %q in %s", name, dir.path)
+		}
+		e.path = name
+		if dir.path != "" {
+			e.path = dir.path + "/" + name
+		}
+
+		if !e.dir && flags&isoFlagMultiExtent != 0 {
+			pending = &e
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if pending != nil {
+		return nil, fmt.Errorf("iso9660: incomplete multi-extent file %s", pending.path)
+	}
+	return entries, nil
+}
+
+// decodeName converts a directory record identifier to a file name
+func (img *isoImage) decodeName(ident []byte, dir bool) string {
+	var name string
+	if img.joliet {
+		u := make([]uint16, len(ident)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(ident[2*i:])
+		}
+		name = string(utf16.Decode(u))
+	} else {
+		name = string(ident)
+	}
+	if !dir {
+		// "README.TXT;1" -> "README.TXT", "README.;1" -> "README"
+		name, _, _ = strings.Cut(name, ";")
+		name = strings.TrimSuffix(name, ".")
+	}
+	return name
+}
+
+// dotLength returns the directory length stored in the "." record at lba
+func (img *isoImage) dotLength(lba uint32) (uint32, error) {
+	rec := make([]byte, 34)
+	if _, err := img.f.ReadAt(rec, int64(lba)*img.blockSize); err != nil {
+		return 0, fmt.Errorf("iso9660: failed to read relocated directory: %w", err)
+	}
+	length := binary.LittleEndian.Uint32(rec[10:14])
+	if err := img.checkExtent(lba, length); err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// rockRidgeInfo holds the Rock Ridge fields of one directory record
+type rockRidgeInfo struct {
+	name      string
+	mode      uint32
+	symlink   string
+	childLink uint32 // CL: extent of a relocated directory
+	relocated bool   // RE: this is the relocated copy of a directory
+}
+
+// parseRockRidge reads the SUSP entries of a record's System Use area,
+// following CE continuation areas
+func (img *isoImage) parseRockRidge(rec []byte, suStart int) (rockRidgeInfo, error) {
+	var rr rockRidgeInfo
+	var name, link strings.Builder
+	linkContinues := false
+
+	area := []byte(nil)
+	if suStart+img.suspSkip < len(rec) {
+		area = rec[suStart+img.suspSkip:]
+	}
+	for hops := 0; area != nil; hops++ {
+		var next []byte
+		for len(area) >= 4 {
+			sig, entryLen := string(area[0:2]), int(area[2])
+			if entryLen < 4 || entryLen > len(area) {
+				break
+			}
+			body := area[4:entryLen]
+			area = area[entryLen:]
+
+			switch sig {
+			case "NM":
+				// Flags 0x02/0x04 mean "." and ".."; 0x01 continues the name in the next NM
+				if len(body) >= 1 && body[0]&0x06 == 0 {
+					name.Write(body[1:])
+				}
+			case "PX":
+				if len(body) >= 4 {
+					rr.mode = binary.LittleEndian.Uint32(body[0:4])
+				}
+			case "SL":
+				if len(body) >= 1 {
+					linkContinues = appendSymlinkComponents(&link, body[1:], linkContinues)
+				}
+			case "CL":
+				if len(body) >= 4 {
+					rr.childLink = binary.LittleEndian.Uint32(body[0:4])
+				}
+			case "RE":
+				rr.relocated = true
+			case "CE":
+				if len(body) >= 24 {
+					block := binary.LittleEndian.Uint32(body[0:4])
+					offset := binary.LittleEndian.Uint32(body[8:12])
+					length := binary.LittleEndian.Uint32(body[16:20])
+					if length > uint32(img.blockSize) || offset >= uint32(img.blockSize) {
+						return rr, fmt.Errorf("invalid Rock Ridge continuation area")
+					}
+					next = make([]byte, length)
+					if _, err := img.f.ReadAt(next, int64(block)*img.blockSize+int64(offset)); err != nil {
+						return rr, fmt.Errorf("failed to read Rock Ridge continuation area: %w", err)
+					}
+				}
+			case "ST":
+				area = nil
+			}
+		}
+		if next != nil && hops >= isoMaxContinuation {
+			return rr, fmt.Errorf("too many Rock Ridge continuation areas")
+		}
+		area = next
+	}
+
+	rr.name = name.String()
+	if link.Len() > 0 {
+		rr.symlink = link.String()
+		if link.Len() > maxSymlinkTarget {
+			return rr, fmt.Errorf("symlink target too long (limit %d bytes)", maxSymlinkTarget)
+		}
+	}
+	return rr, nil
+}
+
+// appendSymlinkComponents decodes the component records of an SL entry.
+// It reports whether the last component continues in the next SL entry.
+func appendSymlinkComponents(b *strings.Builder, comps []byte, continues bool) bool {
+	for len(comps) >= 2 {
+		flags, n := comps[0], int(comps[1])
+		if 2+n > len(comps) {
+			break
+		}
+		content := string(comps[2 : 2+n])
+		comps = comps[2+n:]
+
+		if b.Len() > 0 && !continues && !strings.HasSuffix(b.String(), "/") {
+			b.WriteByte('/')
+		}
+		switch {
+		case flags&0x02 != 0:
+			b.WriteString(".")
+		case flags&0x04 != 0:
+			b.WriteString("..")
+		case flags&0x08 != 0:
+			b.WriteString("/")
+		default:
+			b.WriteString(content)
+		}
+		continues = flags&0x01 != 0
+	}
+	return continues
+}
+
+// extractEntry writes one entry below root
+func (img *isoImage) extractEntry(ctx context.Context, tracker *cleanup.Tracker, e isoEntry, root *extractRoot, opts ExtractOptions, extracted *int64, copies *pendingCopies, dirs *pendingDirs) error {
+	// Apply strip-components
+	name := util.StripPathComponents(e.path, opts.StripComponents)
+	if name == "" {
+		return nil // Skip entries that are entirely stripped
+	}
+	if ok, err := admitEntry(ctx, name, e.dir, opts); !ok {
+		return err
+	}
+	name, err := entryName(ctx, name, opts)
+	if err != nil {
+		return err
+	}
+
+	// Path traversal protection. The root enforces it; this reports it clearly.
+	destDir := root.dir
+	destPath := filepath.Join(destDir, name)
+	if !util.IsPathSafe(destPath, destDir) {
+		return fmt.Errorf("iso9660 path traversal detected: %s", name)
+	}
+
+	if e.dir {
+		if err := root.mkdirAll(destPath, 0755); err != nil {
+			return err
+		}
+		dirs.add(destPath, e.fileMode(), e.modTime, opts)
+		return nil
+	}
+
+	if !admitExisting(ctx, name, destPath, e.modTime, opts) {
+		if opts.Progress != nil && e.symlink == "" {
+			opts.Progress.Update(e.size)
+		}
+		return nil
+	}
+
+	if e.symlink != "" {
+		linkname, err := entryName(ctx, e.symlink, opts)
+		if err != nil {
+			return err
+		}
+		// Validate symlink target doesn't escape
+		targetPath := filepath.Join(filepath.Dir(destPath), linkname)
+		if _, err := util.ResolvePathWithinBase(targetPath, destDir); err != nil {
+			return fmt.Errorf("symlink escape detected: %s -> %s: %w", name, linkname, err)
+		}
+		if ok, err := admitLink(ctx, name, destPath, targetPath, false, copies, opts); !ok {
+			return err
+		}
+		if err := root.mkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return fmt.Errorf("failed to create parent directory for symlink: %w", err)
+		}
+		if err := root.remove(destPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove existing path for symlink: %w", err)
+		}
+		if err := root.symlink(linkname, destPath); err != nil {
+			return fmt.Errorf("failed to create symlink: %w", err)
+		}
+		// Register symlink for cleanup
+		if tracker != nil {
+			tracker.Register(destPath)
+		}
+		return nil
+	}
+
+	if err := root.mkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := checkFileSize(name, e.size, opts); err != nil {
+		return err
+	}
+	if opts.MaxBytes > 0 && *extracted+e.size > opts.MaxBytes {
+		return fmt.Errorf("%w of %s", ErrMaxBytes, util.HumanReadableBytes(opts.MaxBytes))
+	}
+	h, err := opts.manifest.hash(name)
+	if err != nil {
+		return err
+	}
+
+	outFile, err := root.openFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	// Register file for cleanup immediately after creation
+	if tracker != nil {
+		tracker.Register(destPath)
+	}
+
+	readers := make([]io.Reader, 0, len(e.extents))
+	for _, ext := range e.extents {
+		readers = append(readers, io.NewSectionReader(img.f, int64(ext.lba)*img.blockSize, int64(ext.length)))
+	}
+	var src io.Reader = io.MultiReader(readers...)
+	if opts.Progress != nil {
+		src = &progressReader{r: src, bar: opts.Progress}
+	}
+	written, err := copyWithContext(ctx, opts.manifest.writer(opts.stats.fileWriter(outFile), h), src, e.size)
+	if closeErr := outFile.Close(); closeErr != nil && err == nil {
+		return fmt.Errorf("failed to close file: %w", closeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if written != e.size {
+		return fmt.Errorf("incomplete file %s: wrote %d of %d bytes", name, written, e.size)
+	}
+	*extracted += written
+	if err := opts.manifest.verify(name, h); err != nil {
+		return err
+	}
+
+	if err := applyFileMode(destPath, e.fileMode(), opts); err != nil {
+		return err
+	}
+	return applyFileTime(destPath, e.modTime, opts)
+}
+
+// isoRecordTime decodes the 7-byte recording time of a directory record:
+// years since 1900, month, day, hour, minute, second and the offset from
+// GMT in 15-minute steps. An all-zero time means none was recorded.
+func isoRecordTime(b []byte) time.Time {
+	if b[0] == 0 && b[1] == 0 && b[2] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// fileMode converts the Rock Ridge POSIX mode of e, 0 if it has none
+func (e *isoEntry) fileMode() os.FileMode {
+	mode := os.FileMode(e.mode & 0777)
+	if e.mode&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
@@ -0,0 +1,201 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// Link policies accepted by ExtractOptions.Links
+const (
+	LinksKeep        = "keep"
+	LinksError       = "error"
+	LinksSkip        = "skip"
+	LinksDereference = "dereference"
+)
+
+// LinkPolicies lists the supported link policies in display order
+var LinkPolicies = []string{LinksKeep, LinksError, LinksSkip, LinksDereference}
+
+// ValidateLinkPolicy returns an error if policy is not a supported link policy
+func ValidateLinkPolicy(policy string) error {
+	for _, p := range LinkPolicies {
+		if policy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported link policy %q: must be one of %s", policy, strings.Join(LinkPolicies, ", "))
+}
+
+// admitLink applies opts.Links to a symlink or hard link entry whose
+// destination and target have been checked to stay within the extraction
+// directory. It returns false for a link not to create: skipped, or
+// recorded in copies to be replaced by a copy of its target.
+func admitLink(ctx context.Context, name, destPath, targetPath string, hard bool, copies *pendingCopies, opts ExtractOptions) (bool, error) {
+	kind := "symlink"
+	if hard {
+		kind = "hard link"
+	}
+	switch opts.Links {
+	case LinksError:
+		return false, fmt.Errorf("%w: %s is a %s", ErrEntryNotAllowed, name, kind)
+	case LinksSkip:
+		logging.FromContext(ctx).Debug("extract_entry_skipped", "entry", name, "reason", "is a "+kind)
+		return false, nil
+	case LinksDereference:
+		*copies = append(*copies, linkCopy{name: name, destPath: destPath, target: targetPath})
+		return false, nil
+	}
+	return true, nil
+}
+
+// linkCopy is a link entry to materialize as a copy of its target
+type linkCopy struct {
+	name     string
+	destPath string
+	target   string
+}
+
+// pendingCopies collects the links of an extraction with LinksDereference
+type pendingCopies []linkCopy
+
+// apply replaces each recorded link by a copy of its target, once all
+// entries are written. A target that is itself a dereferenced link is
+// copied once its own copy exists, and a directory once the links inside
+// it are copies, so chains resolve in any order. Copies count toward
+// MaxBytes and MaxFileBytes like extracted files.
+func (c pendingCopies) apply(ctx context.Context, tracker *cleanup.Tracker, root *extractRoot, extracted *int64, opts ExtractOptions) error {
+	pending := c
+	for len(pending) > 0 {
+		var next pendingCopies
+		for i, lc := range pending {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if _, err := root.lstat(lc.target); errors.Is(err, os.ErrNotExist) || pending.hasLinkBelow(lc.target, i) {
+				next = append(next, lc)
+				continue
+			}
+			if err := lc.copy(ctx, tracker, root, extracted, opts); err != nil {
+				return err
+			}
+			pending[i].destPath = "" // Done
+		}
+		if len(next) == len(pending) {
+			return fmt.Errorf("link target not found: %s -> %s", next[0].name, next[0].target)
+		}
+		pending = next
+	}
+	return nil
+}
+
+// hasLinkBelow reports whether a pending link other than c[skip] is
+// located below dir
+func (c pendingCopies) hasLinkBelow(dir string, skip int) bool {
+	for i, lc := range c {
+		if i != skip && lc.destPath != "" && util.IsPathSafe(lc.destPath, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// copy writes a copy of the link's target, a file or a directory tree, at
+// the link's destination. root resolves the target, so it cannot lead out
+// of the extraction directory.
+func (lc linkCopy) copy(ctx context.Context, tracker *cleanup.Tracker, root *extractRoot, extracted *int64, opts ExtractOptions) error {
+	info, err := root.stat(lc.target)
+	if err != nil {
+		return fmt.Errorf("failed to stat link target: %w", err)
+	}
+	if !info.IsDir() {
+		return copyExtractedFile(ctx, tracker, lc.name, lc.target, lc.destPath, extracted, opts)
+	}
+	if util.IsPathSafe(lc.destPath, lc.target) {
+		return fmt.Errorf("cannot dereference %s: it links to its own ancestor", lc.name)
+	}
+
+	return root.walkDir(lc.target, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(lc.target, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(lc.destPath, rel)
+		switch {
+		case d.IsDir():
+			return root.mkdirAll(dst, 0755)
+		case d.Type().IsRegular():
+			return copyExtractedFile(ctx, tracker, filepath.Join(lc.name, rel), path, dst, extracted, opts)
+		default:
+			return nil // Links below the target are dereferenced on their own
+		}
+	})
+}
+
+// copyExtractedFile copies the extracted file src to dst with its mode and
+// modification time
+func copyExtractedFile(ctx context.Context, tracker *cleanup.Tracker, name, src, dst string, extracted *int64, opts ExtractOptions) error {
+	root := opts.root
+	info, err := root.stat(src)
+	if err != nil {
+		return fmt.Errorf("failed to stat link target: %w", err)
+	}
+	if err := checkFileSize(name, info.Size(), opts); err != nil {
+		return err
+	}
+	if opts.MaxBytes > 0 && *extracted+info.Size() > opts.MaxBytes {
+		return fmt.Errorf("%w of %s", ErrMaxBytes, util.HumanReadableBytes(opts.MaxBytes))
+	}
+	h, err := opts.manifest.hash(name)
+	if err != nil {
+		return err
+	}
+
+	in, err := root.open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open link target: %w", err)
+	}
+	defer in.Close()
+
+	if err := root.mkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	if err := root.remove(dst); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove existing path for link copy: %w", err)
+	}
+	out, err := root.openFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if tracker != nil {
+		tracker.Register(dst)
+	}
+	written, err := copyWithContext(ctx, opts.manifest.writer(out, h), in, info.Size())
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy link target: %w", err)
+	}
+	*extracted += written
+	if err := opts.manifest.verify(name, h); err != nil {
+		return err
+	}
+
+	// The mode above was masked by the umask; match the target exactly
+	if err := root.chmod(dst, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	return applyFileTime(dst, info.ModTime(), opts)
+}
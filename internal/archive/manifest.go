@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"path/filepath"
+	"slices"
+)
+
+// ManifestEntry is the expected digest of an extracted file
+type ManifestEntry struct {
+	Algorithm string // Hash algorithm, such as "sha256"
+	Digest    string // Lowercase hex digest
+	NewHash   func() hash.Hash
+}
+
+// Manifest maps the paths of extracted files, slash-separated and relative
+// to the extraction directory (e.g. "bin/tool"), to their expected digests
+type Manifest map[string]ManifestEntry
+
+// ManifestPath returns name, an entry name or manifest path, in the form
+// used as a Manifest key: slash-separated and cleaned, without a leading
+// "./". It returns "" for names that are absolute or leave the directory.
+func ManifestPath(name string) string {
+	name = path.Clean(filepath.ToSlash(name))
+	if name == "." || path.IsAbs(name) || name == ".." || len(name) > 2 && name[:3] == "../" {
+		return ""
+	}
+	return name
+}
+
+// manifestCheck checks the files of one extraction against a Manifest.
+// Regular files are hashed while they are written, so the extraction stops
+// at the first mismatch; finish checks the listed files that were not
+// written, such as hard links or files kept by the overwrite policy.
+type manifestCheck struct {
+	manifest Manifest
+	checked  map[string]bool
+}
+
+// newManifestCheck returns nil for an empty manifest, which checks nothing
+func newManifestCheck(m Manifest) *manifestCheck {
+	if len(m) == 0 {
+		return nil
+	}
+	return &manifestCheck{manifest: m, checked: make(map[string]bool)}
+}
+
+// hash returns the hash to feed the content of the file extracted as name,
+// before it is created. Files the manifest does not list fail.
+func (m *manifestCheck) hash(name string) (hash.Hash, error) {
+	if m == nil {
+		return nil, nil
+	}
+	entry, ok := m.manifest[ManifestPath(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not listed", ErrManifestMismatch, name)
+	}
+	return entry.NewHash(), nil
+}
+
+// writer tees w into h, if there is one
+func (m *manifestCheck) writer(w io.Writer, h hash.Hash) io.Writer {
+	if h == nil {
+		return w
+	}
+	return io.MultiWriter(w, h)
+}
+
+// verify compares the digest in h, fed with the content of the file
+// extracted as name, with the manifest
+func (m *manifestCheck) verify(name string, h hash.Hash) error {
+	if m == nil || h == nil {
+		return nil
+	}
+	key := ManifestPath(name)
+	entry := m.manifest[key]
+	if got := hex.EncodeToString(h.Sum(nil)); got != entry.Digest {
+		return fmt.Errorf("%w: %s has %s %s, expected %s", ErrManifestMismatch, name, entry.Algorithm, got, entry.Digest)
+	}
+	m.checked[key] = true
+	return nil
+}
+
+// finish checks the listed files that were not verified while written,
+// hashing them from the extraction directory of opts. A listed file that
+// is not a regular file there fails.
+func (m *manifestCheck) finish(opts ExtractOptions) error {
+	if m == nil {
+		return nil
+	}
+	root, err := openRoot(&opts)
+	if err != nil {
+		return err
+	}
+	defer root.close()
+	var names []string
+	for name := range m.manifest {
+		if !m.checked[name] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		filePath := filepath.Join(root.dir, filepath.FromSlash(name))
+		info, err := root.lstat(filePath)
+		if err != nil || !info.Mode().IsRegular() {
+			return fmt.Errorf("%w: %s is listed but was not extracted as a regular file", ErrManifestMismatch, name)
+		}
+		f, err := root.open(filePath)
+		if err != nil {
+			return err
+		}
+		h := m.manifest[name].NewHash()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if err := m.verify(name, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
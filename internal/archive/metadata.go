@@ -0,0 +1,103 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// preservedModeBits are the mode bits PreservePermissions applies. setuid
+// and setgid are dropped: an archive from the network should not be able
+// to create privileged executables.
+const preservedModeBits = os.ModePerm | os.ModeSticky
+
+// withUmask resolves the umask PreservePermissions masks modes with
+func withUmask(opts ExtractOptions) ExtractOptions {
+	if opts.PreservePermissions && !opts.NoUmask {
+		opts.umask = util.Umask()
+	}
+	return opts
+}
+
+// applyFileMode sets the permissions of an extracted file from its archive
+// mode. By default only the executable bit is kept (0755 instead of the
+// 0644 the file was created with); with PreservePermissions the permission
+// bits of mode are applied, masked by the umask. A zero mode means the
+// archive has none, and the file is left as created.
+func applyFileMode(path string, mode os.FileMode, opts ExtractOptions) error {
+	if mode == 0 {
+		return nil
+	}
+	if opts.PreservePermissions {
+		if err := opts.root.chmod(path, mode&preservedModeBits&^opts.umask); err != nil {
+			return fmt.Errorf("failed to set permissions: %w", err)
+		}
+		return nil
+	}
+	if mode&0111 != 0 {
+		if err := opts.root.chmod(path, 0755); err != nil {
+			return fmt.Errorf("failed to set executable permission: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyFileTime sets the modification time of an extracted file to its
+// archive timestamp, unless NoMtime is set or the archive has none. The
+// access time is left alone.
+func applyFileTime(path string, mtime time.Time, opts ExtractOptions) error {
+	if opts.NoMtime || mtime.IsZero() {
+		return nil
+	}
+	if err := opts.root.chtimes(path, time.Time{}, mtime); err != nil {
+		return fmt.Errorf("failed to set modification time: %w", err)
+	}
+	return nil
+}
+
+// dirMeta is an extracted directory whose metadata is applied once all
+// entries are written: a read-only directory would block its own content,
+// and writing that content would change its modification time
+type dirMeta struct {
+	path  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+// pendingDirs collects the directories of an extraction
+type pendingDirs []dirMeta
+
+// add records the archive mode and modification time of the directory at
+// path, if either is to be applied
+func (d *pendingDirs) add(path string, mode os.FileMode, mtime time.Time, opts ExtractOptions) {
+	if !opts.PreservePermissions {
+		mode = 0
+	}
+	if opts.NoMtime {
+		mtime = time.Time{}
+	}
+	if mode != 0 || !mtime.IsZero() {
+		*d = append(*d, dirMeta{path: path, mode: mode, mtime: mtime})
+	}
+}
+
+// apply sets the metadata of the recorded directories, deepest first
+func (d pendingDirs) apply(opts ExtractOptions) error {
+	dirs := slices.Clone(d)
+	slices.SortFunc(dirs, func(a, b dirMeta) int { return strings.Compare(b.path, a.path) })
+	for _, dir := range dirs {
+		if dir.mode != 0 {
+			if err := opts.root.chmod(dir.path, dir.mode&preservedModeBits&^opts.umask); err != nil {
+				return fmt.Errorf("failed to set directory permissions: %w", err)
+			}
+		}
+		if err := applyFileTime(dir.path, dir.mtime, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
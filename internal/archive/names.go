@@ -0,0 +1,44 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// sanitizeNames is whether entry names are made valid Windows file names
+// before extraction, rather than failing in the middle of it
+const sanitizeNames = runtime.GOOS == "windows"
+
+// entryName applies the Windows file naming rules to name, an entry name or
+// link target after strip-components. With opts.StrictNames a name breaking
+// them fails the extraction; otherwise, on Windows, the offending path
+// components are renamed (see util.SanitizeWindowsName).
+func entryName(ctx context.Context, name string, opts ExtractOptions) (string, error) {
+	if opts.StrictNames {
+		var problem string
+		util.MapPathComponents(name, func(part string) string {
+			if problem == "" {
+				if p := util.WindowsNameProblem(part); p != "" {
+					problem = fmt.Sprintf("%q %s", part, p)
+				}
+			}
+			return part
+		})
+		if problem != "" {
+			return "", fmt.Errorf("%w: %s is not a valid Windows file name: %s", ErrEntryNotAllowed, name, problem)
+		}
+		return name, nil
+	}
+	if !sanitizeNames {
+		return name, nil
+	}
+	sanitized := util.MapPathComponents(name, util.SanitizeWindowsName)
+	if sanitized != name {
+		logging.FromContext(ctx).Info("extract_entry_renamed", "entry", name, "name", sanitized)
+	}
+	return sanitized, nil
+}
@@ -0,0 +1,107 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// rawSuffixes are the compression suffixes removed from the name of a bare
+// compressed file to name its decompressed content
+var rawSuffixes = []string{".gz", ".gzip", ".bz2", ".xz", ".zst", ".zstd", ".lz4", ".br"}
+
+// rawOutputName names the decompressed content of a bare compressed file:
+// "data.json.gz" becomes "data.json". A name without a compression suffix
+// gets ".out" appended, so the compressed file is never overwritten.
+func rawOutputName(path string) string {
+	base := filepath.Base(path)
+	lower := strings.ToLower(base)
+	for _, suffix := range rawSuffixes {
+		if strings.HasSuffix(lower, suffix) && len(base) > len(suffix) {
+			return base[:len(base)-len(suffix)]
+		}
+	}
+	return base + ".out"
+}
+
+// extractRaw writes the decompressed stream r of the compressed file at
+// path, which does not hold a tar archive, as a single file in the
+// destination directory. opts.MaxBytes bounds the decompressed size.
+func extractRaw(ctx context.Context, tracker *cleanup.Tracker, r io.Reader, path string, opts ExtractOptions) error {
+	root, err := openRoot(&opts)
+	if err != nil {
+		return err
+	}
+	defer root.close()
+	name := rawOutputName(path)
+	if ok, err := admitEntry(ctx, name, false, opts); !ok {
+		return err
+	}
+	destPath := filepath.Join(root.dir, name)
+	if !admitExisting(ctx, name, destPath, time.Time{}, opts) {
+		return nil
+	}
+	h, err := opts.manifest.hash(name)
+	if err != nil {
+		return err
+	}
+
+	outFile, err := root.openFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if tracker != nil {
+		tracker.Register(destPath)
+	}
+
+	limit := int64(math.MaxInt64)
+	if opts.MaxBytes > 0 {
+		limit = opts.MaxBytes
+	}
+	if opts.MaxFileBytes > 0 && opts.MaxFileBytes < limit {
+		limit = opts.MaxFileBytes
+	}
+	r = opts.stats.streamReader(r)
+	written, err := copyWithContext(ctx, opts.manifest.writer(opts.stats.fileWriter(outFile), h), r, limit)
+	if err == nil && written == limit {
+		// Anything left over means the content is larger than allowed
+		if n, _ := r.Read(make([]byte, 1)); n > 0 {
+			if limit == opts.MaxFileBytes {
+				err = fmt.Errorf("%w of %s: %s decompresses to more", ErrMaxFileBytes, util.HumanReadableBytes(opts.MaxFileBytes), name)
+			} else {
+				err = fmt.Errorf("%w of %s", ErrMaxBytes, util.HumanReadableBytes(opts.MaxBytes))
+			}
+		}
+	}
+	if closeErr := outFile.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close file: %w", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	if err := opts.manifest.verify(name, h); err != nil {
+		return err
+	}
+
+	// A compressed release binary is meant to be run, like a downloaded one
+	format, err := DetectExecutable(destPath)
+	if err != nil {
+		return err
+	}
+	if format != "" {
+		if err := root.chmod(destPath, 0755); err != nil {
+			return fmt.Errorf("failed to set executable permission: %w", err)
+		}
+	}
+	logging.FromContext(ctx).Info("raw_decompressed", "file", destPath, "bytes", written, "executable", format)
+	return nil
+}
@@ -0,0 +1,142 @@
+package archive
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// extractRoot is the extraction directory opened as an os.Root. Every file
+// operation of an extraction goes through it, so the kernel keeps them in
+// the directory: a path leaving it through ".." or a symlink fails, even a
+// symlink swapped in after the entry was checked. Its methods take absolute
+// paths below the directory, as the cleanup tracker needs them anyway.
+type extractRoot struct {
+	root *os.Root
+	dir  string // Absolute path of the directory, without symlinks
+}
+
+// openRoot opens the extraction directory of opts as an os.Root and sets
+// it on opts. The caller closes it once the extraction is done.
+func openRoot(opts *ExtractOptions) (*extractRoot, error) {
+	dir, err := destination(*opts)
+	if err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open destination directory: %w", err)
+	}
+	r := &extractRoot{root: root, dir: dir}
+	opts.root = r
+	return r, nil
+}
+
+func (r *extractRoot) close() error {
+	return r.root.Close()
+}
+
+// name returns path relative to the directory, for the os.Root methods
+func (r *extractRoot) name(path string) (string, error) {
+	rel, err := filepath.Rel(r.dir, path)
+	if err != nil || rel != "." && !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s is outside the destination directory", path)
+	}
+	return rel, nil
+}
+
+func (r *extractRoot) openFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	name, err := r.name(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.root.OpenFile(name, flag, perm)
+}
+
+func (r *extractRoot) open(path string) (*os.File, error) {
+	return r.openFile(path, os.O_RDONLY, 0)
+}
+
+func (r *extractRoot) mkdirAll(path string, perm os.FileMode) error {
+	name, err := r.name(path)
+	if err != nil {
+		return err
+	}
+	return r.root.MkdirAll(name, perm)
+}
+
+func (r *extractRoot) remove(path string) error {
+	name, err := r.name(path)
+	if err != nil {
+		return err
+	}
+	return r.root.Remove(name)
+}
+
+// symlink creates path as a symlink to target, which is stored as it is
+func (r *extractRoot) symlink(target, path string) error {
+	name, err := r.name(path)
+	if err != nil {
+		return err
+	}
+	return r.root.Symlink(target, name)
+}
+
+// link creates path as a hard link to target
+func (r *extractRoot) link(target, path string) error {
+	oldname, err := r.name(target)
+	if err != nil {
+		return err
+	}
+	newname, err := r.name(path)
+	if err != nil {
+		return err
+	}
+	return r.root.Link(oldname, newname)
+}
+
+func (r *extractRoot) stat(path string) (os.FileInfo, error) {
+	name, err := r.name(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.root.Stat(name)
+}
+
+func (r *extractRoot) lstat(path string) (os.FileInfo, error) {
+	name, err := r.name(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.root.Lstat(name)
+}
+
+func (r *extractRoot) chmod(path string, mode os.FileMode) error {
+	name, err := r.name(path)
+	if err != nil {
+		return err
+	}
+	return r.root.Chmod(name, mode)
+}
+
+func (r *extractRoot) chtimes(path string, atime, mtime time.Time) error {
+	name, err := r.name(path)
+	if err != nil {
+		return err
+	}
+	return r.root.Chtimes(name, atime, mtime)
+}
+
+// walkDir walks the tree at path like filepath.WalkDir, passing absolute
+// paths to fn
+func (r *extractRoot) walkDir(path string, fn fs.WalkDirFunc) error {
+	name, err := r.name(path)
+	if err != nil {
+		return err
+	}
+	return fs.WalkDir(r.root.FS(), filepath.ToSlash(name), func(p string, d fs.DirEntry, err error) error {
+		return fn(filepath.Join(r.dir, filepath.FromSlash(p)), d, err)
+	})
+}
@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/lucrnz/ripvex/internal/cleanup"
+)
+
+// Streamable reports whether archives of type t can be extracted while they
+// are read, with ExtractStream. Zip and ISO 9660 need random access.
+func Streamable(t Type) bool {
+	switch t {
+	case Tar, Gzip, Bzip2, Xz, Zstd, Lz4, Brotli:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExtractStream extracts an archive of type t read sequentially from r,
+// such as a response body, without storing the archive. A bare compressed
+// file is written under the name derived from name, as Extract would for a
+// file at that path. opts.Progress is not used: the size of a stream is the
+// reader's to report. ExtractStream stops reading at the end of the tar
+// archive; the caller drains r if it must be consumed.
+func ExtractStream(ctx context.Context, tracker *cleanup.Tracker, r io.Reader, name string, t Type, opts ExtractOptions) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !Streamable(t) {
+		return fmt.Errorf("%s archives cannot be extracted from a stream", t)
+	}
+	opts.Progress = nil
+	opts.stats = newExtractStats(ctx)
+	defer opts.stats.finish()
+	opts = withUmask(opts)
+	opts.manifest = newManifestCheck(opts.Manifest)
+
+	if err := extractStream(ctx, tracker, r, name, t, opts); err != nil {
+		return err
+	}
+	return opts.manifest.finish(opts)
+}
+
+// extractStream extracts the tarball or bare compressed file read from r
+func extractStream(ctx context.Context, tracker *cleanup.Tracker, r io.Reader, name string, t Type, opts ExtractOptions) error {
+	r = opts.stats.archiveReader(r)
+	if t == Tar {
+		return extractTar(ctx, tracker, r, opts)
+	}
+	dr, closeFn, err := newDecompressor(t, r, opts)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	isTar, reader := isTarContent(dr)
+	if !isTar {
+		return extractRaw(ctx, tracker, reader, name, opts)
+	}
+	return extractTar(ctx, tracker, reader, opts)
+}
@@ -3,14 +3,15 @@ package archive
 import (
 	"bytes"
 	"compress/bzip2"
-	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"os"
 
-	"github.com/klauspost/compress/zstd"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/pgzip"
 	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/pierrec/lz4/v4"
 	"github.com/ulikunitz/xz"
 )
 
@@ -49,7 +50,7 @@ func extractGzipTar(ctx context.Context, tracker *cleanup.Tracker, path string,
 	}
 	defer f.Close()
 
-	gzr, err := gzip.NewReader(f)
+	gzr, err := newGzipReader(withFileProgress(f, opts))
 	if err != nil {
 		return fmt.Errorf("failed to create gzip reader: %w", err)
 	}
@@ -57,7 +58,7 @@ func extractGzipTar(ctx context.Context, tracker *cleanup.Tracker, path string,
 
 	isTar, reader := isTarContent(gzr)
 	if !isTar {
-		return fmt.Errorf("gzip file does not contain a tar archive")
+		return extractRaw(ctx, tracker, reader, path, opts)
 	}
 
 	return extractTar(ctx, tracker, reader, opts)
@@ -71,10 +72,10 @@ func extractBzip2Tar(ctx context.Context, tracker *cleanup.Tracker, path string,
 	}
 	defer f.Close()
 
-	bzr := bzip2.NewReader(f)
+	bzr := bzip2.NewReader(withFileProgress(f, opts))
 	isTar, reader := isTarContent(bzr)
 	if !isTar {
-		return fmt.Errorf("bzip2 file does not contain a tar archive")
+		return extractRaw(ctx, tracker, reader, path, opts)
 	}
 
 	return extractTar(ctx, tracker, reader, opts)
@@ -88,14 +89,14 @@ func extractXzTar(ctx context.Context, tracker *cleanup.Tracker, path string, op
 	}
 	defer f.Close()
 
-	xzr, err := xz.NewReader(f)
+	xzr, err := xz.NewReader(withFileProgress(f, opts))
 	if err != nil {
 		return fmt.Errorf("failed to create xz reader: %w", err)
 	}
 
 	isTar, reader := isTarContent(xzr)
 	if !isTar {
-		return fmt.Errorf("xz file does not contain a tar archive")
+		return extractRaw(ctx, tracker, reader, path, opts)
 	}
 
 	return extractTar(ctx, tracker, reader, opts)
@@ -109,7 +110,7 @@ func extractZstdTar(ctx context.Context, tracker *cleanup.Tracker, path string,
 	}
 	defer f.Close()
 
-	zstdr, err := zstd.NewReader(f)
+	zstdr, err := newZstdReader(withFileProgress(f, opts), opts.ZstdDict)
 	if err != nil {
 		return fmt.Errorf("failed to create zstd reader: %w", err)
 	}
@@ -117,8 +118,95 @@ func extractZstdTar(ctx context.Context, tracker *cleanup.Tracker, path string,
 
 	isTar, reader := isTarContent(zstdr)
 	if !isTar {
-		return fmt.Errorf("zstd file does not contain a tar archive")
+		return extractRaw(ctx, tracker, reader, path, opts)
 	}
 
 	return extractTar(ctx, tracker, reader, opts)
 }
+
+// extractLz4Tar extracts a .tar.lz4 archive
+func extractLz4Tar(ctx context.Context, tracker *cleanup.Tracker, path string, opts ExtractOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	isTar, reader := isTarContent(lz4.NewReader(withFileProgress(f, opts)))
+	if !isTar {
+		return extractRaw(ctx, tracker, reader, path, opts)
+	}
+
+	return extractTar(ctx, tracker, reader, opts)
+}
+
+// extractBrotliTar extracts a .tar.br archive
+func extractBrotliTar(ctx context.Context, tracker *cleanup.Tracker, path string, opts ExtractOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	isTar, reader := isTarContent(brotli.NewReader(withFileProgress(f, opts)))
+	if !isTar {
+		return extractRaw(ctx, tracker, reader, path, opts)
+	}
+
+	return extractTar(ctx, tracker, reader, opts)
+}
+
+// CompressedTar reports whether head, the leading bytes of a compressed file
+// of type t, decompresses to a tar archive. It returns false when head is too
+// short to tell.
+func CompressedTar(t Type, head []byte) bool {
+	r, closeFn, err := newDecompressor(t, bytes.NewReader(head), ExtractOptions{})
+	if err != nil {
+		return false
+	}
+	defer closeFn()
+	isTar, _ := isTarContent(r)
+	return isTar
+}
+
+// newDecompressor returns a reader decompressing r, which holds data of the
+// compressed type t, and a function releasing the decoder. opts supplies
+// the zstd dictionary.
+func newDecompressor(t Type, r io.Reader, opts ExtractOptions) (io.Reader, func(), error) {
+	switch t {
+	case Gzip:
+		gzr, err := newGzipReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		return gzr, func() { gzr.Close() }, nil
+	case Bzip2:
+		return bzip2.NewReader(r), func() {}, nil
+	case Xz:
+		xzr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create xz reader: %w", err)
+		}
+		return xzr, func() {}, nil
+	case Zstd:
+		zstdr, err := newZstdReader(r, opts.ZstdDict)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		return zstdr, zstdr.Close, nil
+	case Lz4:
+		return lz4.NewReader(r), func() {}, nil
+	case Brotli:
+		return brotli.NewReader(r), func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("%s is not a compression format", t)
+	}
+}
+
+// newGzipReader returns a gzip reader that decompresses ahead of its
+// consumer and checks the CRC in separate goroutines. Deflate streams cannot
+// be split, so this overlaps decompression with writing files rather than
+// spreading it over cores.
+func newGzipReader(r io.Reader) (*pgzip.Reader, error) {
+	return pgzip.NewReader(r)
+}
@@ -0,0 +1,140 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// entryInfo is the name of an archive entry and whether it is a directory
+type entryInfo struct {
+	name string
+	dir  bool
+}
+
+// topLevelDir returns the directory that all entries of the archive at path
+// share as their first path component, such as "project-1.2.3", and the
+// number of path components to strip to remove it, counting leading "."
+// components. It returns 0 when there is no such directory.
+func topLevelDir(ctx context.Context, path string, t Type, opts ExtractOptions) (string, int, error) {
+	entries, err := listEntries(ctx, path, t, opts)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to list archive entries: %w", err)
+	}
+
+	var prefix []string
+	nested := false
+	for _, e := range entries {
+		parts := strings.Split(filepath.ToSlash(e.name), "/")
+		k := 0
+		for k < len(parts) && (parts[k] == "" || parts[k] == ".") {
+			k++
+		}
+		if k == len(parts) {
+			continue // The root itself, like "./"
+		}
+		if prefix == nil {
+			prefix = parts[:k+1]
+		} else if !slices.Equal(prefix, parts[:k+1]) {
+			return "", 0, nil
+		}
+		if strings.Join(parts[k+1:], "") == "" {
+			// The top-level entry itself, which must be a directory
+			if !e.dir {
+				return "", 0, nil
+			}
+			continue
+		}
+		nested = true
+	}
+	if !nested {
+		return "", 0, nil
+	}
+	return prefix[len(prefix)-1], len(prefix), nil
+}
+
+// listEntries reads the names of the entries of the archive at path that
+// extraction would create. A compressed file holding no tar archive has no
+// entries. Compressed tarballs are decompressed once for this, on top of
+// the extraction.
+func listEntries(ctx context.Context, path string, t Type, opts ExtractOptions) ([]entryInfo, error) {
+	var entries []entryInfo
+	switch t {
+	case Zip:
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			entries = append(entries, entryInfo{name: zipEntryName(f, opts), dir: f.FileInfo().IsDir()})
+		}
+
+	case ISO9660:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		img := &isoImage{f: f, size: info.Size(), blockSize: isoSectorSize}
+		root, err := img.readDescriptors()
+		if err != nil {
+			return nil, err
+		}
+		isoEntries, err := img.walk(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range isoEntries {
+			entries = append(entries, entryInfo{name: e.path, dir: e.dir})
+		}
+
+	default:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if t != Tar {
+			dr, closeFn, err := newDecompressor(t, f, opts)
+			if err != nil {
+				return nil, err
+			}
+			defer closeFn()
+			isTar, reader := isTarContent(dr)
+			if !isTar {
+				return nil, nil
+			}
+			r = reader
+		}
+		tr := tar.NewReader(r)
+		for {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("tar read error: %w", err)
+			}
+			switch header.Typeflag {
+			case tar.TypeDir, tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+				entries = append(entries, entryInfo{name: header.Name, dir: header.Typeflag == tar.TypeDir})
+			}
+		}
+	}
+	return entries, nil
+}
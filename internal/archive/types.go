@@ -1,5 +1,23 @@
 package archive
 
+import (
+	"errors"
+	"os"
+
+	"github.com/lucrnz/ripvex/internal/progress"
+)
+
+var (
+	// ErrMaxBytes is returned when extracted content exceeds ExtractOptions.MaxBytes
+	ErrMaxBytes = errors.New("extraction exceeded maximum size limit")
+	// ErrMaxFileBytes is returned for an entry larger than ExtractOptions.MaxFileBytes
+	ErrMaxFileBytes = errors.New("archive entry exceeds maximum file size")
+	// ErrEntryNotAllowed is returned for an entry outside ExtractOptions.AllowPaths or MaxDepth
+	ErrEntryNotAllowed = errors.New("archive entry not allowed")
+	// ErrManifestMismatch is returned for an extracted file that does not match ExtractOptions.Manifest
+	ErrManifestMismatch = errors.New("extracted file does not match manifest")
+)
+
 // Type represents the detected archive format
 type Type int
 
@@ -11,6 +29,9 @@ const (
 	Bzip2 // likely .tar.bz2
 	Xz    // likely .tar.xz
 	Zstd  // likely .tar.zstd
+	ISO9660
+	Lz4    // likely .tar.lz4
+	Brotli // likely .tar.br
 )
 
 func (a Type) String() string {
@@ -27,13 +48,49 @@ func (a Type) String() string {
 		return "xz"
 	case Zstd:
 		return "zstd"
+	case ISO9660:
+		return "iso9660"
+	case Lz4:
+		return "lz4"
+	case Brotli:
+		return "brotli"
 	default:
 		return "unknown"
 	}
 }
 
+// OverwritePolicy decides what happens to an entry whose destination exists
+type OverwritePolicy int
+
+const (
+	OverwriteAlways       OverwritePolicy = iota // Replace existing files
+	OverwriteSkipExisting                        // Keep existing files
+	OverwriteKeepNewer                           // Keep existing files newer than the entry
+)
+
 // ExtractOptions configures archive extraction behavior
 type ExtractOptions struct {
-	StripComponents int // Number of leading path components to strip
-	MaxBytes        int64
+	DestDir             string // Directory to extract into; empty means the working directory
+	StripComponents     int    // Number of leading path components to strip
+	StripTopLevel       bool   // Strip the one directory all entries share, if any; replaces StripComponents
+	MaxBytes            int64
+	MaxFileBytes        int64         // Maximum size of a single entry (0 = unlimited)
+	Progress            *progress.Bar // Optional; Extract sets Total and starts/stops it
+	AllowPaths          []string      // Glob patterns ("bin/**") entries must match after stripping; empty allows all
+	MaxDepth            int           // Maximum number of path components of an entry (0 = unlimited)
+	Lenient             bool          // Skip entries outside AllowPaths or MaxDepth instead of failing
+	PreservePermissions bool          // Apply the permission bits of entries instead of 0644/0755
+	NoUmask             bool          // With PreservePermissions, do not mask modes with the umask
+	NoMtime             bool          // Leave the extraction time as mtime instead of applying entry timestamps
+	Overwrite           OverwritePolicy
+	Links               string   // Link policy: keep (default when empty), error, skip, dereference
+	StrictNames         bool     // Fail on entry names that are not valid Windows file names instead of renaming them on Windows
+	ZipCharset          string   // Charset of zip names without the UTF-8 flag: auto (default when empty), cp437, utf-8, shift-jis
+	ZstdDict            []byte   // zstd dictionary, trained with zstd --train or raw content; see ValidateZstdDict
+	Manifest            Manifest // Expected digests of the extracted files; every regular file must be listed
+
+	stats    *extractStats  // Set by Extract when debug logging is enabled
+	manifest *manifestCheck // Set by Extract from Manifest
+	root     *extractRoot   // Set by each extractor to the opened destination directory
+	umask    os.FileMode    // Set by Extract for PreservePermissions
 }
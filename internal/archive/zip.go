@@ -22,50 +22,75 @@ func extractZip(ctx context.Context, tracker *cleanup.Tracker, path string, opts
 	}
 	defer r.Close()
 
-	destDir, err := filepath.Abs(".")
+	root, err := openRoot(&opts)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
-	}
-	destDir, err = filepath.EvalSymlinks(destDir)
-	if err != nil {
-		return fmt.Errorf("failed to resolve destination path: %w", err)
+		return err
 	}
+	defer root.close()
 
 	var extracted int64
+	var copies pendingCopies
+	var dirs pendingDirs
+	if opts.Progress != nil {
+		var total int64
+		for _, f := range r.File {
+			total += int64(f.UncompressedSize64)
+		}
+		startProgress(total, opts)
+	}
 
 	for _, f := range r.File {
 		// Check for cancellation before processing each entry
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
-		if err := extractZipFile(ctx, tracker, f, destDir, opts, &extracted); err != nil {
+		if err := extractZipFile(ctx, tracker, f, root, opts, &extracted, &copies, &dirs); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	if err := copies.apply(ctx, tracker, root, &extracted, opts); err != nil {
+		return err
+	}
+	return dirs.apply(opts)
 }
 
 // extractZipFile extracts a single file from a ZIP archive
-func extractZipFile(ctx context.Context, tracker *cleanup.Tracker, f *zip.File, destDir string, opts ExtractOptions, extracted *int64) error {
+func extractZipFile(ctx context.Context, tracker *cleanup.Tracker, f *zip.File, root *extractRoot, opts ExtractOptions, extracted *int64, copies *pendingCopies, dirs *pendingDirs) error {
 	// Apply strip-components
-	name := util.StripPathComponents(f.Name, opts.StripComponents)
+	name := util.StripPathComponents(zipEntryName(f, opts), opts.StripComponents)
 	if name == "" {
 		return nil // Skip entries that are entirely stripped
 	}
+	if ok, err := admitEntry(ctx, name, f.FileInfo().IsDir(), opts); !ok {
+		return err
+	}
+	name, err := entryName(ctx, name, opts)
+	if err != nil {
+		return err
+	}
 
-	// Zip slip protection
+	// Zip slip protection. The root enforces it; this reports it clearly.
+	destDir := root.dir
 	destPath := filepath.Join(destDir, name)
 	if !util.IsPathSafe(destPath, destDir) {
 		return fmt.Errorf("zip slip detected: %s", name)
 	}
-	if _, err := util.ResolvePathWithinBase(destPath, destDir); err != nil {
-		return fmt.Errorf("zip path contains unsafe symlink for %s: %w", name, err)
-	}
 
 	// Handle directories
 	if f.FileInfo().IsDir() {
-		return os.MkdirAll(destPath, 0755)
+		if err := root.mkdirAll(destPath, 0755); err != nil {
+			return err
+		}
+		dirs.add(destPath, f.Mode(), f.Modified, opts)
+		return nil
+	}
+
+	if !admitExisting(ctx, name, destPath, f.Modified, opts) {
+		if opts.Progress != nil {
+			opts.Progress.Update(int64(f.UncompressedSize64))
+		}
+		return nil
 	}
 
 	// Handle symlinks
@@ -89,22 +114,32 @@ func extractZipFile(ctx context.Context, tracker *cleanup.Tracker, f *zip.File,
 		// Symlink targets are relative to the symlink's filesystem location,
 		// not relative to the archive root structure.
 		linkname := string(linkTarget)
+		if f.Flags&zipFlagUTF8 == 0 {
+			linkname = decodeZipText(linkname, opts)
+		}
+		linkname, err = entryName(ctx, linkname, opts)
+		if err != nil {
+			return err
+		}
 
 		// Validate symlink target doesn't escape
 		targetPath := filepath.Join(filepath.Dir(destPath), linkname)
 		if _, err := util.ResolvePathWithinBase(targetPath, destDir); err != nil {
 			return fmt.Errorf("symlink escape detected: %s -> %s: %w", name, linkname, err)
 		}
+		if ok, err := admitLink(ctx, name, destPath, targetPath, false, copies, opts); !ok {
+			return err
+		}
 
-		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		if err := root.mkdirAll(filepath.Dir(destPath), 0755); err != nil {
 			return fmt.Errorf("failed to create parent directory for symlink: %w", err)
 		}
 
-		if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+		if err := root.remove(destPath); err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("failed to remove existing path for symlink: %w", err)
 		}
 
-		if err := os.Symlink(linkname, destPath); err != nil {
+		if err := root.symlink(linkname, destPath); err != nil {
 			return fmt.Errorf("failed to create symlink: %w", err)
 		}
 		// Register symlink for cleanup
@@ -115,14 +150,21 @@ func extractZipFile(ctx context.Context, tracker *cleanup.Tracker, f *zip.File,
 	}
 
 	// Create parent directories
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+	if err := root.mkdirAll(filepath.Dir(destPath), 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
 	// Enforce extraction size limit using uncompressed size
 	fileSize := int64(f.UncompressedSize64)
+	if err := checkFileSize(name, fileSize, opts); err != nil {
+		return err
+	}
 	if opts.MaxBytes > 0 && *extracted+fileSize > opts.MaxBytes {
-		return fmt.Errorf("extraction exceeded maximum size limit of %s", util.HumanReadableBytes(opts.MaxBytes))
+		return fmt.Errorf("%w of %s", ErrMaxBytes, util.HumanReadableBytes(opts.MaxBytes))
+	}
+	h, err := opts.manifest.hash(name)
+	if err != nil {
+		return err
 	}
 
 	// Extract file
@@ -132,7 +174,7 @@ func extractZipFile(ctx context.Context, tracker *cleanup.Tracker, f *zip.File,
 	}
 	defer rc.Close()
 
-	outFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	outFile, err := root.openFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
@@ -141,7 +183,11 @@ func extractZipFile(ctx context.Context, tracker *cleanup.Tracker, f *zip.File,
 		tracker.Register(destPath)
 	}
 
-	written, err := copyWithContext(ctx, outFile, rc, fileSize)
+	var src io.Reader = rc
+	if opts.Progress != nil {
+		src = &progressReader{r: rc, bar: opts.Progress}
+	}
+	written, err := copyWithContext(ctx, opts.manifest.writer(opts.stats.fileWriter(outFile), h), src, fileSize)
 	if err == io.EOF {
 		err = nil // CopyN returns EOF when source has fewer bytes than limit
 	}
@@ -159,19 +205,18 @@ func extractZipFile(ctx context.Context, tracker *cleanup.Tracker, f *zip.File,
 	}
 	*extracted += written
 	if opts.MaxBytes > 0 && *extracted > opts.MaxBytes {
-		os.Remove(destPath)
+		root.remove(destPath)
 		if tracker != nil {
 			tracker.Unregister(destPath)
 		}
-		return fmt.Errorf("extraction exceeded maximum size limit of %s", util.HumanReadableBytes(opts.MaxBytes))
+		return fmt.Errorf("%w of %s", ErrMaxBytes, util.HumanReadableBytes(opts.MaxBytes))
 	}
-
-	// Preserve executable bit if set in archive
-	if f.FileInfo().Mode()&0111 != 0 {
-		if err := os.Chmod(destPath, 0755); err != nil {
-			return fmt.Errorf("failed to set executable permission: %w", err)
-		}
+	if err := opts.manifest.verify(name, h); err != nil {
+		return err
 	}
 
-	return nil
+	if err := applyFileMode(destPath, f.Mode(), opts); err != nil {
+		return err
+	}
+	return applyFileTime(destPath, f.Modified, opts)
 }
@@ -0,0 +1,105 @@
+package archive
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// Zip charsets accepted by ExtractOptions.ZipCharset
+const (
+	ZipCharsetAuto     = "auto"
+	ZipCharsetCP437    = "cp437"
+	ZipCharsetUTF8     = "utf-8"
+	ZipCharsetShiftJIS = "shift-jis"
+)
+
+// ZipCharsets lists the supported zip charsets in display order
+var ZipCharsets = []string{ZipCharsetAuto, ZipCharsetCP437, ZipCharsetUTF8, ZipCharsetShiftJIS}
+
+// ValidateZipCharset returns an error if charset is not a supported zip charset
+func ValidateZipCharset(charset string) error {
+	for _, c := range ZipCharsets {
+		if charset == c {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported zip charset %q: must be one of %s", charset, strings.Join(ZipCharsets, ", "))
+}
+
+const (
+	zipFlagUTF8           = 0x800  // General purpose bit 11: name and comment are UTF-8
+	zipUnicodePathExtraID = 0x7075 // Info-ZIP Unicode Path extra field
+)
+
+// zipEntryName returns the name of f as UTF-8. Names flagged as UTF-8 are
+// used as they are. Otherwise an Info-ZIP Unicode Path extra field matching
+// the name is preferred, then the name is decoded from opts.ZipCharset.
+func zipEntryName(f *zip.File, opts ExtractOptions) string {
+	if f.Flags&zipFlagUTF8 != 0 {
+		return f.Name
+	}
+	if name, ok := zipUnicodePath(f.Extra, f.Name); ok {
+		return name
+	}
+	return decodeZipText(f.Name, opts)
+}
+
+// zipUnicodePath returns the name stored in the Info-ZIP Unicode Path extra
+// field of extra, if there is one written for rawName. A field whose CRC
+// does not match was left behind by a tool that renamed the entry.
+func zipUnicodePath(extra []byte, rawName string) (string, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			return "", false
+		}
+		field := extra[:size]
+		extra = extra[size:]
+		if id != zipUnicodePathExtraID || len(field) < 5 || field[0] != 1 {
+			continue
+		}
+		if binary.LittleEndian.Uint32(field[1:]) != crc32.ChecksumIEEE([]byte(rawName)) {
+			continue
+		}
+		if name := string(field[5:]); utf8.ValidString(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// decodeZipText decodes s, a name or symlink target of an entry without the
+// UTF-8 flag, from opts.ZipCharset. auto keeps valid UTF-8, which many tools
+// write without setting the flag, and decodes anything else as CP437, the
+// charset of the zip specification.
+func decodeZipText(s string, opts ExtractOptions) string {
+	var dec *encoding.Decoder
+	switch opts.ZipCharset {
+	case ZipCharsetUTF8:
+		return s
+	case ZipCharsetCP437:
+		dec = charmap.CodePage437.NewDecoder()
+	case ZipCharsetShiftJIS:
+		dec = japanese.ShiftJIS.NewDecoder()
+	default:
+		if utf8.ValidString(s) {
+			return s
+		}
+		dec = charmap.CodePage437.NewDecoder()
+	}
+	decoded, err := dec.String(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
@@ -0,0 +1,48 @@
+package archive
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMaxWindow is the largest window zstd readers accept: 2 GiB, the
+// window of zstd --long=31. The library default of 512 MiB rejects such
+// archives, like the zstd CLI does without --long=31.
+const zstdMaxWindow = 1 << 31
+
+// zstdDictMagic starts dictionaries in the zstd format, as written by
+// zstd --train
+const zstdDictMagic = "\x37\xa4\x30\xec"
+
+// newZstdReader returns a zstd reader decoding up to GOMAXPROCS blocks
+// concurrently, instead of the library default of at most 4, with windows
+// up to zstdMaxWindow and the optional dictionary dict
+func newZstdReader(r io.Reader, dict []byte) (*zstd.Decoder, error) {
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxWindow(zstdMaxWindow)}
+	if dict != nil {
+		opts = append(opts, zstdDictOption(dict))
+	}
+	return zstd.NewReader(r, opts...)
+}
+
+// zstdDictOption loads dict as a dictionary in the zstd format if it has
+// the magic number, and as raw content otherwise. Raw content serves frames
+// without a dictionary ID, such as those of zstd --patch-from.
+func zstdDictOption(dict []byte) zstd.DOption {
+	if len(dict) >= len(zstdDictMagic) && string(dict[:len(zstdDictMagic)]) == zstdDictMagic {
+		return zstd.WithDecoderDicts(dict)
+	}
+	return zstd.WithDecoderDictRaw(0, dict)
+}
+
+// ValidateZstdDict returns an error if dict cannot be used as the
+// ExtractOptions.ZstdDict
+func ValidateZstdDict(dict []byte) error {
+	d, err := zstd.NewReader(nil, zstdDictOption(dict))
+	if err != nil {
+		return err
+	}
+	d.Close()
+	return nil
+}
@@ -1,6 +1,7 @@
 package cleanup
 
 import (
+	"io"
 	"log/slog"
 	"os"
 	"sync"
@@ -15,19 +16,77 @@ func SetLogger(l *slog.Logger) {
 	}
 }
 
-// Tracker tracks files that should be cleaned up on interrupt
+// Tracker tracks files that should be cleaned up on interrupt. It is safe
+// for concurrent use. Scopes split it per job: a scope's files are removed
+// with it when the job fails, and kept when it succeeds, without touching
+// the files of other jobs.
 type Tracker struct {
-	files map[string]struct{}
-	mu    sync.Mutex
+	files    map[string]struct{}
+	trees    map[string]struct{}
+	children map[*Tracker]struct{}
+	parent   *Tracker
+	journal  io.Writer
+	mu       sync.Mutex
 }
 
 // NewTracker creates a new cleanup tracker
 func NewTracker() *Tracker {
 	return &Tracker{
-		files: make(map[string]struct{}),
+		files:    make(map[string]struct{}),
+		trees:    make(map[string]struct{}),
+		children: make(map[*Tracker]struct{}),
 	}
 }
 
+// Scope creates a child tracker, e.g. for one job of a multi-job run. Its
+// files are also removed by Cleanup on t (and t's ancestors) until the scope
+// ends with Release or its own Cleanup.
+func (t *Tracker) Scope() *Tracker {
+	s := NewTracker()
+	s.parent = t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.journal = t.journal
+	t.children[s] = struct{}{}
+	return s
+}
+
+// Journal makes t write the paths registered with it, and with the scopes
+// created from it afterwards, to w, each followed by a NUL byte. A process
+// doing work for another reports its files this way, so the other process
+// can remove them if the work fails or the process dies.
+func (t *Tracker) Journal(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.journal = w
+}
+
+// Release keeps every file of the tracker and its scopes, and detaches it
+// from its parent. Call it when the work the scope covers has succeeded.
+func (t *Tracker) Release() {
+	t.mu.Lock()
+	children := t.children
+	t.files = make(map[string]struct{})
+	t.trees = make(map[string]struct{})
+	t.children = make(map[*Tracker]struct{})
+	t.mu.Unlock()
+
+	for c := range children {
+		c.Release()
+	}
+	t.detach()
+}
+
+// detach removes t from its parent's scopes
+func (t *Tracker) detach() {
+	if t.parent == nil {
+		return
+	}
+	t.parent.mu.Lock()
+	defer t.parent.mu.Unlock()
+	delete(t.parent.children, t)
+}
+
 // Register adds a file path to the cleanup list
 func (t *Tracker) Register(path string) {
 	if path == "" || path == "-" {
@@ -36,43 +95,98 @@ func (t *Tracker) Register(path string) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.files[path] = struct{}{}
+	if t.journal != nil {
+		// Best effort: a reader that went away has nothing left to clean up
+		_, _ = io.WriteString(t.journal, path+"\x00")
+	}
+}
+
+// RegisterTree adds a directory to the cleanup list, to be removed with
+// everything in it. Unlike files, trees are not listed by GetAll.
+func (t *Tracker) RegisterTree(path string) {
+	if path == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trees[path] = struct{}{}
 }
 
-// Unregister removes a file path from the cleanup list
+// Unregister removes a file or tree path from the cleanup list, including
+// the lists of t's scopes
 func (t *Tracker) Unregister(path string) {
 	if path == "" || path == "-" {
 		return
 	}
 	t.mu.Lock()
-	defer t.mu.Unlock()
 	delete(t.files, path)
+	delete(t.trees, path)
+	children := t.childList()
+	t.mu.Unlock()
+
+	for _, c := range children {
+		c.Unregister(path)
+	}
 }
 
-// GetAll returns a copy of all currently registered files
+// GetAll returns a copy of all currently registered files, including those
+// of t's scopes
 func (t *Tracker) GetAll() []string {
 	t.mu.Lock()
-	defer t.mu.Unlock()
 	files := make([]string, 0, len(t.files))
 	for path := range t.files {
 		files = append(files, path)
 	}
+	children := t.childList()
+	t.mu.Unlock()
+
+	for _, c := range children {
+		files = append(files, c.GetAll()...)
+	}
 	return files
 }
 
-// Cleanup removes all registered files
+// childList returns t's scopes; t.mu must be held
+func (t *Tracker) childList() []*Tracker {
+	children := make([]*Tracker, 0, len(t.children))
+	for c := range t.children {
+		children = append(children, c)
+	}
+	return children
+}
+
+// Cleanup removes all registered files and trees, those of t's scopes
+// included, and detaches t from its parent
 func (t *Tracker) Cleanup() {
 	t.mu.Lock()
 	files := make([]string, 0, len(t.files))
 	for path := range t.files {
 		files = append(files, path)
 	}
+	trees := make([]string, 0, len(t.trees))
+	for path := range t.trees {
+		trees = append(trees, path)
+	}
+	children := t.childList()
 	t.files = make(map[string]struct{}) // Clear the map
+	t.trees = make(map[string]struct{})
+	t.children = make(map[*Tracker]struct{})
 	t.mu.Unlock()
 
+	for _, c := range children {
+		c.Cleanup()
+	}
+	t.detach()
+
 	for _, path := range files {
 		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
 			// Best effort cleanup - errors are non-critical
 			logger.Warn("cleanup_failed", "file", path, "error", err)
 		}
 	}
+	for _, path := range trees {
+		if err := os.RemoveAll(path); err != nil {
+			logger.Warn("cleanup_failed", "file", path, "error", err)
+		}
+	}
 }
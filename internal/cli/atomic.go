@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/lucrnz/ripvex/internal/archive"
+	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// atomicExtraction is an --extract-atomic extraction, staged in a hidden
+// directory next to the extraction directory and renamed into place by
+// commit. The stage is registered as a tree with the extraction scope, so it
+// goes away entirely if the extraction fails or is interrupted.
+type atomicExtraction struct {
+	scope *cleanup.Tracker
+	dest  string // Extraction directory, absolute
+	stage string
+}
+
+// stageExtraction creates the stage of an --extract-atomic extraction and
+// points opts at it. The extraction directory must be missing or empty, as
+// it is replaced as a whole.
+func stageExtraction(logger *slog.Logger, scope *cleanup.Tracker, opts *archive.ExtractOptions) (*atomicExtraction, error) {
+	dest, err := filepath.Abs(opts.DestDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(dest); err == nil {
+		dest = resolved
+	}
+	entries, err := os.ReadDir(dest)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read --extract-dir: %w", err)
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("--extract-atomic needs a missing or empty --extract-dir, %s is not empty", dest)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create parent of --extract-dir: %w", err)
+	}
+	stage, err := os.MkdirTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".ripvex-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	scope.RegisterTree(stage)
+	// MkdirTemp uses 0700; the stage becomes the extraction directory
+	if err := os.Chmod(stage, 0755&^util.Umask()); err != nil {
+		return nil, fmt.Errorf("failed to set permissions of staging directory: %w", err)
+	}
+	// Extraction resolves symlinks in its directory, and so must the stage
+	if resolved, err := filepath.EvalSymlinks(stage); err == nil && resolved != stage {
+		scope.Unregister(stage)
+		stage = resolved
+		scope.RegisterTree(stage)
+	}
+
+	logger.Info("extract_atomic_stage", "dir", stage, "extract_dir", dest)
+	opts.DestDir = stage
+	return &atomicExtraction{scope: scope, dest: dest, stage: stage}, nil
+}
+
+// commit renames the stage to the extraction directory, replacing it if it
+// is still empty, and moves the extracted files of the scope to their final
+// paths so that a later failure of the job still removes them
+func (a *atomicExtraction) commit(logger *slog.Logger) error {
+	if err := os.Remove(a.dest); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace --extract-dir %s, which must be empty: %w", a.dest, err)
+	}
+	if err := os.Rename(a.stage, a.dest); err != nil {
+		return fmt.Errorf("failed to move staging directory into place: %w", err)
+	}
+	a.scope.Unregister(a.stage)
+	for _, path := range a.scope.GetAll() {
+		if path == a.stage || !util.IsPathSafe(path, a.stage) {
+			continue
+		}
+		rel, err := filepath.Rel(a.stage, path)
+		if err != nil {
+			continue
+		}
+		a.scope.Unregister(path)
+		a.scope.Register(filepath.Join(a.dest, rel))
+	}
+	logger.Info("extract_atomic_committed", "dir", a.dest)
+	return nil
+}
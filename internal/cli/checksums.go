@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// checksumEntry is one line of a checksum file
+type checksumEntry struct {
+	algo   string
+	digest string
+	name   string
+	line   int
+}
+
+// bsdChecksumLine matches the BSD/`--tag` form: SHA256 (name) = digest
+var bsdChecksumLine = regexp.MustCompile(`^([A-Za-z0-9-]+) \((.*)\) = ([0-9A-Fa-f]+)$`)
+
+// inferredAlgorithms maps a digest length to the algorithm assumed for
+// checksum lines that do not name one. BLAKE3 shares SHA-256's length, so
+// it is only used when named.
+var inferredAlgorithms = map[int]string{
+	32:  "md5",
+	40:  "sha1",
+	64:  "sha256",
+	128: "sha512",
+}
+
+// parseChecksumFile reads checksum lines in the formats produced by common tools:
+//
+//	<digest>  <name>         sha256sum, shasum (text mode)
+//	<digest> *<name>         sha256sum --binary
+//	SHA256 (<name>) = <hex>  BSD and --tag
+//	sha256:<digest>  <name>  ripvex hash
+//
+// Blank lines and lines starting with # are skipped. Without an explicit
+// algorithm, it is inferred from the digest length.
+func parseChecksumFile(r io.Reader) ([]checksumEntry, error) {
+	var entries []checksumEntry
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, err := parseChecksumLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		entry.line = lineNo
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no checksum lines found")
+	}
+	return entries, nil
+}
+
+func parseChecksumLine(line string) (checksumEntry, error) {
+	var algo, digest, name string
+	if m := bsdChecksumLine.FindStringSubmatch(line); m != nil {
+		algo, name, digest = strings.ReplaceAll(strings.ToLower(m[1]), "-", ""), m[2], m[3]
+	} else {
+		var ok bool
+		digest, name, ok = strings.Cut(line, " ")
+		if !ok || name == "" {
+			return checksumEntry{}, fmt.Errorf("expected \"<digest>  <name>\"")
+		}
+		// Text mode separates with two spaces, binary mode with " *"
+		name = strings.TrimPrefix(strings.TrimPrefix(name, " "), "*")
+		if prefix, rest, ok := strings.Cut(digest, ":"); ok {
+			algo, digest = strings.ToLower(prefix), rest
+		}
+	}
+	algo, digest, err := parseDigest(algo, digest)
+	if err != nil {
+		return checksumEntry{}, err
+	}
+	return checksumEntry{algo: algo, digest: digest, name: name}, nil
+}
+
+// parseDigest validates a hex digest, inferring the algorithm from its
+// length when algo is empty
+func parseDigest(algo, digest string) (string, string, error) {
+	digest = strings.ToLower(digest)
+	if algo == "" {
+		var ok bool
+		if algo, ok = inferredAlgorithms[len(digest)]; !ok {
+			return "", "", fmt.Errorf("cannot infer hash algorithm from a %d-character digest", len(digest))
+		}
+	}
+
+	// Reuse the --hash validation for the algorithm, length and hex digits
+	return parseExpectedHash(algo + ":" + digest)
+}
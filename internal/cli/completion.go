@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"github.com/lucrnz/ripvex/internal/credhelper"
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/spf13/cobra"
+)
+
+// registerFlagCompletions teaches shell completion the values of enum-like
+// flags. cobra adds the `completion bash|zsh|fish|powershell` command itself
+// once the root command has subcommands. Called from root's init, after the
+// flags exist.
+func registerFlagCompletions() {
+	completeValues := func(flag string, values ...string) {
+		_ = rootCmd.RegisterFlagCompletionFunc(flag, cobra.FixedCompletions(values, cobra.ShellCompDirectiveNoFileComp))
+	}
+	completeValues("progress", "auto", "bar", "log", "json")
+	completeValues("log-format", "text", "json")
+	completeValues("log-level", "debug", "info", "warn", "error")
+	completeValues("redirect-policy", downloader.RedirectPolicies...)
+	completeValues("print-hash", hashAlgorithms()...)
+	completeValues("auth-helper", credhelper.Keychain, credhelper.SecretService, credhelper.WinCred, "command:")
+
+	// Complete the algorithm prefix; the digest itself has to be pasted
+	_ = rootCmd.RegisterFlagCompletionFunc("hash", cobra.FixedCompletions([]string{"sha256:", "sha512:", "blake3:", "sha1:", "md5:", "crc32:", "crc32c:"}, cobra.ShellCompDirectiveNoFileComp|cobra.ShellCompDirectiveNoSpace))
+
+	_ = rootCmd.MarkFlagDirname("chdir")
+	_ = rootCmd.MarkFlagDirname("output-dir")
+}
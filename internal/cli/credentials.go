@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/credhelper"
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/lucrnz/ripvex/internal/netrc"
+	"golang.org/x/term"
+)
+
+// maxSecretBytes bounds a secret read from a file or stdin
+const maxSecretBytes = 64 * 1024
+
+// readAuthSecrets fills --auth-bearer or --auth-basic-pass from
+// --auth-bearer-file, --auth-basic-pass-file or --auth-prompt, which keep
+// the secret out of process arguments and shell history
+func readAuthSecrets(ctx context.Context) error {
+	sources := 0
+	for _, set := range []bool{authBearerFile != "", authBasicPassFile != "", authPrompt} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("--auth-bearer-file, --auth-basic-pass-file and --auth-prompt are mutually exclusive")
+	}
+
+	var err error
+	switch {
+	case authBearerFile != "":
+		if authBearer != "" {
+			return fmt.Errorf("--auth-bearer-file cannot be used with --auth-bearer")
+		}
+		authBearer, err = readSecretFile(authBearerFile, "--auth-bearer-file")
+	case authBasicPassFile != "":
+		if authBasicPass != "" {
+			return fmt.Errorf("--auth-basic-pass-file cannot be used with --auth-basic-pass")
+		}
+		if authBasicUser == "" {
+			return fmt.Errorf("--auth-basic-pass-file requires --auth-basic-user")
+		}
+		authBasicPass, err = readSecretFile(authBasicPassFile, "--auth-basic-pass-file")
+	case authPrompt && authBasicUser != "":
+		if authBasicPass != "" {
+			return fmt.Errorf("--auth-prompt cannot be used with --auth-basic-pass")
+		}
+		authBasicPass, err = promptSecret(ctx, fmt.Sprintf("Password for %s: ", authBasicUser))
+	case authPrompt:
+		if authBearer != "" {
+			return fmt.Errorf("--auth-prompt cannot be used with --auth-bearer")
+		}
+		authBearer, err = promptSecret(ctx, "Bearer token: ")
+	}
+	return err
+}
+
+// readSecretFile reads a secret from path, or stdin for "-". Trailing line
+// breaks are dropped, as editors and echo add them.
+func readSecretFile(path, flag string) (string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", flag, err)
+		}
+		defer f.Close()
+		r = f
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxSecretBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", flag, err)
+	}
+	if len(data) > maxSecretBytes {
+		return "", fmt.Errorf("%s is larger than %d bytes", flag, maxSecretBytes)
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", flag)
+	}
+	return secret, nil
+}
+
+// promptSecret reads a secret from the terminal without echoing it. Like
+// confirm, the read runs in the background so an interrupt is not stuck
+// behind it.
+func promptSecret(ctx context.Context, prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("--auth-prompt requires a terminal; use --auth-bearer-file or --auth-basic-pass-file in scripts")
+	}
+	state, err := term.GetState(fd)
+	if err != nil {
+		return "", fmt.Errorf("failed to read terminal state: %w", err)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+
+	type result struct {
+		secret []byte
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		secret, err := term.ReadPassword(fd)
+		done <- result{secret, err}
+	}()
+
+	select {
+	case r := <-done:
+		fmt.Fprintln(os.Stderr)
+		if r.err != nil {
+			return "", fmt.Errorf("failed to read secret: %w", r.err)
+		}
+		if len(r.secret) == 0 {
+			return "", fmt.Errorf("no secret entered at the --auth-prompt prompt")
+		}
+		return string(r.secret), nil
+	case <-ctx.Done():
+		// ReadPassword turned echo off; the shell needs it back
+		term.Restore(fd, state)
+		return "", ctx.Err()
+	}
+}
+
+// newAuthHelper returns the --auth-helper token source, or nil without one.
+// A nil *credhelper.Helper must not become a non-nil interface.
+func newAuthHelper() (downloader.TokenSource, error) {
+	if authHelper == "" {
+		return nil, nil
+	}
+	h, err := credhelper.New(authHelper)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --auth-helper value: %w", err)
+	}
+	return h, nil
+}
+
+// loadNetrc reads the credentials requests fall back to when no
+// Authorization is given: --netrc-file, or else $NETRC or ~/.netrc if it
+// exists. A broken default file is only warned about, since it may be meant
+// for another program.
+func loadNetrc(logger *slog.Logger) (*netrc.File, error) {
+	if noNetrc {
+		if netrcFile != "" {
+			return nil, fmt.Errorf("--netrc-file cannot be used with --no-netrc")
+		}
+		return nil, nil
+	}
+	if netrcFile != "" {
+		f, err := netrc.Load(netrcFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --netrc-file: %w", err)
+		}
+		return f, nil
+	}
+
+	path := netrc.DefaultPath()
+	if path == "" {
+		return nil, nil
+	}
+	f, err := netrc.Load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Warn("netrc_ignored", "file", path, "error", err)
+		return nil, nil
+	}
+	logger.Debug("netrc_loaded", "file", path)
+	return f, nil
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/devserver"
+	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+var (
+	devserverListen    string
+	devserverDir       string
+	devserverFault     string
+	devserverTLS       string
+	devserverLogFormat string
+)
+
+var devserverCmd = &cobra.Command{
+	Use:   "devserver",
+	Short: "Serve fixtures with injectable faults for testing download wrappers",
+	Long: `Serve fixtures with injectable faults for testing download wrappers.
+
+Fixtures are files under --dir and generated bodies at /bytes/<size>
+(e.g. /bytes/10MiB). Faults are selected per request with query parameters,
+or for every request with --fault using the same syntax:
+
+  fault=drip&rate=4KiB          send the body at 4 KiB/s
+  fault=reset&after=1MiB        reset the TCP connection after 1 MiB
+  fault=length-long&by=1KiB     announce 1 KiB more than is sent, then close
+  fault=length-short&by=1KiB    announce 1 KiB less than is sent
+  fault=stall&after=64KiB       send 64 KiB, then hang
+  fault=429&count=3             answer 429 three times per path, then succeed
+  fault=status&code=503         answer with the given status
+  delay=2s                      wait before responding (combines with any fault)
+
+--tls serves HTTPS with a throwaway certificate that is self-signed, expired,
+issued for the wrong host, or limited to legacy TLS versions.`,
+	Example: `  ripvex devserver --dir ./fixtures
+  ripvex -U 'http://127.0.0.1:8080/bytes/10MiB?fault=reset' --allow-unsafe-http
+  ripvex devserver --fault 'fault=drip&rate=16KiB'
+  ripvex devserver --tls expired --listen 127.0.0.1:8443`,
+	Args: cobra.NoArgs,
+	RunE: runDevserver,
+}
+
+func init() {
+	devserverCmd.Flags().StringVar(&devserverListen, "listen", "127.0.0.1:8080", "Address to listen on")
+	devserverCmd.Flags().StringVar(&devserverDir, "dir", "", "Serve files from this directory (in addition to /bytes/<size>)")
+	devserverCmd.Flags().StringVar(&devserverFault, "fault", "", "Default fault parameters for every request, as a query string (e.g. \"fault=drip&rate=4KiB\"). Request query parameters override them")
+	devserverCmd.Flags().StringVar(&devserverTLS, "tls", "", "Serve HTTPS with a faulty certificate or protocol: "+strings.Join(devserver.TLSModes, ", "))
+	devserverCmd.Flags().StringVar(&devserverLogFormat, "log-format", "text", "Log format: text or json")
+	_ = devserverCmd.RegisterFlagCompletionFunc("tls", cobra.FixedCompletions(devserver.TLSModes, cobra.ShellCompDirectiveNoFileComp))
+	_ = devserverCmd.RegisterFlagCompletionFunc("log-format", cobra.FixedCompletions([]string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp))
+	_ = devserverCmd.MarkFlagDirname("dir")
+	rootCmd.AddCommand(devserverCmd)
+}
+
+func runDevserver(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	logger, err := logging.New("info", devserverLogFormat)
+	if err != nil {
+		return withExitCode(ExitUsage, fmt.Errorf("invalid logging configuration: %w", err))
+	}
+
+	faults, err := url.ParseQuery(devserverFault)
+	if err != nil {
+		return withExitCode(ExitUsage, fmt.Errorf("invalid --fault value: %w", err))
+	}
+	if f := faults.Get("fault"); f != "" && !slices.Contains(devserver.Faults, f) {
+		return withExitCode(ExitUsage, fmt.Errorf("invalid --fault value: unknown fault %q (supported: %s)", f, strings.Join(devserver.Faults, ", ")))
+	}
+
+	server := &http.Server{
+		Handler:           devserver.New(devserver.Config{Dir: devserverDir, Faults: faults, Logger: logger}),
+		ReadHeaderTimeout: 10 * time.Second,
+		// HTTP/1.1 only: faults that rewrite the raw response need to hijack the connection
+		TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){},
+	}
+	scheme := "http"
+	if devserverTLS != "" {
+		server.TLSConfig, err = devserver.TLSConfig(devserverTLS)
+		if err != nil {
+			return withExitCode(ExitUsage, fmt.Errorf("invalid --tls value: %w", err))
+		}
+		scheme = "https"
+	}
+
+	ln, err := net.Listen("tcp", devserverListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", devserverListen, err)
+	}
+	logger.Info("devserver_listening", "url", scheme+"://"+ln.Addr().String(), "dir", devserverDir, "fault", devserverFault, "tls", devserverTLS)
+
+	errCh := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			errCh <- server.ServeTLS(ln, "", "")
+		} else {
+			errCh <- server.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("devserver stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	// Stalled and dripping responses never finish on their own, so shutdown is bounded
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("devserver shutdown failed: %w", err)
+	}
+	server.Close()
+	logger.Info("devserver_stopped")
+	return nil
+}
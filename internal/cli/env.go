@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix prefixes the environment variable bound to every flag
+const envPrefix = "RIPVEX_"
+
+// envName returns the environment variable for a flag, e.g. max-bytes -> RIPVEX_MAX_BYTES
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// applyEnv sets every flag not given on the command line from its RIPVEX_*
+// environment variable, so defaults and secrets can be injected without
+// appearing in process arguments. Command-line flags always win.
+func applyEnv(cmd *cobra.Command, args []string) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "help" || f.Name == "version" {
+			return
+		}
+		// A positional URL is a command-line value too
+		if f.Name == "url" && len(args) > 0 {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		// Set marks the flag as changed, which also satisfies required flags
+		if setErr := cmd.Flags().Set(f.Name, value); setErr != nil {
+			// The value may be a secret; only the variable name is reported
+			err = withExitCode(ExitUsage, fmt.Errorf("invalid %s value for --%s", envName(f.Name), f.Name))
+		}
+	})
+	return err
+}
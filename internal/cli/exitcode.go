@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"errors"
+
+	"github.com/lucrnz/ripvex/internal/archive"
+	"github.com/lucrnz/ripvex/internal/downloader"
+)
+
+// Exit codes returned by ripvex. They are part of the CLI contract: scripts
+// may branch on them, so existing values must never change meaning.
+const (
+	ExitOK           = 0
+	ExitFailure      = 1   // Any failure not covered below (e.g. local I/O)
+	ExitUsage        = 2   // Invalid flags, arguments or setup
+	ExitNetwork      = 3   // DNS, connect, TLS, redirect, refused host or transfer failure
+	ExitHTTP         = 4   // Non-200 response, failed --assert-header or --max-age
+	ExitHashMismatch = 5   // Downloaded content does not match --hash, or extracted files --verify-manifest
+	ExitSizeLimit    = 6   // --max-bytes, --extract-max-bytes or --preflight-max-bytes exceeded
+	ExitExtraction   = 7   // Archive detection or extraction failed
+	ExitInterrupted  = 130 // Interrupted by SIGINT/SIGTERM
+)
+
+// exitError attaches an exit code to an error whose class cannot be told
+// from its type alone
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// ExitCode maps an error returned by ExecuteContext to a process exit code
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var httpErr *downloader.HTTPError
+	var assertErr *downloader.AssertionError
+	var staleErr *downloader.StaleError
+	var netErr *downloader.NetworkError
+	var timeoutErr *downloader.TimeoutError
+	var exitErr *exitError
+	switch {
+	// Size limits and manifest mismatches win over the extraction class they may be wrapped in
+	case errors.Is(err, downloader.ErrMaxBytes), errors.Is(err, archive.ErrMaxBytes), errors.Is(err, archive.ErrMaxFileBytes), errors.Is(err, errPreflightLimit):
+		return ExitSizeLimit
+	case errors.Is(err, archive.ErrManifestMismatch):
+		return ExitHashMismatch
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	case errors.As(err, &httpErr), errors.As(err, &assertErr), errors.As(err, &staleErr):
+		return ExitHTTP
+	case errors.Is(err, downloader.ErrHashMismatch):
+		return ExitHashMismatch
+	case errors.As(err, &netErr), errors.As(err, &timeoutErr):
+		return ExitNetwork
+	}
+	return ExitFailure
+}
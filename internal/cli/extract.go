@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/spf13/cobra"
+)
+
+// newExtractCmd returns `ripvex extract <file>`, which extracts a local
+// archive with the same safety limits as `ripvex -x`
+func newExtractCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "extract <file>",
+		Short: "Extract a local archive",
+		Long: `Extract a local archive into the working directory (or --chdir).
+
+The archive type is detected from its content, and the same protections as
+for downloads apply: path traversal checks, --extract-max-bytes and
+--extract-timeout. If extraction fails, the files it created are removed.
+The archive itself is never deleted.`,
+		Example: `  ripvex extract release.tar.gz
+  ripvex extract release.zip -C /opt/app --chdir-create --extract-strip-components 1`,
+		Args:    usageArgs(cobra.ExactArgs(1)),
+		PreRunE: applyEnv,
+		RunE:    runExtract,
+	}
+	shareFlags(cmd, "chdir", "chdir-create", "extract-dir", "extract-atomic", "extract-strip-components", "extract-strip-toplevel", "extract-allow-paths", "extract-max-depth", "lenient", "preserve-permissions", "no-umask", "preserve-mtime", "no-mtime", "extract-overwrite", "extract-skip-existing", "extract-keep-newer", "extract-links", "extract-strict-names", "zip-charset", "zstd-dict", "archive-type", "verify-manifest", "extract-nested", "extract-nested-depth", "extract-sandbox", "extract-max-bytes", "extract-max-file-bytes", "extract-timeout",
+		"quiet", "log-level", "log-format", "log-file", "progress", "progress-fd", "progress-interval", "log-progress-step", "log-progress-step-unknown")
+	return cmd
+}
+
+func runExtract(cmd *cobra.Command, args []string) (err error) {
+	// Everything that fails before extraction starts is a usage/setup error
+	extracting := false
+	defer func() {
+		if err != nil && !extracting && cmd.Context().Err() == nil {
+			err = withExitCode(ExitUsage, err)
+		}
+	}()
+
+	ctx := cmd.Context()
+	tracker, ok := ctx.Value(trackerKey).(*cleanup.Tracker)
+	if !ok || tracker == nil {
+		return fmt.Errorf("internal error: cleanup tracker not found in context")
+	}
+
+	// Resolve the archive before --chdir moves the working directory
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot read archive: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("cannot extract %q: is a directory", args[0])
+	}
+
+	extractOpts, err := parseExtractFlags(cmd)
+	if err != nil {
+		return err
+	}
+	progressInterval, err := parseProgressFlags()
+	if err != nil {
+		return err
+	}
+	if err := applyChdir(); err != nil {
+		return err
+	}
+
+	ctx, logger, err := setupLogger(ctx)
+	if err != nil {
+		return err
+	}
+	progressLogger, progressTerminal, err := newProgressOutput(nil)
+	if err != nil {
+		return err
+	}
+	extractOpts.Progress = newPhaseBar("extract", logger, progressLogger, progressTerminal, progressInterval)
+
+	extracting = true
+	return extractFile(ctx, tracker, logger, path, "", extractOpts)
+}
@@ -0,0 +1,18 @@
+package cli
+
+import "github.com/spf13/cobra"
+
+// newGetCmd returns `ripvex get`, the explicit form of the default command.
+// It shares every root flag, so `ripvex get URL` and `ripvex URL` behave the same.
+func newGetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "get [URL]",
+		Short:   "Download a URL, optionally verifying and extracting it (default command)",
+		Args:    usageArgs(cobra.MaximumNArgs(1)),
+		PreRunE: applyEnv,
+		RunE:    run,
+	}
+	cmd.ValidArgsFunction = cobra.NoFileCompletions
+	cmd.Flags().AddFlagSet(rootCmd.Flags())
+	return cmd
+}
@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/fips"
+	"github.com/lucrnz/ripvex/internal/progress"
+	"github.com/spf13/cobra"
+)
+
+var hashAlgorithm string
+
+// localHashFlags are the root flags shared by the hash and verify commands
+var localHashFlags = []string{"fips", "quiet", "log-level", "log-format", "log-file", "progress", "progress-fd", "progress-interval"}
+
+// newHashCmd returns `ripvex hash <file>...`, which prints digests in the
+// algorithm-prefixed form accepted by --hash
+func newHashCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hash <file>...",
+		Short: "Print the digest of local files in --hash format",
+		Long: `Print the digest of local files in the algorithm-prefixed form accepted
+by --hash and verify, followed by the file name. Use "-" for stdin.`,
+		Example: `  ripvex hash release.tar.gz
+  ripvex hash -a sha512 release.tar.gz release.zip`,
+		Args:    usageArgs(cobra.MinimumNArgs(1)),
+		PreRunE: applyEnv,
+		RunE:    runHash,
+	}
+	cmd.Flags().StringVarP(&hashAlgorithm, "algorithm", "a", "sha256", "Hash algorithm: "+strings.Join(hashAlgorithms(), ", "))
+	_ = cmd.RegisterFlagCompletionFunc("algorithm", cobra.FixedCompletions(hashAlgorithms(), cobra.ShellCompDirectiveNoFileComp))
+	shareFlags(cmd, localHashFlags...)
+	return cmd
+}
+
+func runHash(cmd *cobra.Command, args []string) error {
+	algo := strings.ToLower(hashAlgorithm)
+	if err := checkLocalHashPolicy(algo); err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	_, logger, err := setupLogger(cmd.Context())
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	newBar, err := verifyBars(logger)
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+
+	out := cmd.OutOrStdout()
+	for _, path := range args {
+		digest, err := hashFile(path, algo, newBar)
+		if err != nil {
+			return err
+		}
+		printDigest(out, algo, digest, path)
+	}
+	return nil
+}
+
+// printDigest writes one "<algo>:<digest>  <name>" line, the format of
+// `ripvex hash` and --print-hash
+func printDigest(w io.Writer, algo, digest, name string) {
+	fmt.Fprintf(w, "%s:%s  %s\n", algo, digest, name)
+}
+
+// checkLocalHashPolicy applies checkHashPolicy outside the download command
+func checkLocalHashPolicy(algo string) error {
+	// The FIPS module cannot be switched off at runtime, so neither can the policy
+	if fips.ModuleEnabled() {
+		fipsMode = true
+	}
+	return checkHashPolicy(algo)
+}
+
+// verifyBars returns a constructor for per-file "verify" progress bars, or
+// nil when progress would only be regular log lines: one bar per entry of a
+// checksum file would flood the log
+func verifyBars(logger *slog.Logger) (func() *progress.Bar, error) {
+	interval, err := parseProgressFlags()
+	if err != nil {
+		return nil, err
+	}
+	progressLogger, terminal, err := newProgressOutput(nil)
+	if err != nil {
+		return nil, err
+	}
+	if progressLogger == nil && terminal == nil {
+		return nil, nil
+	}
+	return func() *progress.Bar {
+		return newPhaseBar("verify", logger, progressLogger, terminal, interval)
+	}, nil
+}
+
+// hashFile returns the hex digest of a file, or of stdin for "-". With
+// newBar set, reading the file advances a progress bar.
+func hashFile(path, algo string, newBar func() *progress.Bar) (string, error) {
+	var r io.Reader = os.Stdin
+	var size int64
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to open file: %w", err)
+		}
+		defer f.Close()
+		if info, err := f.Stat(); err == nil {
+			size = info.Size()
+		}
+		r = f
+	}
+
+	if newBar != nil {
+		bar := newBar()
+		bar.Total = size
+		bar.Start()
+		defer bar.Stop()
+		r = io.TeeReader(r, barWriter{bar})
+	}
+
+	h := supportedHashes[algo].newHash()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// barWriter advances a progress bar by the bytes written to it
+type barWriter struct {
+	bar *progress.Bar
+}
+
+func (w barWriter) Write(p []byte) (int, error) {
+	w.bar.Update(int64(len(p)))
+	return len(p), nil
+}
+
+// hashAlgorithms lists the supported algorithm names in a stable order
+func hashAlgorithms() []string {
+	names := make([]string, 0, len(supportedHashes))
+	for name := range supportedHashes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+)
+
+// maxCompanionFileSize bounds the checksum and signature files fetched for a download
+const maxCompanionFileSize = 1 << 20
+
+// fetchSmall downloads a small companion file of j, such as a checksum file
+// or a signature, with the connection settings of base. Custom headers and
+// credentials are only sent when it is on the origin of the download.
+func fetchSmall(ctx context.Context, base downloader.Options, j job, target *url.URL, flag string) ([]byte, error) {
+	var buf bytes.Buffer
+	opts := downloader.Options{
+		URL:              target.String(),
+		Output:           flag,
+		Sink:             &buf,
+		Quiet:            true,
+		ConnectTimeout:   base.ConnectTimeout,
+		MaxTime:          base.MaxTime,
+		MaxRedirects:     base.MaxRedirects,
+		RedirectPolicy:   base.RedirectPolicy,
+		HostPolicy:       base.HostPolicy,
+		UserAgent:        base.UserAgent,
+		MaxBytes:         maxCompanionFileSize,
+		ProgressInterval: base.ProgressInterval,
+		AllowInsecureTLS: base.AllowInsecureTLS,
+		FIPS:             base.FIPS,
+		AuthHelper:       base.AuthHelper,
+		Netrc:            base.Netrc,
+		Verbose:          base.Verbose,
+	}
+	if target.Scheme == j.parsedURL.Scheme && target.Host == j.parsedURL.Host {
+		opts.Headers = base.Headers
+	}
+	if _, err := downloader.Download(ctx, nil, opts); err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", flag, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseChecksumData parses a --hash-url checksum file. A file holding a
+// single bare digest, as many <file>.sha256 files do, yields one entry
+// without a name.
+func parseChecksumData(data []byte) ([]checksumEntry, error) {
+	if fields := strings.Fields(string(data)); len(fields) == 1 {
+		digest := strings.ToLower(fields[0])
+		algo, ok := inferredAlgorithms[len(digest)]
+		if !ok {
+			return nil, fmt.Errorf("invalid --hash-url checksum file: cannot infer hash algorithm from a %d-character digest", len(digest))
+		}
+		algo, digest, err := parseExpectedHash(algo + ":" + digest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --hash-url checksum file: %w", err)
+		}
+		return []checksumEntry{{algo: algo, digest: digest, line: 1}}, nil
+	}
+
+	entries, err := parseChecksumFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --hash-url checksum file: %w", err)
+	}
+	return entries, nil
+}
+
+// lookupChecksum selects the checksum line for j: the one naming the file
+// of its URL, else the one naming its output. Names are compared in full,
+// then by their last path element when that is unambiguous.
+func lookupChecksum(entries []checksumEntry, j job) (checksumEntry, error) {
+	if len(entries) == 1 && entries[0].name == "" {
+		return entries[0], nil
+	}
+	names := []string{path.Base(j.parsedURL.Path)}
+	if unescaped, err := url.PathUnescape(names[0]); err == nil && unescaped != names[0] {
+		names = append(names, unescaped)
+	}
+	names = append(names, path.Base(j.output))
+
+	for _, name := range names {
+		for _, e := range entries {
+			if e.name == name {
+				return e, nil
+			}
+		}
+	}
+	for _, name := range names {
+		var match *checksumEntry
+		for i, e := range entries {
+			if path.Base(strings.TrimPrefix(e.name, "./")) != name {
+				continue
+			}
+			if match != nil && (match.algo != e.algo || match.digest != e.digest) {
+				return checksumEntry{}, fmt.Errorf("--hash-url lists %s more than once with different hashes (lines %d and %d)", name, match.line, e.line)
+			}
+			match = &entries[i]
+		}
+		if match != nil {
+			return *match, nil
+		}
+	}
+	return checksumEntry{}, fmt.Errorf("--hash-url has no checksum for %s", names[0])
+}
+
+// resolveHashURLs fetches the --hash-url checksum file of every job, once
+// per distinct URL, and sets each job's expected hash from its line
+func resolveHashURLs(ctx context.Context, logger *slog.Logger, base downloader.Options, jobs []job) error {
+	fetched := make(map[string][]checksumEntry)
+	warned := make(map[string]bool)
+	for i := range jobs {
+		j := &jobs[i]
+		key := j.hashURL.String()
+		entries, ok := fetched[key]
+		if !ok {
+			logger.Info("hash_url_fetch", "url", key)
+			data, err := fetchSmall(ctx, base, *j, j.hashURL, "--hash-url")
+			if err != nil {
+				return err
+			}
+			if entries, err = parseChecksumData(data); err != nil {
+				return err
+			}
+			fetched[key] = entries
+		}
+
+		e, err := lookupChecksum(entries, *j)
+		if err != nil {
+			return err
+		}
+		if err := checkHashPolicy(e.algo); err != nil {
+			return withExitCode(ExitUsage, fmt.Errorf("--hash-url line %d: %w", e.line, err))
+		}
+		if !warned[e.algo] {
+			warned[e.algo] = true
+			warnWeakHash(logger, e.algo)
+		}
+		logger.Info("hash_url_matched", "url", j.url, "name", e.name, "line", e.line, "algorithm", e.algo)
+		j.hashAlgo, j.hashDigest = e.algo, e.digest
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/lucrnz/ripvex/internal/logging"
+)
+
+// Phases of the --exec-* hooks, passed to them in RIPVEX_HOOK_PHASE
+const (
+	hookPre          = "pre"
+	hookPostDownload = "post-download"
+	hookPostExtract  = "post-extract"
+)
+
+// hookEnvPrefix prefixes the variables describing the artifact to a hook.
+// It must not be the name of a flag under envPrefix, or a ripvex run by the
+// hook would read them as its own flags.
+const hookEnvPrefix = envPrefix + "HOOK_"
+
+// hookArtifact is what a hook is told about the download
+type hookArtifact struct {
+	url        string
+	path       string
+	result     *downloader.Result // nil before the download
+	extractDir string             // Set after extraction
+}
+
+// env returns the RIPVEX_HOOK_* variables for phase
+func (a hookArtifact) env(phase string) []string {
+	vars := map[string]string{
+		"PHASE": phase,
+		"URL":   withoutUserinfo(a.url),
+		"PATH":  a.path,
+	}
+	if r := a.result; r != nil {
+		vars["EFFECTIVE_URL"] = withoutUserinfo(r.URL)
+		vars["BYTES"] = strconv.FormatInt(r.BytesDownloaded, 10)
+		vars["HTTP_CODE"] = strconv.Itoa(r.HTTPCode)
+		vars["VERIFIED"] = "0"
+		if r.Hash != "" {
+			vars["HASH"] = r.HashAlgorithm + ":" + r.Hash
+			vars["VERIFIED"] = "1"
+		} else if digest := r.Digests["sha256"]; digest != "" {
+			vars["HASH"] = "sha256:" + digest
+		}
+	}
+	if a.extractDir != "" {
+		vars["EXTRACT_DIR"] = a.extractDir
+	}
+	env := make([]string, 0, len(vars))
+	for name, value := range vars {
+		env = append(env, hookEnvPrefix+name+"="+value)
+	}
+	return env
+}
+
+// runHook runs command through the shell for phase, with the environment
+// of ripvex plus the RIPVEX_HOOK_* variables describing a. Its output goes
+// to stderr, keeping stdout for the download. A failing command fails the
+// job, and the tracker removes the files the job still has registered.
+func runHook(ctx context.Context, logger *slog.Logger, phase, command string, a hookArtifact) error {
+	if command == "" {
+		return nil
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), a.env(phase)...)
+	cmd.Stdout = logging.Stderr
+	cmd.Stderr = logging.Stderr
+
+	logger.Info("hook_start", "phase", phase, "file", a.path)
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return fmt.Errorf("--exec-%s command failed: %w", phase, err)
+	}
+	logger.Info("hook_complete", "phase", phase, "elapsed", time.Since(start).Round(time.Millisecond).String())
+	return nil
+}
@@ -0,0 +1,21 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+)
+
+// newHostPolicy returns the policy of --allow-host and --deny-host, or nil
+// when neither is set
+func newHostPolicy() (*downloader.HostPolicy, error) {
+	if len(allowHosts) == 0 && len(denyHosts) == 0 {
+		return nil, nil
+	}
+	for _, pattern := range append(append([]string{}, allowHosts...), denyHosts...) {
+		if err := downloader.ValidateHostPattern(pattern); err != nil {
+			return nil, fmt.Errorf("invalid --allow-host or --deny-host value: %w", err)
+		}
+	}
+	return &downloader.HostPolicy{Allow: allowHosts, Deny: denyHosts}, nil
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// Lock file of an output, and how often a held one is tried again
+const (
+	lockSuffix       = ".lock"
+	lockPollInterval = 200 * time.Millisecond
+)
+
+// outputLock is the advisory lock on <output>.lock that keeps ripvex
+// processes sharing an output from writing it at the same time
+type outputLock struct {
+	file   *os.File // nil when the filesystem has no locks
+	path   string
+	waited bool // Another process held the lock first, and may have written the output
+}
+
+// lockOutput locks output against other ripvex processes. It waits for the
+// one holding it up to timeout, or without limit if timeout is negative.
+func lockOutput(ctx context.Context, logger *slog.Logger, output string, timeout time.Duration) (*outputLock, error) {
+	path := output + lockSuffix
+	var deadline <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	waiting := false
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+		locked, err := util.TryLock(file)
+		if errors.Is(err, errors.ErrUnsupported) {
+			file.Close()
+			os.Remove(path)
+			logger.Warn("output_lock_unsupported", "file", path, "error", err)
+			return &outputLock{path: path}, nil
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if locked {
+			// The previous holder removes the file on release: a lock on a
+			// file no longer at path excludes nobody
+			info, statErr := file.Stat()
+			current, err := os.Stat(path)
+			if statErr == nil && err == nil && os.SameFile(info, current) {
+				if waiting {
+					logger.Info("output_lock_acquired", "file", path)
+				}
+				return &outputLock{file: file, path: path, waited: waiting}, nil
+			}
+			util.Unlock(file)
+			file.Close()
+			continue
+		}
+		file.Close()
+
+		if !waiting {
+			logger.Info("output_lock_wait", "file", path, "hint", "another ripvex process is downloading to this output")
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-deadline:
+			return nil, fmt.Errorf("%s is being downloaded by another ripvex process (%s held for over --lock-timeout %s)", output, path, util.FormatDuration(timeout))
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// release removes the lock file and unlocks it. The file is removed first,
+// while still locked, so a waiting process never keeps a lock on it.
+func (l *outputLock) release() {
+	if l.file == nil {
+		return
+	}
+	removed := os.Remove(l.path) == nil
+	util.Unlock(l.file)
+	l.file.Close()
+	if !removed {
+		// Windows cannot remove a file that is open
+		os.Remove(l.path)
+	}
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/archive"
+)
+
+// readManifest reads the --verify-manifest file: a JSON object mapping the
+// paths of extracted files to digests in --hash format ("bin/tool":
+// "sha256:..."), or a checksum file in any format --hash-file accepts. A
+// digest without an algorithm has it inferred from its length.
+func readManifest(path string) (archive.Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []checksumEntry
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var digests map[string]string
+		if err := json.Unmarshal(trimmed, &digests); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		for name, value := range digests {
+			var algo, digest string
+			if prefix, rest, ok := strings.Cut(value, ":"); ok {
+				algo, digest = strings.ToLower(prefix), rest
+			} else {
+				digest = value
+			}
+			algo, digest, err := parseDigest(algo, digest)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			entries = append(entries, checksumEntry{algo: algo, digest: digest, name: name})
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("no files listed")
+		}
+	} else if entries, err = parseChecksumFile(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	manifest := make(archive.Manifest, len(entries))
+	for _, e := range entries {
+		if err := checkHashPolicy(e.algo); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.name, err)
+		}
+		name := archive.ManifestPath(e.name)
+		if name == "" {
+			return nil, fmt.Errorf("%s: path must be relative to the extraction directory", e.name)
+		}
+		if _, dup := manifest[name]; dup {
+			return nil, fmt.Errorf("%s is listed more than once", name)
+		}
+		manifest[name] = archive.ManifestEntry{Algorithm: e.algo, Digest: e.digest, NewHash: supportedHashes[e.algo].newHash}
+	}
+	return manifest, nil
+}
+
+// logManifest logs the --verify-manifest file in use and warns about weak
+// hash algorithms in it
+func logManifest(logger *slog.Logger, manifest archive.Manifest) {
+	var algos []string
+	for _, e := range manifest {
+		if !slices.Contains(algos, e.Algorithm) {
+			algos = append(algos, e.Algorithm)
+		}
+	}
+	slices.Sort(algos)
+	for _, algo := range algos {
+		warnWeakHash(logger, algo)
+	}
+	logger.Info("extract_manifest", "file", verifyManifestPath, "files", len(manifest), "algorithms", algos)
+}
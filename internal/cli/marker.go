@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// markerState is the state of the --extract-if-missing marker path
+type markerState int
+
+const (
+	markerMissing markerState = iota
+	markerPresent             // Exists and, if it is a stamp, records the expected hash
+	markerStale               // A stamp recording a different hash
+)
+
+// maxStampSize bounds how much of a marker is read to look for a stamp
+const maxStampSize = 1024
+
+// checkMarker inspects the marker path. Any existing path satisfies it,
+// except a stamp file (a single --hash value, as written by writeMarker)
+// that records a different hash than stamp.
+func checkMarker(path, stamp string) (markerState, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return markerMissing, nil
+	}
+	if err != nil {
+		return markerMissing, fmt.Errorf("failed to check --extract-if-missing path: %w", err)
+	}
+	if stamp == "" || !info.Mode().IsRegular() || info.Size() > maxStampSize {
+		return markerPresent, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return markerMissing, fmt.Errorf("failed to read --extract-if-missing path: %w", err)
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return markerMissing, fmt.Errorf("failed to read --extract-if-missing path: %w", err)
+	}
+
+	// Files the archive itself provides are not stamps; their existence is enough
+	algo, digest, err := parseExpectedHash(strings.TrimSpace(string(content)))
+	if err != nil || algo == "" {
+		return markerPresent, nil
+	}
+	if algo+":"+digest != stamp {
+		return markerStale, nil
+	}
+	return markerPresent, nil
+}
+
+// writeMarker creates the marker as a stamp file holding stamp (which may
+// be empty), so the next run can skip the download
+func writeMarker(path, stamp string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create --extract-if-missing directory: %w", err)
+	}
+	content := ""
+	if stamp != "" {
+		content = stamp + "\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write --extract-if-missing marker: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseMatrix parses a --matrix specification such as
+// "os=linux,darwin;arch=amd64,arm64" into every combination of its
+// variables. The first variable varies slowest. An empty specification
+// yields a single combination with no variables.
+func parseMatrix(spec string) ([]map[string]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return []map[string]string{nil}, nil
+	}
+
+	type axis struct {
+		key    string
+		values []string
+	}
+	var axes []axis
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, rawValues, ok := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected \"name=value1,value2\", got %q", part)
+		}
+		if !isMatrixKey(key) {
+			return nil, fmt.Errorf("invalid variable name %q: use letters, digits, '_' or '-'", key)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("variable %q specified more than once", key)
+		}
+		seen[key] = true
+
+		var values []string
+		for _, v := range strings.Split(rawValues, ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				return nil, fmt.Errorf("variable %q has an empty value", key)
+			}
+			values = append(values, v)
+		}
+		axes = append(axes, axis{key: key, values: values})
+	}
+	if len(axes) == 0 {
+		return []map[string]string{nil}, nil
+	}
+
+	combos := []map[string]string{{}}
+	for _, a := range axes {
+		next := make([]map[string]string, 0, len(combos)*len(a.values))
+		for _, combo := range combos {
+			for _, v := range a.values {
+				c := make(map[string]string, len(combo)+1)
+				for k, existing := range combo {
+					c[k] = existing
+				}
+				c[a.key] = v
+				next = append(next, c)
+			}
+		}
+		combos = next
+	}
+	return combos, nil
+}
+
+// expandMatrix replaces {name} placeholders in s with values from vars.
+// Placeholders that are not plain variable names (e.g. {header:X-Version})
+// are left untouched. A plain placeholder without a matching variable is an
+// error so typos don't silently produce wrong URLs.
+func expandMatrix(s string, vars map[string]string) (string, error) {
+	if len(vars) == 0 {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(s, '{')
+		if start == -1 {
+			b.WriteString(s)
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end == -1 {
+			b.WriteString(s)
+			break
+		}
+		end += start
+
+		name := s[start+1 : end]
+		b.WriteString(s[:start])
+		if isMatrixKey(name) {
+			value, ok := vars[name]
+			if !ok {
+				return "", fmt.Errorf("unknown matrix variable {%s}", name)
+			}
+			b.WriteString(value)
+		} else {
+			b.WriteString(s[start : end+1])
+		}
+		s = s[end+1:]
+	}
+	return b.String(), nil
+}
+
+// isMatrixKey reports whether name is a valid matrix variable name
+func isMatrixKey(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
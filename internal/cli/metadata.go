@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/lucrnz/ripvex/internal/version"
+)
+
+// metadataSuffix is appended to the output name for the --write-metadata file
+const metadataSuffix = ".ripvex.json"
+
+// metadataHeaders are the response headers --write-metadata records: the
+// ones that identify the served version of the file and how it was sent
+var metadataHeaders = []string{
+	"Content-Type", "Content-Length", "Content-Encoding", "Content-Disposition",
+	"ETag", "Last-Modified", "Date", "Server",
+	"Digest", "Repr-Digest", "Content-Digest",
+}
+
+// downloadMetadata is the --write-metadata record of one download
+type downloadMetadata struct {
+	File          string            `json:"file"` // Base name of the output
+	Size          int64             `json:"size"`
+	URL           string            `json:"url"` // As requested
+	EffectiveURL  string            `json:"effective_url"`
+	Redirects     []string          `json:"redirects,omitempty"` // URLs that redirected to EffectiveURL, in order
+	HTTPCode      int               `json:"http_code"`
+	HTTPVersion   string            `json:"http_version"`
+	TLSVersion    string            `json:"tls_version,omitempty"`
+	Headers       map[string]string `json:"headers"`
+	Verified      string            `json:"verified,omitempty"` // algo:digest the body was checked against
+	Digests       map[string]string `json:"digests"`
+	SignatureKey  string            `json:"signature_key_id,omitempty"`
+	Builder       string            `json:"provenance_builder,omitempty"`
+	StartedAt     time.Time         `json:"started_at"`
+	CompletedAt   time.Time         `json:"completed_at"`
+	RipvexVersion string            `json:"ripvex_version"`
+}
+
+// writeMetadataFile writes the audit record of the download of rawURL to
+// <path>.ripvex.json. builder is the builder ID --provenance verified, if any.
+func writeMetadataFile(path, rawURL string, result *downloader.Result, builder string) (string, error) {
+	meta := downloadMetadata{
+		File:          filepath.Base(path),
+		Size:          result.BytesDownloaded,
+		URL:           withoutUserinfo(rawURL),
+		EffectiveURL:  withoutUserinfo(result.URL),
+		HTTPCode:      result.HTTPCode,
+		HTTPVersion:   result.HTTPVersion,
+		TLSVersion:    result.TLSVersion,
+		Headers:       make(map[string]string),
+		Digests:       result.Digests,
+		Builder:       builder,
+		StartedAt:     result.StartTime.UTC(),
+		CompletedAt:   result.StartTime.Add(result.TimeTotal).UTC(),
+		RipvexVersion: version.Print(),
+	}
+	for _, u := range result.Redirects {
+		meta.Redirects = append(meta.Redirects, withoutUserinfo(u))
+	}
+	for _, name := range metadataHeaders {
+		if v := result.Header.Get(name); v != "" {
+			meta.Headers[name] = v
+		}
+	}
+	if result.Hash != "" {
+		meta.Verified = result.HashAlgorithm + ":" + result.Hash
+	}
+	if signingKey != nil {
+		meta.SignatureKey = signingKey.ID()
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	metaPath := path + metadataSuffix
+	if err := os.WriteFile(metaPath, append(data, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("failed to write metadata file: %w", err)
+	}
+	return metaPath, nil
+}
+
+// withoutUserinfo removes credentials from rawURL, so they are never stored
+func withoutUserinfo(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,193 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"golang.org/x/crypto/blake2b"
+)
+
+// errBadSignature is returned when a download does not match its minisign/signify signature
+var errBadSignature = errors.New("signature verification failed")
+
+// Signature algorithms: Ed signs the file itself (signify, legacy minisign),
+// ED signs its BLAKE2b-512 hash (minisign's default since 0.11)
+const (
+	sigAlgEd        = "Ed"
+	sigAlgPrehashed = "ED"
+)
+
+const (
+	untrustedCommentPrefix = "untrusted comment:"
+	trustedCommentPrefix   = "trusted comment: "
+)
+
+// minisignKey is a minisign or signify Ed25519 public key
+type minisignKey struct {
+	id  [8]byte
+	key ed25519.PublicKey
+}
+
+// ID formats the key ID the way minisign prints it
+func (k *minisignKey) ID() string {
+	id := k.id
+	// minisign prints the little-endian key number
+	for i, j := 0, len(id)-1; i < j; i, j = i+1, j-1 {
+		id[i], id[j] = id[j], id[i]
+	}
+	return strings.ToUpper(hex.EncodeToString(id[:]))
+}
+
+// minisignSignature is a parsed .minisig or signify .sig file
+type minisignSignature struct {
+	algorithm      string
+	keyID          [8]byte
+	signature      []byte
+	trustedComment string
+	globalSig      []byte // Signs signature+trustedComment; nil for signify
+}
+
+// parseMinisignKey reads a public key given inline (RWQ...) or as the path
+// to a minisign .pub or signify public key file
+func parseMinisignKey(value string) (*minisignKey, error) {
+	encoded := strings.TrimSpace(value)
+	if data, err := os.ReadFile(value); err == nil {
+		encoded = ""
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, untrustedCommentPrefix) {
+				encoded = line
+			}
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize {
+		return nil, fmt.Errorf("expected a base64 minisign/signify public key (RWQ...) or a file containing one")
+	}
+	if string(raw[:2]) != sigAlgEd {
+		return nil, fmt.Errorf("unsupported public key algorithm %q", raw[:2])
+	}
+	k := &minisignKey{key: ed25519.PublicKey(raw[10:])}
+	copy(k.id[:], raw[2:10])
+	return k, nil
+}
+
+// parseMinisignSignature parses a minisign signature (four lines, with a
+// trusted comment) or a signify signature (two lines)
+func parseMinisignSignature(data []byte) (*minisignSignature, error) {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 2 && len(lines) != 4 {
+		return nil, fmt.Errorf("expected 2 (signify) or 4 (minisign) lines, got %d", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], untrustedCommentPrefix) {
+		return nil, fmt.Errorf("missing %q line", untrustedCommentPrefix)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return nil, fmt.Errorf("malformed signature line")
+	}
+	s := &minisignSignature{algorithm: string(raw[:2]), signature: raw[10:]}
+	copy(s.keyID[:], raw[2:10])
+	if s.algorithm != sigAlgEd && s.algorithm != sigAlgPrehashed {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", s.algorithm)
+	}
+	if len(lines) == 2 {
+		if s.algorithm != sigAlgEd {
+			return nil, fmt.Errorf("prehashed signatures need a trusted comment")
+		}
+		return s, nil
+	}
+
+	comment, ok := strings.CutPrefix(lines[2], trustedCommentPrefix)
+	if !ok {
+		return nil, fmt.Errorf("missing %q line", strings.TrimSpace(trustedCommentPrefix))
+	}
+	s.trustedComment = comment
+	s.globalSig, err = base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(s.globalSig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("malformed trusted comment signature")
+	}
+	return s, nil
+}
+
+// verifyMinisign checks the file at path against sig and key. Prehashed
+// signatures are verified while streaming the file; the legacy form signs
+// the file itself, so it is read into memory, as minisign does.
+func verifyMinisign(path string, key *minisignKey, sig *minisignSignature) error {
+	if sig.keyID != key.id {
+		k := minisignKey{id: sig.keyID}
+		return fmt.Errorf("%w: signed with key %s, not %s", errBadSignature, k.ID(), key.ID())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file for signature verification: %w", err)
+	}
+	defer f.Close()
+
+	var message []byte
+	if sig.algorithm == sigAlgPrehashed {
+		h, _ := blake2b.New512(nil)
+		if _, err := io.Copy(h, f); err != nil {
+			return fmt.Errorf("failed to read file for signature verification: %w", err)
+		}
+		message = h.Sum(nil)
+	} else if message, err = io.ReadAll(f); err != nil {
+		return fmt.Errorf("failed to read file for signature verification: %w", err)
+	}
+
+	if !ed25519.Verify(key.key, message, sig.signature) {
+		return fmt.Errorf("%w: %s does not match its signature", errBadSignature, path)
+	}
+	if sig.globalSig != nil {
+		signed := append(bytes.Clone(sig.signature), sig.trustedComment...)
+		if !ed25519.Verify(key.key, signed, sig.globalSig) {
+			return fmt.Errorf("%w: the trusted comment was altered", errBadSignature)
+		}
+	}
+	return nil
+}
+
+// resolveSignatures reads or fetches the signature of every job
+func resolveSignatures(ctx context.Context, logger *slog.Logger, base downloader.Options, jobs []job) error {
+	for i := range jobs {
+		j := &jobs[i]
+		var data []byte
+		// The key authenticates the signature, so plain http is fine for it
+		if u, err := url.Parse(j.signatureSrc); err == nil && (u.Scheme == "https" || u.Scheme == "http") {
+			logger.Info("signature_fetch", "url", j.signatureSrc)
+			if data, err = fetchSmall(ctx, base, *j, u, "--signature"); err != nil {
+				return err
+			}
+		} else if data, err = os.ReadFile(j.signatureSrc); err != nil {
+			return fmt.Errorf("failed to read --signature: %w", err)
+		}
+
+		sig, err := parseMinisignSignature(data)
+		if err != nil {
+			return withExitCode(ExitUsage, fmt.Errorf("invalid signature %s: %w", j.signatureSrc, err))
+		}
+		if sig.algorithm == sigAlgPrehashed && fipsMode {
+			return withExitCode(ExitUsage, fmt.Errorf("prehashed minisign signatures use BLAKE2b, which is not allowed in FIPS mode"))
+		}
+		j.signature = sig
+	}
+	return nil
+}
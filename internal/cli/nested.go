@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/lucrnz/ripvex/internal/archive"
+	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// nestedProbeSize is how much of a compressed file is decompressed to tell
+// a compressed tarball from a bare compressed file. A bzip2 block can take
+// up to 900 kB before it yields any output.
+const nestedProbeSize = 1 << 20
+
+// nestedArchiveType returns the type of the archive at path, or Unknown for
+// anything that is not an archive. Bare compressed files, such as gzipped
+// man pages, are left alone.
+func nestedArchiveType(path string) (archive.Type, error) {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return archive.Unknown, nil
+	}
+	t, err := archive.Detect(path)
+	if err != nil {
+		return archive.Unknown, fmt.Errorf("error detecting archive type of %s: %w", path, err)
+	}
+	switch t {
+	case archive.Unknown, archive.Zip, archive.Tar, archive.ISO9660:
+		return t, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return archive.Unknown, err
+	}
+	defer f.Close()
+	head, err := io.ReadAll(io.LimitReader(f, nestedProbeSize))
+	if err != nil {
+		return archive.Unknown, err
+	}
+	if !archive.CompressedTar(t, head) {
+		return archive.Unknown, nil
+	}
+	return t, nil
+}
+
+// regularFileBytes sums the sizes of the regular files among paths
+func regularFileBytes(paths []string) int64 {
+	var total int64
+	for _, path := range paths {
+		if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// nestedExtractionError classifies an error of extractNestedArchives as an
+// extraction failure
+func nestedExtractionError(err error) error {
+	return withExitCode(ExitExtraction, fmt.Errorf("error extracting nested archive: %w", err))
+}
+
+// extractNestedArchives extracts the archives found among the files of
+// scope next to themselves, then those found in their content, up to
+// --extract-nested-depth levels. base.MaxBytes bounds everything extracted,
+// the files of the outer archive included; the options selecting entries
+// of the outer archive do not apply. Each nested archive is deleted once
+// extracted, unless --keep-archive is given.
+func extractNestedArchives(ctx context.Context, scope *cleanup.Tracker, logger *slog.Logger, base archive.ExtractOptions) error {
+	maxBytes := base.MaxBytes
+	base.StripComponents = 0
+	base.StripTopLevel = false
+	base.Manifest = nil
+	base.AllowPaths = nil
+	base.MaxDepth = 0
+	base.Lenient = false
+	base.Progress = nil
+
+	files := scope.GetAll()
+	extracted := regularFileBytes(files)
+
+	for level := 1; level <= extractNestedDepth && len(files) > 0; level++ {
+		slices.Sort(files)
+		var next []string
+		for _, path := range files {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			archiveType, err := nestedArchiveType(path)
+			if err != nil {
+				return err
+			}
+			if archiveType == archive.Unknown {
+				continue
+			}
+
+			opts := base
+			opts.DestDir = filepath.Dir(path)
+			if maxBytes > 0 {
+				if extracted >= maxBytes {
+					return fmt.Errorf("%w of %s", archive.ErrMaxBytes, util.HumanReadableBytes(maxBytes))
+				}
+				opts.MaxBytes = maxBytes - extracted
+			}
+
+			logger.Info("nested_archive_detected", "file", path, "type", archiveType, "level", level)
+			inner := scope.Scope()
+			if err := archive.Extract(ctx, inner, path, archiveType, opts); err != nil {
+				if errors.Is(err, archive.ErrMaxBytes) {
+					// Report the overall limit, not what was left of it
+					err = fmt.Errorf("%w of %s", archive.ErrMaxBytes, util.HumanReadableBytes(maxBytes))
+				}
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			innerFiles := inner.GetAll()
+			extracted += regularFileBytes(innerFiles)
+			next = append(next, innerFiles...)
+			logger.Info("nested_archive_extracted", "file", path, "files", len(innerFiles))
+
+			if removeArchive {
+				if err := os.Remove(path); err != nil {
+					logger.Warn("archive_removal_failed", "file", path, "error", err)
+				} else {
+					scope.Unregister(path)
+					logger.Info("archive_removed", "file", path)
+				}
+			}
+		}
+		files = next
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/lucrnz/ripvex/internal/oauth2"
+	"github.com/lucrnz/ripvex/internal/redact"
+)
+
+// oauth2Grant returns the grant the --oauth2-* flags ask for, or "" if none,
+// and checks the flags go together
+func oauth2Grant() (string, error) {
+	var grant string
+	switch {
+	case oauth2ClientCredentials != "" && oauth2Device != "":
+		return "", fmt.Errorf("--oauth2-client-credentials and --oauth2-device are mutually exclusive")
+	case oauth2ClientCredentials != "":
+		grant = oauth2.GrantClientCredentials
+		if oauth2ClientSecret == "" {
+			return "", fmt.Errorf("--oauth2-client-credentials requires --oauth2-client-secret (or RIPVEX_OAUTH2_CLIENT_SECRET)")
+		}
+	case oauth2Device != "":
+		grant = oauth2.GrantDeviceCode
+		if oauth2DeviceAuthURL == "" {
+			return "", fmt.Errorf("--oauth2-device requires --oauth2-device-auth-url")
+		}
+	case oauth2ClientID != "" || oauth2ClientSecret != "" || oauth2Scope != "" || oauth2DeviceAuthURL != "" || oauth2NoCache:
+		return "", fmt.Errorf("the --oauth2-* flags require --oauth2-client-credentials or --oauth2-device")
+	default:
+		return "", nil
+	}
+	if oauth2DeviceAuthURL != "" && grant != oauth2.GrantDeviceCode {
+		return "", fmt.Errorf("--oauth2-device-auth-url requires --oauth2-device")
+	}
+	if oauth2ClientID == "" {
+		return "", fmt.Errorf("--oauth2-client-id (or RIPVEX_OAUTH2_CLIENT_ID) is required")
+	}
+	for _, endpoint := range []string{oauth2ClientCredentials, oauth2Device, oauth2DeviceAuthURL} {
+		if endpoint == "" {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return "", fmt.Errorf("invalid OAuth2 endpoint %q: must be an http or https URL", endpoint)
+		}
+		// The client secret and the token would travel in the clear
+		if u.Scheme == "http" && !allowUnsafeHTTP {
+			return "", fmt.Errorf("OAuth2 endpoint %s uses plain http; use https or --allow-unsafe-http", endpoint)
+		}
+	}
+	redact.Register(oauth2ClientSecret)
+	return grant, nil
+}
+
+// oauth2Token fetches the bearer token of grant with the connection
+// settings of base, from the cache in the user cache directory unless
+// --oauth2-no-cache is set
+func oauth2Token(ctx context.Context, logger *slog.Logger, base downloader.Options, grant string) (string, error) {
+	client, err := downloader.NewClient(ctx, downloader.Options{
+		ConnectTimeout:   base.ConnectTimeout,
+		MaxTime:          base.MaxTime,
+		MaxRedirects:     base.MaxRedirects,
+		RedirectPolicy:   base.RedirectPolicy,
+		HostPolicy:       base.HostPolicy,
+		AllowInsecureTLS: base.AllowInsecureTLS,
+		FIPS:             base.FIPS,
+	})
+	if err != nil {
+		return "", err
+	}
+	cfg := oauth2.Config{
+		Grant:         grant,
+		TokenURL:      oauth2ClientCredentials,
+		DeviceAuthURL: oauth2DeviceAuthURL,
+		ClientID:      oauth2ClientID,
+		ClientSecret:  oauth2ClientSecret,
+		Scope:         oauth2Scope,
+		Client:        client,
+		Prompt:        logging.Stderr,
+		Logger:        logger,
+	}
+	if grant == oauth2.GrantDeviceCode {
+		cfg.TokenURL = oauth2Device
+	}
+	if dir, err := os.UserCacheDir(); err == nil && !oauth2NoCache {
+		cfg.CacheDir = filepath.Join(dir, "ripvex", "oauth2")
+	}
+
+	for _, endpoint := range []string{cfg.TokenURL, cfg.DeviceAuthURL} {
+		if u, err := url.Parse(endpoint); err == nil && endpoint != "" {
+			if err := base.HostPolicy.Check(u); err != nil {
+				return "", &downloader.NetworkError{Err: fmt.Errorf("OAuth2 endpoint refused: %w", err)}
+			}
+		}
+	}
+
+	token, err := cfg.Token(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", context.Cause(ctx)
+		}
+		return "", fmt.Errorf("failed to get an OAuth2 token from %s: %w", cfg.TokenURL, err)
+	}
+	return token, nil
+}
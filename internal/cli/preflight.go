@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/lucrnz/ripvex/internal/progress"
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// errPreflightLimit is returned when the expected total exceeds --preflight-max-bytes
+var errPreflightLimit = errors.New("preflight size limit exceeded")
+
+// preflightHost accumulates the expected transfer from one host
+type preflightHost struct {
+	host    string
+	files   int
+	bytes   int64
+	unknown int // Files whose size the server did not report
+}
+
+// runPreflight sends a HEAD request for every job and logs the expected
+// total and per-host breakdown before anything is downloaded. The batch is
+// refused if the total exceeds maxTotal (0 = no limit) or, on a terminal,
+// if the user does not confirm it.
+func runPreflight(ctx context.Context, logger *slog.Logger, baseOpts downloader.Options, jobs []job, maxTotal int64) error {
+	hosts := make(map[string]*preflightHost)
+	var total int64
+	unknown, missing := 0, 0
+
+	for _, j := range jobs {
+		opts := baseOpts
+		opts.URL = j.url
+		probe, err := downloader.Probe(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("preflight: %s: %w", j.url, err)
+		}
+
+		if probe.HTTPCode == http.StatusNotFound {
+			if !optional {
+				return fmt.Errorf("preflight: %s: %w", j.url, &downloader.HTTPError{StatusCode: probe.HTTPCode, Status: fmt.Sprintf("%d %s", probe.HTTPCode, http.StatusText(probe.HTTPCode))})
+			}
+			logger.Warn("preflight_missing", "url", j.url)
+			missing++
+			continue
+		}
+
+		host := j.parsedURL.Host
+		if u, err := url.Parse(probe.URL); err == nil {
+			// Count bytes against the host that serves them, e.g. a CDN behind a redirect
+			host = u.Host
+		}
+		h, ok := hosts[host]
+		if !ok {
+			h = &preflightHost{host: host}
+			hosts[host] = h
+		}
+		h.files++
+
+		// Servers that reject HEAD or omit Content-Length leave the size unknown
+		if probe.HTTPCode != http.StatusOK || probe.ContentLength < 0 {
+			logger.Debug("preflight_size_unknown", "url", j.url, "status", probe.HTTPCode)
+			h.unknown++
+			unknown++
+			continue
+		}
+		h.bytes += probe.ContentLength
+		total += probe.ContentLength
+	}
+
+	sorted := make([]*preflightHost, 0, len(hosts))
+	for _, h := range hosts {
+		sorted = append(sorted, h)
+	}
+	slices.SortFunc(sorted, func(a, b *preflightHost) int {
+		if c := cmp.Compare(b.bytes, a.bytes); c != 0 {
+			return c
+		}
+		return strings.Compare(a.host, b.host)
+	})
+	for _, h := range sorted {
+		logger.Info("preflight_host", "host", h.host, "files", h.files, "bytes", h.bytes, "size", util.HumanReadableBytes(h.bytes), "unknown_size", h.unknown)
+	}
+	logger.Info("preflight_summary", "files", len(jobs)-missing, "hosts", len(hosts), "total_bytes", total, "total", util.HumanReadableBytes(total), "unknown_size", unknown, "missing", missing)
+
+	if maxTotal > 0 && total > maxTotal {
+		return fmt.Errorf("expected %s exceeds --preflight-max-bytes %s: %w", util.HumanReadableBytes(total), util.HumanReadableBytes(maxTotal), errPreflightLimit)
+	}
+	if maxTotal > 0 && unknown > 0 {
+		logger.Warn("preflight_total_incomplete", "unknown_size", unknown, "hint", "files of unknown size are not counted against --preflight-max-bytes")
+	}
+
+	// Unattended runs (CI) rely on --preflight-max-bytes instead of a prompt
+	if !progress.IsTerminal(os.Stdin) || !progress.IsTerminal(os.Stderr) {
+		return nil
+	}
+	question := fmt.Sprintf("Download %s (%s", plural(len(jobs)-missing, "file"), util.HumanReadableBytes(total))
+	if unknown > 0 {
+		question += fmt.Sprintf(" plus %d of unknown size", unknown)
+	}
+	question += fmt.Sprintf(") from %s? [y/N] ", plural(len(hosts), "host"))
+	ok, err := confirm(ctx, question)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("download aborted after preflight")
+	}
+	return nil
+}
+
+// confirm asks a yes/no question on the terminal. The read runs in the
+// background so an interrupt is not stuck behind it.
+func confirm(ctx context.Context, question string) (bool, error) {
+	fmt.Fprint(os.Stderr, question)
+
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer <- line
+	}()
+
+	select {
+	case line := <-answer:
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true, nil
+		}
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// plural formats a count with a singular or plural noun, e.g. "1 file", "2 files"
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
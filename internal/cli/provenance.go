@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+)
+
+// errProvenance is returned when a download is not covered by its attestation
+var errProvenance = errors.New("provenance verification failed")
+
+// in-toto and SLSA type URIs
+const (
+	dssePayloadType       = "application/vnd.in-toto+json"
+	inTotoStatementV01    = "https://in-toto.io/Statement/v0.1"
+	inTotoStatementV1     = "https://in-toto.io/Statement/v1"
+	slsaProvenancePrefix  = "https://slsa.dev/provenance/"
+	slsaProvenanceV02     = "https://slsa.dev/provenance/v0.2"
+	provenanceSubjectAlgo = "sha256"
+)
+
+// inTotoStatement is the part of an in-toto statement with SLSA provenance
+// that ripvex checks
+type inTotoStatement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string `json:"predicateType"`
+	Predicate     struct {
+		Builder struct { // SLSA v0.2
+			ID string `json:"id"`
+		} `json:"builder"`
+		RunDetails struct { // SLSA v1
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+		} `json:"runDetails"`
+	} `json:"predicate"`
+}
+
+// builderID returns the builder of the statement for its SLSA version
+func (s *inTotoStatement) builderID() string {
+	if s.PredicateType == slsaProvenanceV02 {
+		return s.Predicate.Builder.ID
+	}
+	return s.Predicate.RunDetails.Builder.ID
+}
+
+// dsseEnvelope wraps a statement in a DSSE envelope
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+}
+
+// provenance holds the SLSA provenance statements of an attestation file
+type provenance struct {
+	source     string
+	statements []inTotoStatement
+}
+
+// parseProvenance reads the SLSA provenance statements of an attestation:
+// bare in-toto statements, DSSE envelopes or Sigstore bundles, either as a
+// single JSON document or one per line (.intoto.jsonl). Statements with
+// other predicates, such as SBOMs, are skipped.
+func parseProvenance(source string, data []byte) (*provenance, error) {
+	p := &provenance{source: source}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+
+		var doc struct {
+			Type         string        `json:"_type"`
+			DSSEEnvelope *dsseEnvelope `json:"dsseEnvelope"` // Sigstore bundle
+			dsseEnvelope
+		}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, fmt.Errorf("invalid attestation: %w", err)
+		}
+		envelope := doc.dsseEnvelope
+		if doc.DSSEEnvelope != nil {
+			envelope = *doc.DSSEEnvelope
+		}
+		statement := []byte(raw)
+		if doc.Type == "" {
+			if envelope.PayloadType != dssePayloadType {
+				return nil, fmt.Errorf("expected an in-toto statement, DSSE envelope or Sigstore bundle")
+			}
+			payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DSSE payload: %w", err)
+			}
+			statement = payload
+		}
+
+		var s inTotoStatement
+		if err := json.Unmarshal(statement, &s); err != nil {
+			return nil, fmt.Errorf("invalid in-toto statement: %w", err)
+		}
+		if s.Type != inTotoStatementV01 && s.Type != inTotoStatementV1 {
+			return nil, fmt.Errorf("unsupported in-toto statement type %q", s.Type)
+		}
+		if strings.HasPrefix(s.PredicateType, slsaProvenancePrefix) {
+			p.statements = append(p.statements, s)
+		}
+	}
+	if len(p.statements) == 0 {
+		return nil, fmt.Errorf("no SLSA provenance statement found")
+	}
+	return p, nil
+}
+
+// verify checks that a statement lists digest (sha256) as a subject and was
+// made by builder, and returns that statement
+func (p *provenance) verify(name, digest, builder string) (*inTotoStatement, error) {
+	var others []string
+	for i := range p.statements {
+		s := &p.statements[i]
+		for _, subject := range s.Subject {
+			if !strings.EqualFold(subject.Digest[provenanceSubjectAlgo], digest) {
+				continue
+			}
+			if builderMatches(s.builderID(), builder) {
+				return s, nil
+			}
+			others = append(others, s.builderID())
+		}
+	}
+	if len(others) > 0 {
+		return nil, fmt.Errorf("%w: %s was built by %s, not %s", errProvenance, name, strings.Join(others, ", "), builder)
+	}
+	return nil, fmt.Errorf("%w: %s (sha256:%s) is not a subject of %s", errProvenance, name, digest, p.source)
+}
+
+// builderMatches compares builder IDs. Reusable workflow builders carry the
+// ref they ran at ("...@refs/tags/v2.0.0"); an expected ID without a ref
+// accepts any of them.
+func builderMatches(actual, expected string) bool {
+	if actual == expected {
+		return true
+	}
+	name, _, ok := strings.Cut(actual, "@")
+	return ok && !strings.Contains(expected, "@") && name == expected
+}
+
+// resolveProvenance reads or fetches the attestation of every job, once
+// per distinct source
+func resolveProvenance(ctx context.Context, logger *slog.Logger, base downloader.Options, jobs []job) error {
+	parsed := make(map[string]*provenance)
+	for i := range jobs {
+		j := &jobs[i]
+		if p, ok := parsed[j.provenanceSrc]; ok {
+			j.provenance = p
+			continue
+		}
+
+		var data []byte
+		if u, err := url.Parse(j.provenanceSrc); err == nil && (u.Scheme == "https" || u.Scheme == "http") {
+			if u.Scheme == "http" && !allowUnsafeHTTP {
+				return withExitCode(ExitUsage, fmt.Errorf("a plain http --provenance requires --allow-unsafe-http"))
+			}
+			logger.Info("provenance_fetch", "url", j.provenanceSrc)
+			if data, err = fetchSmall(ctx, base, *j, u, "--provenance"); err != nil {
+				return err
+			}
+		} else if data, err = os.ReadFile(j.provenanceSrc); err != nil {
+			return fmt.Errorf("failed to read --provenance: %w", err)
+		}
+
+		p, err := parseProvenance(j.provenanceSrc, data)
+		if err != nil {
+			return withExitCode(ExitUsage, fmt.Errorf("invalid provenance %s: %w", j.provenanceSrc, err))
+		}
+		parsed[j.provenanceSrc] = p
+		j.provenance = p
+	}
+	return nil
+}
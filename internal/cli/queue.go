@@ -0,0 +1,485 @@
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/lucrnz/ripvex/internal/util"
+	"github.com/spf13/cobra"
+)
+
+// queueVersion is the format version of the queue file
+const queueVersion = 1
+
+var queueFilePath string
+
+// queueState is the queue file: the downloads that have not completed yet
+type queueState struct {
+	Version int         `json:"version"`
+	Jobs    []queuedJob `json:"jobs"`
+}
+
+// queuedJob is a download added with `ripvex queue add`. Paths are absolute,
+// so `ripvex queue run` can run from any directory.
+type queuedJob struct {
+	ID             string    `json:"id"`
+	URL            string    `json:"url"`
+	Output         string    `json:"output"`
+	OutputExplicit bool      `json:"output_explicit,omitempty"`
+	OutputDir      string    `json:"output_dir,omitempty"` // Directory of a server-derived name
+	Hash           string    `json:"hash,omitempty"`
+	AddedAt        time.Time `json:"added_at"`
+	Attempts       int       `json:"attempts,omitempty"`   // Failed runs
+	LastError      string    `json:"last_error,omitempty"` // Error of the last failed run
+}
+
+// newQueueCmd returns `ripvex queue`, a download queue kept in a state file
+// so that an interrupted batch resumes where it stopped
+func newQueueCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "queue",
+		Short: "Queue downloads and run them, resuming across runs",
+		Long: `Queue downloads and run them, resuming across runs.
+
+"ripvex queue add" records downloads in a queue file, and "ripvex queue run"
+downloads them one after the other. Every download uses --partial, so one
+interrupted by Ctrl-C, a crash or a reboot resumes from its <output>.part on
+the next run. Completed downloads leave the queue; failed ones stay in it
+with their error and are tried again by the next run.
+
+The queue file is $XDG_STATE_HOME/ripvex/queue.json, by default
+~/.local/state/ripvex/queue.json.`,
+		Example: `  ripvex queue add https://example.com/file.iso -H sha256:abc123...
+  ripvex queue add --output-dir /srv/mirror https://example.com/a.tar.gz https://example.com/b.tar.gz
+  ripvex queue run
+  ripvex queue list`,
+	}
+	cmd.PersistentFlags().StringVar(&queueFilePath, "queue-file", "", "Queue file (default: $XDG_STATE_HOME/ripvex/queue.json or ~/.local/state/ripvex/queue.json)")
+
+	add := &cobra.Command{
+		Use:   "add <URL>...",
+		Short: "Add downloads to the queue",
+		Long: `Add downloads to the queue. Outputs are resolved like for "ripvex URL",
+relative to the working directory, and stored as absolute paths. --output
+and --hash apply to a single URL.`,
+		Args:    usageArgs(cobra.MinimumNArgs(1)),
+		PreRunE: applyEnv,
+		RunE:    runQueueAdd,
+	}
+	add.ValidArgsFunction = cobra.NoFileCompletions
+	shareFlags(add, "output", "output-dir", "hash", "allow-unsafe-http", "quiet", "log-level", "log-format", "log-file")
+
+	run := &cobra.Command{
+		Use:   "run",
+		Short: "Download the queued jobs, resuming interrupted ones",
+		Long: `Download the queued jobs in the order they were added, resuming the
+parts interrupted runs left. A job is removed from the queue once its
+download completes. Failed jobs are recorded and the run goes on; it exits
+with the code of the first failure.
+
+Several runs may share a queue: each output is locked while it downloads,
+and a job another run completed is skipped.`,
+		Args:    usageArgs(cobra.NoArgs),
+		PreRunE: applyEnv,
+		RunE:    runQueueRun,
+	}
+	shareFlags(run, "max-bytes", "connect-timeout", "max-redirs", "user-agent", "allow-host", "deny-host", "auth-helper", "netrc-file", "no-netrc",
+		"quiet", "log-level", "log-format", "log-file", "progress", "progress-fd", "progress-interval", "log-progress-step", "log-progress-step-unknown")
+
+	list := &cobra.Command{
+		Use:     "list",
+		Short:   "List the queued jobs",
+		Args:    usageArgs(cobra.NoArgs),
+		PreRunE: applyEnv,
+		RunE:    runQueueList,
+	}
+
+	remove := &cobra.Command{
+		Use:     "remove <id>...",
+		Short:   "Remove jobs from the queue, with their partial downloads",
+		Args:    usageArgs(cobra.MinimumNArgs(1)),
+		PreRunE: applyEnv,
+		RunE:    runQueueRemove,
+	}
+	remove.ValidArgsFunction = cobra.NoFileCompletions
+	shareFlags(remove, "quiet", "log-level", "log-format", "log-file")
+
+	cmd.AddCommand(add, run, list, remove)
+	return cmd
+}
+
+// queueFile returns the path of the queue file
+func queueFile() (string, error) {
+	if queueFilePath != "" {
+		return filepath.Abs(queueFilePath)
+	}
+	dir := os.Getenv("XDG_STATE_HOME")
+	if !filepath.IsAbs(dir) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot locate the queue file, set --queue-file: %w", err)
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "ripvex", "queue.json"), nil
+}
+
+// readQueue reads the queue file. A missing file is an empty queue.
+func readQueue(path string) (*queueState, error) {
+	state := &queueState{Version: queueVersion}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue file: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid queue file %s: %w", path, err)
+	}
+	if state.Version > queueVersion {
+		return nil, fmt.Errorf("queue file %s was written by a newer ripvex (version %d)", path, state.Version)
+	}
+	return state, nil
+}
+
+// updateQueue applies update to the queue file. The file is locked against
+// other ripvex processes and replaced atomically, so a crash never leaves
+// it half-written.
+func updateQueue(ctx context.Context, logger *slog.Logger, path string, update func(*queueState) error) error {
+	// The queue may record URLs with credentials
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create queue directory: %w", err)
+	}
+	lock, err := lockOutput(ctx, logger, path, -1)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	state, err := readQueue(path)
+	if err != nil {
+		return err
+	}
+	if err := update(state); err != nil {
+		return err
+	}
+	state.Version = queueVersion
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write queue file: %w", err)
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write queue file: %w", err)
+	}
+	return nil
+}
+
+func runQueueAdd(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 && (output != "" || expectedHash != "") {
+		return withExitCode(ExitUsage, fmt.Errorf("--output and --hash apply to a single URL"))
+	}
+	if output == "-" {
+		return withExitCode(ExitUsage, fmt.Errorf("queued downloads need a file output, not stdout (-)"))
+	}
+	hashAlgo, hashDigest, err := parseExpectedHash(expectedHash)
+	if err != nil {
+		return withExitCode(ExitUsage, fmt.Errorf("invalid hash: %w", err))
+	}
+	path, err := queueFile()
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	ctx, logger, err := setupLogger(cmd.Context())
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+
+	var added []queuedJob
+	for _, arg := range args {
+		urlStr = arg
+		j, err := newJob(nil)
+		if err != nil {
+			return withExitCode(ExitUsage, err)
+		}
+		if j.parsedURL.Scheme == "http" && hashDigest == "" && !allowUnsafeHTTP {
+			return withExitCode(ExitUsage, fmt.Errorf("plain http downloads require --hash or --allow-unsafe-http"))
+		}
+		qj := queuedJob{ID: newQueueID(), URL: j.url, OutputExplicit: j.outputExplicit, AddedAt: time.Now().UTC()}
+		if hashDigest != "" {
+			qj.Hash = hashAlgo + ":" + hashDigest
+		}
+		if qj.Output, err = filepath.Abs(j.output); err != nil {
+			return fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		if j.outputDir != "" {
+			if qj.OutputDir, err = filepath.Abs(j.outputDir); err != nil {
+				return fmt.Errorf("failed to get absolute path: %w", err)
+			}
+		}
+		added = append(added, qj)
+	}
+
+	err = updateQueue(ctx, logger, path, func(state *queueState) error {
+		for _, qj := range added {
+			for _, other := range state.Jobs {
+				if other.Output == qj.Output {
+					return withExitCode(ExitUsage, fmt.Errorf("%s is already queued as job %s", qj.Output, other.ID))
+				}
+			}
+			state.Jobs = append(state.Jobs, qj)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, qj := range added {
+		logger.Info("queue_job_added", "id", qj.ID, "url", withoutUserinfo(qj.URL), "output", qj.Output, "queue", path)
+	}
+	return nil
+}
+
+func runQueueRun(cmd *cobra.Command, args []string) (err error) {
+	// Everything that fails before the first download is a usage/setup error
+	downloading := false
+	defer func() {
+		if err != nil && !downloading && cmd.Context().Err() == nil {
+			err = withExitCode(ExitUsage, err)
+		}
+	}()
+
+	ctx := cmd.Context()
+	tracker, ok := ctx.Value(trackerKey).(*cleanup.Tracker)
+	if !ok || tracker == nil {
+		return fmt.Errorf("internal error: cleanup tracker not found in context")
+	}
+	maxBytes, err := util.ParseByteSize(maxBytesStr)
+	if err != nil {
+		return fmt.Errorf("invalid --max-bytes value: %w", err)
+	}
+	connectTimeout, err := util.ParseDuration(connectTimeoutStr)
+	if err != nil {
+		return fmt.Errorf("invalid --connect-timeout value: %w", err)
+	}
+	if maxRedirects < 0 {
+		return fmt.Errorf("--max-redirs must be non-negative, got %d", maxRedirects)
+	}
+	progressInterval, err := parseProgressFlags()
+	if err != nil {
+		return err
+	}
+	path, err := queueFile()
+	if err != nil {
+		return err
+	}
+	ctx, logger, err := setupLogger(ctx)
+	if err != nil {
+		return err
+	}
+	progressLogger, progressTerminal, err := newProgressOutput(nil)
+	if err != nil {
+		return err
+	}
+	hostPolicy, err := newHostPolicy()
+	if err != nil {
+		return err
+	}
+	tokens, err := newAuthHelper()
+	if err != nil {
+		return err
+	}
+	netrcCreds, err := loadNetrc(logger)
+	if err != nil {
+		return err
+	}
+	state, err := readQueue(path)
+	if err != nil {
+		return err
+	}
+	if len(state.Jobs) == 0 {
+		logger.Info("queue_empty", "queue", path)
+		return nil
+	}
+
+	downloading = true
+	baseOpts := downloader.Options{
+		Quiet:                  quiet,
+		ConnectTimeout:         connectTimeout,
+		MaxRedirects:           maxRedirects,
+		HostPolicy:             hostPolicy,
+		UserAgent:              userAgent,
+		MaxBytes:               maxBytes,
+		AuthHelper:             tokens,
+		Netrc:                  netrcCreds,
+		Preallocate:            true,
+		Partial:                true,
+		ProgressInterval:       progressInterval,
+		LogFormat:              logFormat,
+		LogProgressStep:        logProgressStep,
+		LogProgressStepUnknown: logProgressStepUnknown,
+		ProgressLogger:         progressLogger,
+		ProgressTerminal:       progressTerminal,
+	}
+	var failed int
+	var firstErr error
+	for i, qj := range state.Jobs {
+		logger.Info("queue_job_start", "id", qj.ID, "url", withoutUserinfo(qj.URL), "output", qj.Output, "job", i+1, "jobs", len(state.Jobs))
+		err := runQueuedJob(ctx, tracker, logger, path, baseOpts, qj)
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			// The job stays queued, and its part is resumed by the next run
+			return err
+		}
+		logger.Warn("queue_job_failed", "id", qj.ID, "error", err)
+		failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+		recordErr := updateQueue(ctx, logger, path, func(state *queueState) error {
+			if i := slices.IndexFunc(state.Jobs, func(j queuedJob) bool { return j.ID == qj.ID }); i != -1 {
+				state.Jobs[i].Attempts++
+				state.Jobs[i].LastError = err.Error()
+			}
+			return nil
+		})
+		if recordErr != nil {
+			return recordErr
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d queued downloads failed and stay in the queue: %w", failed, len(state.Jobs), firstErr)
+	}
+	logger.Info("queue_complete", "jobs", len(state.Jobs))
+	return nil
+}
+
+// runQueuedJob downloads qj and removes it from the queue. A job that is no
+// longer queued once its output is locked was completed or removed by
+// another process, and is skipped.
+func runQueuedJob(ctx context.Context, tracker *cleanup.Tracker, logger *slog.Logger, path string, opts downloader.Options, qj queuedJob) error {
+	if err := os.MkdirAll(filepath.Dir(qj.Output), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	lock, err := lockOutput(ctx, logger, qj.Output, -1)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	state, err := readQueue(path)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(state.Jobs, func(j queuedJob) bool { return j.ID == qj.ID }) {
+		logger.Info("queue_job_skipped", "id", qj.ID, "reason", "no longer queued")
+		return nil
+	}
+
+	opts.URL = qj.URL
+	opts.Output = qj.Output
+	opts.OutputExplicit = qj.OutputExplicit
+	opts.OutputDir = qj.OutputDir
+	if opts.HashAlgorithm, opts.ExpectedHash, err = parseExpectedHash(qj.Hash); err != nil {
+		return fmt.Errorf("invalid hash: %w", err)
+	}
+	scope := tracker.Scope()
+	if _, err := downloader.Download(ctx, scope, opts); err != nil {
+		scope.Cleanup()
+		return err
+	}
+	scope.Release()
+	return updateQueue(ctx, logger, path, func(state *queueState) error {
+		state.Jobs = slices.DeleteFunc(state.Jobs, func(j queuedJob) bool { return j.ID == qj.ID })
+		return nil
+	})
+}
+
+func runQueueList(cmd *cobra.Command, args []string) error {
+	path, err := queueFile()
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	state, err := readQueue(path)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tPART\tOUTPUT\tURL\tLAST ERROR")
+	for _, qj := range state.Jobs {
+		size := downloader.PartialSize(qj.Output)
+		status, part := "pending", "-"
+		if size > 0 {
+			status, part = "partial", util.HumanReadableBytes(size)
+		}
+		if qj.LastError != "" {
+			status = fmt.Sprintf("failed (%d)", qj.Attempts)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", qj.ID, status, part, qj.Output, withoutUserinfo(qj.URL), qj.LastError)
+	}
+	return w.Flush()
+}
+
+func runQueueRemove(cmd *cobra.Command, args []string) error {
+	path, err := queueFile()
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	ctx, logger, err := setupLogger(cmd.Context())
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	var removed []queuedJob
+	err = updateQueue(ctx, logger, path, func(state *queueState) error {
+		for _, id := range args {
+			i := slices.IndexFunc(state.Jobs, func(j queuedJob) bool { return j.ID == id })
+			if i == -1 {
+				return withExitCode(ExitUsage, fmt.Errorf("no queued job %q", id))
+			}
+			removed = append(removed, state.Jobs[i])
+			state.Jobs = slices.Delete(state.Jobs, i, i+1)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, qj := range removed {
+		downloader.DiscardPartial(qj.Output)
+		logger.Info("queue_job_removed", "id", qj.ID, "output", qj.Output)
+	}
+	return nil
+}
+
+// newQueueID returns a random job ID
+func newQueueID() string {
+	return strings.ToLower(rand.Text()[:8])
+}
@@ -2,14 +2,28 @@ package cli
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/base32"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -17,42 +31,138 @@ import (
 	"github.com/lucrnz/ripvex/internal/archive"
 	"github.com/lucrnz/ripvex/internal/cleanup"
 	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/lucrnz/ripvex/internal/fips"
 	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/lucrnz/ripvex/internal/progress"
+	"github.com/lucrnz/ripvex/internal/redact"
+	"github.com/lucrnz/ripvex/internal/sandbox"
 	"github.com/lucrnz/ripvex/internal/util"
 	"github.com/lucrnz/ripvex/internal/version"
+	"lukechampine.com/blake3"
 )
 
 var (
 	urlStr                    string
 	output                    string
+	outputDir                 string
 	quiet                     bool
+	verbose                   int
+	tracePath                 string
+	dumpHeaderPath            string
+	dumpHeaderRedirects       bool
 	expectedHash              string
+	printHash                 string
+	writeChecksum             bool
+	writeMetadata             bool
+	streamUnverified          bool
+	partial                   bool
+	chmodStr                  string
+	syncOutput                bool
+	fsyncIntervalStr          string
+	noPreallocate             bool
+	lockTimeoutStr            string
+	execPre                   string
+	execPostDownload          string
+	execPostExtract           string
+	hashFromHeaders           bool
+	hashURL                   string
+	paranoid                  bool
+	xattr                     bool
+	minisignKeyStr            string
+	signature                 string
+	provenanceSrc             string
+	provenanceBuilder         string
 	extractArchive            bool
 	removeArchive             bool
+	keepArchive               bool
+	extractIfMissing          string
+	skipVerified              bool
+	preflight                 bool
+	preflightMaxBytesStr      string
 	chdir                     string
 	chdirCreate               bool
 	stripComponents           int
+	stripTopLevel             bool
+	extractAtomic             bool
+	extractDir                string
+	extractAllowPaths         []string
+	extractMaxDepth           int
+	lenient                   bool
+	extractNested             bool
+	preservePermissions       bool
+	noUmask                   bool
+	preserveMtime             bool
+	noMtime                   bool
+	extractOverwrite          bool
+	extractMaxFileBytesStr    string
+	extractSkipExisting       bool
+	extractKeepNewer          bool
+	extractLinks              string
+	extractStrictNames        bool
+	zipCharset                string
+	zstdDictPath              string
+	archiveTypeStr            string
+	verifyManifestPath        string
+	extractStream             bool
+	extractNestedDepth        int
+	extractSandbox            bool
 	connectTimeoutStr         string
 	downloadMaxTimeStr        string
 	progressIntervalStr       string
+	progressMode              string
+	progressFD                int
 	logProgressStepUnknownStr string
 	logLevel                  string
 	logFormat                 string
+	logFilePath               string
 	logProgressStep           int
 	logProgressStepUnknown    int64
 	maxRedirects              int
+	redirectPolicy            string
+	allowHosts                []string
+	denyHosts                 []string
 	userAgent                 string
 	maxBytesStr               string
 	extractMaxBytesStr        string
 	extractTimeoutStr         string
 	allowInsecureTLS          bool
+	fipsMode                  bool
 	allowUnsafeHTTP           bool
+	requireHash               bool
 	headers                   []string
+	assertHeaders             []string
+	maxAgeStr                 string
+	maxAgeWarn                bool
+	writeOut                  string
+	matrix                    string
+	optional                  bool
+	inferExtension            bool
 	auth                      string
 	authBearer                string
 	authBasicUser             string
 	authBasicPass             string
 	authBasic                 string
+	authBearerFile            string
+	authBasicPassFile         string
+	authPrompt                bool
+	authHelper                string
+	oauth2ClientCredentials   string
+	oauth2Device              string
+	oauth2DeviceAuthURL       string
+	oauth2ClientID            string
+	oauth2ClientSecret        string
+	oauth2Scope               string
+	oauth2NoCache             bool
+	netrcFile                 string
+	noNetrc                   bool
+
+	// Parsed once in run and shared by every job
+	extractTimeout    time.Duration
+	forcedArchiveType archive.Type // Unknown unless --archive-type is set
+	writeOutTemplate  *template.Template
+	printHashAlgos    []string
+	signingKey        *minisignKey
+	fileMode          *os.FileMode // Set by --chmod
 )
 
 // trackerKeyType is a private type for context key to store the cleanup tracker
@@ -61,7 +171,7 @@ type trackerKeyType struct{}
 var trackerKey = trackerKeyType{}
 
 var rootCmd = &cobra.Command{
-	Use:   "ripvex",
+	Use:   "ripvex [URL]",
 	Short: "Your Swiss-Army Knife for downloading files",
 	Long: `ripvex
 
@@ -70,42 +180,130 @@ Lightweight Go program for downloading files from URLs with optional hash integr
 Copyright (c) 2025 Luciano Hillcoat.
 This program is open-source and warranty-free, read more at: https://github.com/lucrnz/ripvex/blob/main/LICENSE
 `,
+	Args:    usageArgs(cobra.MaximumNArgs(1)),
+	PreRunE: applyEnv,
 	RunE:    run,
 	Version: version.Print(),
 }
 
 func init() {
-	rootCmd.Flags().StringVarP(&urlStr, "url", "U", "", "The URL to download (required)")
-	rootCmd.Flags().StringVarP(&output, "output", "O", "", "The name for the file to write it as")
+	rootCmd.Flags().StringVarP(&urlStr, "url", "U", "", "The URL to download (required unless given as an argument)")
+	rootCmd.Flags().StringVarP(&output, "output", "O", "", "The name for the file to write it as, or an existing directory to save the server-derived name in")
+	rootCmd.Flags().StringVar(&outputDir, "output-dir", "", "Directory to save the download in, created if missing. The file keeps the URL or Content-Disposition name, or a relative --output name. Unlike --chdir, extraction still happens in the working directory. Matrix variables are expanded")
+	rootCmd.Flags().BoolVar(&inferExtension, "infer-extension", false, "When the URL and Content-Disposition give no file extension, add one from the file's magic bytes or Content-Type (e.g. download -> download.tar.gz)")
+	rootCmd.Flags().BoolVar(&optional, "optional", false, "Treat HTTP 404 as a skipped download (exit 0) instead of a failure. Applies to each --matrix item")
+	rootCmd.Flags().BoolVar(&preflight, "preflight", false, "Send a HEAD request for every download first, log the expected total and per-host sizes, and ask for confirmation on a terminal")
+	rootCmd.Flags().StringVar(&preflightMaxBytesStr, "preflight-max-bytes", "", "Refuse to start unless the preflight total is at most this size (e.g. \"50GiB\"). Implies --preflight")
+	rootCmd.Flags().StringVar(&matrix, "matrix", "", "Download every combination of variables, e.g. \"os=linux,darwin;arch=amd64,arm64\". Reference them as {os} and {arch} in --url and --output")
 	rootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Does not show any progress or output")
-	rootCmd.Flags().StringVarP(&expectedHash, "hash", "H", "", "Expected hash with algorithm prefix (e.g., sha256:xxxxx... or sha512:xxxxx...). Supported algorithms: sha256, sha512")
+	rootCmd.Flags().StringVarP(&dumpHeaderPath, "dump-header", "D", "", "Write the final response status line and headers to this file (\"-\" for stdout)")
+	rootCmd.Flags().BoolVar(&dumpHeaderRedirects, "dump-header-redirects", false, "Also write the headers of each redirect response (requires --dump-header)")
+	rootCmd.Flags().StringArrayVar(&assertHeaders, "assert-header", []string{}, "Fail unless the response satisfies a header predicate: \"Name: glob\" (value matches, '*' = any), \"Name\" (present) or \"!Name\" (absent). Can be specified multiple times.")
+	rootCmd.Flags().StringVar(&maxAgeStr, "max-age", "", "Fail before downloading when Last-Modified shows the resource is older than this (e.g. \"7d\", \"12h\"), measured against the server's Date")
+	rootCmd.Flags().BoolVar(&maxAgeWarn, "max-age-warn", false, "Only log a warning when --max-age is exceeded")
+	rootCmd.Flags().StringVarP(&writeOut, "write-out", "w", "", "Print a Go template to stdout after each download, e.g. '{{.HTTPCode}} {{.BytesDownloaded}} {{.TimeTotal}} {{.Filename}}\\n'")
+	rootCmd.Flags().StringVar(&tracePath, "trace", "", "Write DNS, connect, TLS, header and timing events as JSON lines to this file")
+	rootCmd.Flags().CountVarP(&verbose, "verbose", "v", "Print request/response headers, redirects and TLS details to stderr (repeat for connection events). Credentials are redacted")
+	rootCmd.Flags().StringVarP(&expectedHash, "hash", "H", "", "Expected hash with algorithm prefix (e.g., sha256:xxxxx... or sha512:xxxxx...). Supported algorithms: sha256, sha512, blake3, and the weak legacy sha1 and md5 and non-cryptographic crc32 and crc32c (with a warning)")
+	rootCmd.Flags().BoolVar(&hashFromHeaders, "hash-from-headers", false, "Without --hash, verify against a digest the server advertises (GCS x-goog-hash, S3 x-amz-checksum-*, Content-MD5), preferring the strongest. Detects corruption, not tampering")
+	rootCmd.Flags().BoolVar(&paranoid, "paranoid", false, "Always compute a CRC-32C of the body, even without --hash, and log it (and expose it to --write-out) as a cheap integrity baseline")
+	rootCmd.Flags().BoolVar(&xattr, "xattr", false, "Record the source URL, ETag and verified digest in user.ripvex.* extended attributes of the output file (and user.xdg.origin.url, like wget --xattr)")
+	rootCmd.Flags().StringVar(&minisignKeyStr, "minisign-key", "", "Verify the download against a minisign or signify signature made with this Ed25519 public key (RWQ... or a .pub file) before extracting it")
+	rootCmd.Flags().StringVar(&signature, "signature", "", "Signature for --minisign-key: a local path or an http(s) URL (default: the download URL + \".minisig\"). Matrix variables are expanded")
+	rootCmd.Flags().StringVar(&provenanceSrc, "provenance", "", "Verify that an in-toto/SLSA provenance attestation (a local path or an https URL; .intoto.jsonl, DSSE envelope or Sigstore bundle) lists the download's SHA-256 as a subject. Requires --provenance-builder. Matrix variables are expanded")
+	rootCmd.Flags().StringVar(&provenanceBuilder, "provenance-builder", "", "Builder ID the --provenance attestation must name (e.g. https://github.com/slsa-framework/slsa-github-generator/.github/workflows/generator_generic_slsa3.yml); without an @ref, any ref matches")
+	rootCmd.Flags().StringVar(&hashURL, "hash-url", "", "Fetch a checksum file (e.g. SHA256SUMS) and verify the download against its line for the downloaded file name. Matrix variables are expanded")
+	rootCmd.Flags().BoolVar(&streamUnverified, "stream-unverified", false, "With --output - and --hash, stream to stdout while downloading instead of buffering in a temp file. The hash is checked at the end and a mismatch exits 5, but the data has already been written: only use it when the consumer discards its output on failure")
+	rootCmd.Flags().BoolVar(&partial, "partial", false, "Download to <output>.part, keeping it with its URL, ETag and size in <output>.part.json if the download fails or is interrupted. The next run with --partial resumes it with a range request if the server still has the same file")
+	rootCmd.Flags().StringVar(&chmodStr, "chmod", "", "Set the permissions of the downloaded file to this octal mode (e.g. 0755 for a binary or installer script), regardless of the umask")
+	rootCmd.Flags().BoolVar(&syncOutput, "sync", false, "Flush the downloaded file and its directory to stable storage (fsync) before reporting success, so the file survives a crash or power loss")
+	rootCmd.Flags().StringVar(&fsyncIntervalStr, "fsync-interval-bytes", "", "Also flush the output file every this many bytes while downloading (e.g. \"64MiB\"), bounding the unwritten data of very large files. Implies --sync")
+	rootCmd.Flags().BoolVar(&noPreallocate, "no-preallocate", false, "Do not reserve disk space for the output file when the server sends its size. Preallocation avoids fragmentation and fails early when the disk is full, but some filesystems (e.g. copy-on-write or compressed ones) gain nothing from it")
+	rootCmd.Flags().StringVar(&lockTimeoutStr, "lock-timeout", "", "How long to wait for another ripvex process writing the same output, which holds <output>.lock, before failing (e.g. \"30s\"; 0 fails at once). Waits without limit by default")
+	rootCmd.Flags().BoolVar(&writeChecksum, "write-checksum", false, "Write the SHA-256 of each downloaded file to <output>.sha256 in sha256sum format")
+	rootCmd.Flags().BoolVar(&writeMetadata, "write-metadata", false, "Write an audit record of each download to <output>.ripvex.json: source URL, redirect chain, key response headers, digests, timestamps and ripvex version")
+	rootCmd.Flags().StringVar(&printHash, "print-hash", "", "Print the digest of each downloaded file in --hash format, for one or more comma-separated algorithms (e.g. \"sha256\" or \"sha256,sha512\")")
+	rootCmd.Flags().StringVar(&execPre, "exec-pre", "", "Shell command to run before each download. RIPVEX_HOOK_URL and RIPVEX_HOOK_PATH describe it; a failing command aborts the download")
+	rootCmd.Flags().StringVar(&execPostDownload, "exec-post-download", "", "Shell command to run after each download is verified, before extraction (e.g. a virus scan). RIPVEX_HOOK_PATH, RIPVEX_HOOK_URL, RIPVEX_HOOK_HASH, RIPVEX_HOOK_BYTES and more describe the file; a failing command removes it and fails the run")
+	rootCmd.Flags().StringVar(&execPostExtract, "exec-post-extract", "", "Shell command to run after each archive is extracted, with RIPVEX_HOOK_EXTRACT_DIR set as well. A failing command fails the run; the extracted files and the archive are kept")
 	rootCmd.Flags().BoolVarP(&extractArchive, "extract-archive", "x", false, "Extract the downloaded archive")
-	rootCmd.Flags().BoolVar(&removeArchive, "remove-archive", true, "Delete archive file after successful extraction")
+	rootCmd.Flags().BoolVar(&removeArchive, "remove-archive", true, "Delete archive file after successful extraction. The archive is kept if any step after extraction fails")
+	rootCmd.Flags().BoolVar(&keepArchive, "keep-archive", false, "Keep the archive file after extraction (same as --remove-archive=false)")
+	rootCmd.Flags().BoolVar(&extractStream, "extract-stream", false, "Extract tar-based archives while downloading instead of storing the archive first. Zip and ISO 9660 archives are still stored and extracted afterwards. Extracted files are removed if the hash does not match")
+	rootCmd.Flags().StringVar(&extractIfMissing, "extract-if-missing", "", "Skip the download and extraction when this path exists. If extraction does not create it, it is written as a stamp holding the --hash value, and a stamp with a different hash triggers a new download")
+	rootCmd.Flags().BoolVar(&skipVerified, "skip-verified", false, "Skip a download whose output file already exists and matches --hash (or --hash-url), without requesting it")
 	rootCmd.Flags().StringVarP(&chdir, "chdir", "C", "", "Change working directory before any operation (fails if directory doesn't exist)")
 	rootCmd.Flags().BoolVar(&chdirCreate, "chdir-create", false, "Create directory if it doesn't exist (requires --chdir)")
+	rootCmd.Flags().StringVar(&extractDir, "extract-dir", "", "Extract into this directory instead of the working directory, creating it if needed")
+	rootCmd.Flags().BoolVar(&extractAtomic, "extract-atomic", false, "Extract into a hidden directory next to --extract-dir and rename it into place on success, so a failed or interrupted extraction leaves nothing behind. --extract-dir must be missing or empty")
 	rootCmd.Flags().IntVar(&stripComponents, "extract-strip-components", 0, "Strip N leading components from file names during extraction")
+	rootCmd.Flags().BoolVar(&stripTopLevel, "extract-strip-toplevel", false, "Strip the top-level directory when all entries share one (e.g. project-1.2.3/); extract as is otherwise")
+	rootCmd.Flags().StringSliceVar(&extractAllowPaths, "extract-allow-paths", nil, "Comma-separated glob patterns (e.g. 'bin/**,share/**') every extracted entry must match after stripping; others fail the extraction")
+	rootCmd.Flags().IntVar(&extractMaxDepth, "extract-max-depth", 0, "Fail on entries with more than N path components after stripping (0 = unlimited)")
+	rootCmd.Flags().BoolVar(&lenient, "lenient", false, "Skip entries rejected by --extract-allow-paths or --extract-max-depth instead of failing")
+	rootCmd.Flags().BoolVar(&preservePermissions, "preserve-permissions", false, "Apply the permission bits of archive entries, masked by the umask, instead of 0644 (0755 for executables). setuid and setgid are never applied")
+	rootCmd.Flags().BoolVar(&noUmask, "no-umask", false, "With --preserve-permissions, apply entry permissions exactly, without masking them with the umask")
+	rootCmd.Flags().BoolVar(&preserveMtime, "preserve-mtime", true, "Set the modification time of extracted files and directories to their archive timestamps")
+	rootCmd.Flags().BoolVar(&noMtime, "no-mtime", false, "Leave the extraction time as modification time (same as --preserve-mtime=false)")
+	rootCmd.Flags().BoolVar(&extractOverwrite, "extract-overwrite", false, "Replace files that already exist at an entry's destination (the default)")
+	rootCmd.Flags().BoolVar(&extractSkipExisting, "extract-skip-existing", false, "Keep files that already exist at an entry's destination and skip the entry")
+	rootCmd.Flags().BoolVar(&extractKeepNewer, "extract-keep-newer", false, "Keep existing files newer than the archive entry; replace older ones")
+	rootCmd.Flags().StringVar(&extractLinks, "extract-links", archive.LinksKeep, "What to do with symlinks and hard links in archives: keep (create them), error (fail the extraction), skip, or dereference (write copies of their targets)")
+	rootCmd.Flags().BoolVar(&extractStrictNames, "extract-strict-names", false, "Fail on entry names that are not valid on Windows (reserved names such as NUL, trailing dots or spaces, characters such as : or ?) instead of renaming them on Windows; applies on every platform")
+	rootCmd.Flags().StringVar(&zipCharset, "zip-charset", archive.ZipCharsetAuto, "Charset of zip entry names without the UTF-8 flag: auto (UTF-8 if valid, CP437 otherwise), cp437, utf-8 or shift-jis")
+	rootCmd.Flags().StringVar(&archiveTypeStr, "archive-type", "", "Archive type to extract as, skipping detection, for misnamed or undetectable archives: zip, tar, gzip, bzip2, xz, zstd, iso9660, lz4 or brotli")
+	rootCmd.Flags().StringVar(&verifyManifestPath, "verify-manifest", "", "Checksum manifest of the extracted files: a JSON object mapping paths to digests in --hash format, or a checksum file; extraction fails at the first file that does not match or is not listed")
+	rootCmd.Flags().StringVar(&zstdDictPath, "zstd-dict", "", "Dictionary for zstd archives compressed with one: a zstd --train dictionary, or any file used as raw content (e.g. the source of zstd --patch-from)")
+	rootCmd.Flags().BoolVar(&extractNested, "extract-nested", false, "Also extract archives found among the extracted files (e.g. a .tar.gz inside a .zip), next to themselves. --extract-max-bytes covers all levels")
+	rootCmd.Flags().IntVar(&extractNestedDepth, "extract-nested-depth", 1, "How many levels of archives inside archives --extract-nested extracts")
+	rootCmd.Flags().BoolVar(&extractSandbox, "extract-sandbox", false, "Decode archives in a child process that can only read the archive and write the extraction directory, and cannot use the network or run programs (Linux 5.19+ with Landlock, OpenBSD)")
 	rootCmd.Flags().StringVar(&connectTimeoutStr, "connect-timeout", "300s", "Maximum time for connection establishment (supports human-readable formats like \"5m\", \"1h30m\", \"2d\")")
 	rootCmd.Flags().StringVarP(&downloadMaxTimeStr, "download-max-time", "m", "1h", "Maximum time for the download operation. Supports human-readable formats like \"1h\", \"2d\", \"1w\")")
 	rootCmd.Flags().IntVar(&maxRedirects, "max-redirs", 30, "Maximum number of redirects to follow")
+	rootCmd.Flags().StringSliceVar(&allowHosts, "allow-host", nil, "Comma-separated host globs (e.g. 'mirror.example.com,*.example.org') the download and every redirect must go to; others fail. Can be specified multiple times")
+	rootCmd.Flags().StringSliceVar(&denyHosts, "deny-host", nil, "Comma-separated host globs the download and its redirects must not go to, even if --allow-host matches. Can be specified multiple times")
+	rootCmd.Flags().StringVar(&redirectPolicy, "redirect-policy", downloader.RedirectAny, "Which redirects to follow: any, same-host, same-origin, https-upgrade-only. Authorization is never forwarded across origins")
 	rootCmd.Flags().StringVar(&userAgent, "user-agent", version.UserAgent(), "User-Agent header to send with HTTP requests")
 	rootCmd.Flags().StringVarP(&maxBytesStr, "max-bytes", "M", "4GiB", "Maximum bytes to download (e.g., \"4GiB\", \"512MB\")")
 	rootCmd.Flags().StringVar(&extractMaxBytesStr, "extract-max-bytes", "8GiB", "Maximum total bytes to extract from archive (e.g., \"8GiB\")")
+	rootCmd.Flags().StringVar(&extractMaxFileBytesStr, "extract-max-file-bytes", "", "Maximum size of any single extracted file (e.g., \"100MiB\"); larger entries fail the extraction")
 	rootCmd.Flags().StringVar(&extractTimeoutStr, "extract-timeout", "30m", "Maximum time for archive extraction. Supports human-readable formats like \"30m\", \"1h\", \"2d\")")
 	rootCmd.Flags().StringVar(&progressIntervalStr, "progress-interval", "500ms", "Interval between progress updates (supports human-readable formats like \"500ms\", \"1s\", \"2s\")")
+	rootCmd.Flags().StringVar(&progressMode, "progress", "auto", "Progress output: auto (bar on a terminal, log otherwise), bar (interactive bar with speed and ETA, log if stderr is not a terminal), log (progress records in the regular log) or json (newline-delimited JSON events with percent, bytes, speed and ETA for the download and extract phases)")
+	rootCmd.Flags().IntVar(&progressFD, "progress-fd", 2, "File descriptor receiving --progress=json events (default stderr)")
 	rootCmd.Flags().StringVar(&logProgressStepUnknownStr, "log-progress-step-unknown", "25MB", "Byte interval for progress logs when size is unknown (supports human-readable formats like \"25MB\", \"50MiB\", \"100k\")")
 	rootCmd.Flags().StringVar(&logLevel, "log-level", "info", "Log level: debug, info, warn, error")
 	rootCmd.Flags().StringVar(&logFormat, "log-format", "text", "Log format: text or json")
+	rootCmd.Flags().StringVar(&logFilePath, "log-file", "", "Append log records and --verbose output to this file instead of stderr. The progress bar and errors stay on stderr")
 	rootCmd.Flags().IntVar(&logProgressStep, "log-progress-step", 5, "Percent interval for progress milestone logs (1-50)")
 	rootCmd.Flags().BoolVar(&allowInsecureTLS, "allow-insecure-tls", false, "Allow insecure TLS versions (1.0/1.1) with known vulnerabilities")
+	rootCmd.Flags().BoolVar(&fipsMode, "fips", fips.ModuleEnabled(), "Restrict hash algorithms and TLS settings to FIPS-approved ones (always on in FIPS builds)")
 	rootCmd.Flags().BoolVar(&allowUnsafeHTTP, "allow-unsafe-http", false, "Allow plain HTTP downloads without hash verification (unsafe)")
+	rootCmd.Flags().BoolVar(&requireHash, "require-hash", false, "Refuse any download that --hash, --hash-url, --minisign-key or --provenance does not verify. Set RIPVEX_REQUIRE_HASH=true to enforce it for every run")
 	rootCmd.Flags().StringArrayVar(&headers, "header", []string{}, "Custom header in \"Key: Value\" format. Can be specified multiple times.")
 	rootCmd.Flags().StringVarP(&auth, "auth", "A", "", "Set Authorization header to the provided value")
 	rootCmd.Flags().StringVarP(&authBearer, "auth-bearer", "B", "", "Set Authorization header to \"Bearer {value}\"")
-	rootCmd.Flags().StringVar(&authBasicUser, "auth-basic-user", "", "Username for HTTP Basic authentication (requires --auth-basic-pass)")
+	rootCmd.Flags().StringVar(&authBasicUser, "auth-basic-user", "", "Username for HTTP Basic authentication (requires --auth-basic-pass, --auth-basic-pass-file or --auth-prompt)")
 	rootCmd.Flags().StringVar(&authBasicPass, "auth-basic-pass", "", "Password for HTTP Basic authentication (requires --auth-basic-user)")
 	rootCmd.Flags().StringVar(&authBasic, "auth-basic", "", "Custom base64 value for Basic auth (cannot be used with --auth-basic-user/pass)")
-
-	rootCmd.MarkFlagRequired("url")
+	rootCmd.Flags().StringVar(&authBearerFile, "auth-bearer-file", "", "Read the --auth-bearer token from this file (\"-\" for stdin), keeping it out of process arguments")
+	rootCmd.Flags().StringVar(&authBasicPassFile, "auth-basic-pass-file", "", "Read the --auth-basic-pass password from this file (\"-\" for stdin)")
+	rootCmd.Flags().BoolVar(&authPrompt, "auth-prompt", false, "Prompt on the terminal, without echo, for the --auth-basic-user password, or for a bearer token without --auth-basic-user")
+	rootCmd.Flags().StringVar(&authHelper, "auth-helper", "", "Look up a bearer token for the host of each request in keychain (macOS), secretservice (Linux), wincred (Windows), or with command:<cmd>, which prints it")
+	rootCmd.Flags().StringVar(&oauth2ClientCredentials, "oauth2-client-credentials", "", "Get a bearer token from this OAuth2 token endpoint with the client credentials grant before downloading")
+	rootCmd.Flags().StringVar(&oauth2Device, "oauth2-device", "", "Get a bearer token from this OAuth2 token endpoint with the device authorization grant, asking you to approve it in a browser")
+	rootCmd.Flags().StringVar(&oauth2DeviceAuthURL, "oauth2-device-auth-url", "", "Device authorization endpoint for --oauth2-device")
+	rootCmd.Flags().StringVar(&oauth2ClientID, "oauth2-client-id", "", "OAuth2 client ID (or RIPVEX_OAUTH2_CLIENT_ID)")
+	rootCmd.Flags().StringVar(&oauth2ClientSecret, "oauth2-client-secret", "", "OAuth2 client secret; prefer RIPVEX_OAUTH2_CLIENT_SECRET to keep it out of process arguments")
+	rootCmd.Flags().StringVar(&oauth2Scope, "oauth2-scope", "", "Space-separated OAuth2 scopes to request")
+	rootCmd.Flags().BoolVar(&oauth2NoCache, "oauth2-no-cache", false, "Get a new OAuth2 token instead of reusing, and saving, one cached in the user cache directory")
+	rootCmd.Flags().StringVar(&netrcFile, "netrc-file", "", "Read host credentials from this .netrc file instead of $NETRC or ~/.netrc")
+	rootCmd.Flags().BoolVar(&noNetrc, "no-netrc", false, "Do not read host credentials from ~/.netrc")
+
+	rootCmd.ValidArgsFunction = cobra.NoFileCompletions
+	registerFlagCompletions()
+
+	rootCmd.AddCommand(newGetCmd(), newExtractCmd(), newVerifyCmd(), newHashCmd(), newQueueCmd(), newExtractSandboxedCmd())
 
 	// Silence usage output for runtime errors, but show it for flag errors
 	// SilenceErrors is true so we can control error output format in main()
@@ -115,7 +313,7 @@ func init() {
 	// Show usage only when there's a flag parsing error
 	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
 		_ = cmd.Usage()
-		return err
+		return withExitCode(ExitUsage, err)
 	})
 }
 
@@ -132,13 +330,22 @@ func ExecuteContext(c context.Context, t *cleanup.Tracker) error {
 		// Show usage for required flag errors (not caught by SetFlagErrorFunc)
 		if strings.Contains(err.Error(), "required flag") {
 			_ = rootCmd.Usage()
+			return withExitCode(ExitUsage, err)
 		}
 		return err
 	}
 	return nil
 }
 
-func run(cmd *cobra.Command, args []string) error {
+func run(cmd *cobra.Command, args []string) (err error) {
+	// Everything that fails before the first download is a usage/setup error
+	downloading := false
+	defer func() {
+		if err != nil && !downloading && cmd.Context().Err() == nil {
+			err = withExitCode(ExitUsage, err)
+		}
+	}()
+
 	ctx := cmd.Context()
 	tracker, ok := ctx.Value(trackerKey).(*cleanup.Tracker)
 	if !ok || tracker == nil {
@@ -150,51 +357,110 @@ func run(cmd *cobra.Command, args []string) error {
 		return ctx.Err()
 	}
 
-	// Change directory first if specified
-	if chdir != "" {
-		if chdirCreate {
-			if err := os.MkdirAll(chdir, 0755); err != nil {
-				return fmt.Errorf("failed to create directory %q: %w", chdir, err)
-			}
+	// The URL may be given positionally, curl/wget style
+	if len(args) > 0 {
+		if cmd.Flags().Changed("url") && urlStr != args[0] {
+			return fmt.Errorf("URL given both as an argument and with --url")
 		}
-		if err := os.Chdir(chdir); err != nil {
-			return fmt.Errorf("failed to change directory to %q: %w", chdir, err)
+		urlStr = args[0]
+	}
+	if urlStr == "" {
+		_ = cmd.Usage()
+		return fmt.Errorf("a URL is required: pass it as an argument or with --url")
+	}
+
+	// --keep-archive is the positive form of --remove-archive=false
+	if cmd.Flags().Changed("keep-archive") {
+		if cmd.Flags().Changed("remove-archive") && removeArchive == keepArchive {
+			return fmt.Errorf("--keep-archive conflicts with --remove-archive")
 		}
-	} else if chdirCreate {
-		return fmt.Errorf("--chdir-create requires --chdir to be specified")
+		removeArchive = !keepArchive
 	}
 
-	// Validate URL
-	parsedURL, err := url.Parse(urlStr)
+	// Change directory first if specified
+	if err := applyChdir(); err != nil {
+		return err
+	}
+
+	// Expand --matrix into one variable set per download
+	combos, err := parseMatrix(matrix)
 	if err != nil {
-		return fmt.Errorf("invalid URL: %w", err)
+		return fmt.Errorf("invalid --matrix value: %w", err)
 	}
-	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return fmt.Errorf("unsupported URL scheme %q: only http and https are supported", parsedURL.Scheme)
+	if len(combos) > 1 {
+		if output == "-" {
+			return fmt.Errorf("cannot use --matrix when output is stdout (-)")
+		}
+		if expectedHash != "" {
+			return fmt.Errorf("--hash cannot be used with --matrix expanding to multiple downloads")
+		}
+		if extractIfMissing != "" {
+			return fmt.Errorf("--extract-if-missing cannot be used with --matrix expanding to multiple downloads")
+		}
 	}
-	urlStr = parsedURL.String()
-
-	// Track whether --output was explicitly set
-	outputExplicit := output != ""
-
-	// Determine output filename (fallback if not explicitly set)
-	if output == "" {
-		parsedURL := urlStr
-		if idx := strings.LastIndex(parsedURL, "/"); idx != -1 {
-			output = parsedURL[idx+1:]
+	if hashURL != "" && (expectedHash != "" || hashFromHeaders) {
+		return fmt.Errorf("--hash-url cannot be combined with --hash or --hash-from-headers")
+	}
+	if signature != "" && minisignKeyStr == "" {
+		return fmt.Errorf("--signature requires --minisign-key")
+	}
+	signingKey = nil
+	if minisignKeyStr != "" {
+		if output == "-" {
+			return fmt.Errorf("--minisign-key requires a file output, not stdout (-)")
 		}
-		if output == "" || output == "/" {
-			output = "download"
+		signingKey, err = parseMinisignKey(minisignKeyStr)
+		if err != nil {
+			return fmt.Errorf("invalid --minisign-key value: %w", err)
 		}
-		// Strip query string if present
-		if idx := strings.Index(output, "?"); idx != -1 {
-			output = output[:idx]
+	}
+	if (provenanceSrc == "") != (provenanceBuilder == "") {
+		return fmt.Errorf("--provenance and --provenance-builder must be used together")
+	}
+	if xattr {
+		if output == "-" {
+			return fmt.Errorf("--xattr requires a file output, not stdout (-)")
+		}
+		if !util.XattrSupported {
+			return fmt.Errorf("--xattr is not supported on %s", runtime.GOOS)
 		}
 	}
-
-	// Cannot extract when outputting to stdout
-	if extractArchive && output == "-" {
-		return fmt.Errorf("cannot extract archive when output is stdout (-)")
+	if provenanceSrc != "" && output == "-" {
+		return fmt.Errorf("--provenance requires a file output, not stdout (-)")
+	}
+	if skipVerified {
+		if expectedHash == "" && hashURL == "" {
+			return fmt.Errorf("--skip-verified requires --hash or --hash-url")
+		}
+		if output == "-" {
+			return fmt.Errorf("--skip-verified requires a file output, not stdout (-)")
+		}
+		if extractArchive && removeArchive {
+			return fmt.Errorf("--skip-verified with --extract-archive requires --keep-archive")
+		}
+	}
+	if extractIfMissing != "" && !extractArchive {
+		return fmt.Errorf("--extract-if-missing requires --extract-archive")
+	}
+	if extractStream {
+		if !extractArchive {
+			return fmt.Errorf("--extract-stream requires --extract-archive")
+		}
+		if !removeArchive {
+			return fmt.Errorf("--extract-stream cannot be used with --keep-archive: a streamed archive is not stored")
+		}
+		if minisignKeyStr != "" {
+			return fmt.Errorf("--extract-stream cannot be used with --minisign-key, which verifies the stored archive")
+		}
+		if xattr {
+			return fmt.Errorf("--extract-stream cannot be used with --xattr, which labels the stored archive")
+		}
+		if stripTopLevel {
+			return fmt.Errorf("--extract-stream cannot be used with --extract-strip-toplevel, which reads the whole archive before extracting")
+		}
+		if extractSandbox {
+			return fmt.Errorf("--extract-stream cannot be used with --extract-sandbox, which extracts the stored archive")
+		}
 	}
 
 	// Parse size limits
@@ -203,9 +469,18 @@ func run(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid --max-bytes value: %w", err)
 	}
 
-	extractMaxBytes, err := util.ParseByteSize(extractMaxBytesStr)
+	extractOpts, err := parseExtractFlags(cmd)
 	if err != nil {
-		return fmt.Errorf("invalid --extract-max-bytes value: %w", err)
+		return err
+	}
+
+	var preflightMaxBytes int64
+	if preflightMaxBytesStr != "" {
+		preflightMaxBytes, err = util.ParseByteSize(preflightMaxBytesStr)
+		if err != nil {
+			return fmt.Errorf("invalid --preflight-max-bytes value: %w", err)
+		}
+		preflight = true
 	}
 
 	// Parse duration limits
@@ -221,62 +496,191 @@ func run(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid --download-max-time value: %w", err)
 	}
 
-	var extractTimeout time.Duration
-	extractTimeout, err = util.ParseDuration(extractTimeoutStr)
+	progressInterval, err := parseProgressFlags()
 	if err != nil {
-		return fmt.Errorf("invalid --extract-timeout value: %w", err)
+		return err
 	}
 
-	progressInterval, err := util.ParseDuration(progressIntervalStr)
-	if err != nil {
-		return fmt.Errorf("invalid --progress-interval value: %w", err)
+	// The FIPS module cannot be switched off at runtime, so neither can the policy
+	if fips.ModuleEnabled() {
+		fipsMode = true
 	}
-	if progressInterval <= 0 {
-		return fmt.Errorf("--progress-interval must be greater than 0, got %s", progressIntervalStr)
+	if fipsMode && allowInsecureTLS {
+		return fmt.Errorf("--allow-insecure-tls cannot be used in FIPS mode")
 	}
 
-	logProgressStepUnknown, err = util.ParseByteSize(logProgressStepUnknownStr)
+	hashAlgo, hashDigest, err := parseExpectedHash(expectedHash)
 	if err != nil {
-		return fmt.Errorf("invalid --log-progress-step-unknown value: %w", err)
+		return err
 	}
-	if logProgressStepUnknown <= 0 {
-		return fmt.Errorf("--log-progress-step-unknown must be greater than 0, got %s", logProgressStepUnknownStr)
+	if hashAlgo != "" {
+		if err := checkHashPolicy(hashAlgo); err != nil {
+			return err
+		}
 	}
-
-	hashAlgo, hashDigest, err := parseExpectedHash(expectedHash)
+	if streamUnverified && (output != "-" || (hashDigest == "" && hashURL == "")) {
+		return fmt.Errorf("--stream-unverified requires --output - and --hash or --hash-url")
+	}
+	if outputDir != "" {
+		if output == "-" {
+			return fmt.Errorf("--output-dir cannot be used when output is stdout (-)")
+		}
+		if filepath.IsAbs(output) {
+			return fmt.Errorf("--output-dir cannot be used with an absolute --output path")
+		}
+	}
+	if partial {
+		if output == "-" {
+			return fmt.Errorf("--partial requires a file output, not stdout (-)")
+		}
+		if extractStream {
+			return fmt.Errorf("--partial cannot be used with --extract-stream: a streamed archive is not stored")
+		}
+	}
+	var fsyncInterval int64
+	if fsyncIntervalStr != "" {
+		if fsyncInterval, err = util.ParseByteSize(fsyncIntervalStr); err != nil {
+			return fmt.Errorf("invalid --fsync-interval-bytes value: %w", err)
+		}
+	}
+	lockTimeout := time.Duration(-1)
+	if lockTimeoutStr != "" {
+		if lockTimeout, err = util.ParseDuration(lockTimeoutStr); err != nil {
+			return fmt.Errorf("invalid --lock-timeout value: %w", err)
+		}
+		if lockTimeout < 0 {
+			return fmt.Errorf("invalid --lock-timeout value: must not be negative")
+		}
+	}
+	syncFiles := syncOutput || fsyncInterval > 0
+	if syncFiles {
+		if output == "-" {
+			return fmt.Errorf("--sync requires a file output, not stdout (-)")
+		}
+		if extractStream {
+			return fmt.Errorf("--sync cannot be used with --extract-stream: a streamed archive is not stored")
+		}
+	}
+	if execPostDownload != "" {
+		if output == "-" {
+			return fmt.Errorf("--exec-post-download requires a file output, not stdout (-)")
+		}
+		if extractStream {
+			return fmt.Errorf("--exec-post-download cannot be used with --extract-stream: a streamed archive is not stored")
+		}
+	}
+	if execPostExtract != "" && !extractArchive {
+		return fmt.Errorf("--exec-post-extract requires --extract-archive")
+	}
+	printHashAlgos, err = parsePrintHash(printHash)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid --print-hash value: %w", err)
+	}
+	fileMode = nil
+	if chmodStr != "" {
+		if output == "-" {
+			return fmt.Errorf("--chmod requires a file output, not stdout (-)")
+		}
+		if extractStream {
+			return fmt.Errorf("--chmod cannot be used with --extract-stream: a streamed archive is not stored")
+		}
+		mode, err := parseFileMode(chmodStr)
+		if err != nil {
+			return fmt.Errorf("invalid --chmod value: %w", err)
+		}
+		fileMode = &mode
+	}
+	digestAlgos := printHashAlgos
+	if paranoid && !slices.Contains(digestAlgos, "crc32c") {
+		// A record of the body, not a verification, so the hash policy does not apply
+		digestAlgos = append(slices.Clone(digestAlgos), "crc32c")
+	}
+	if writeChecksum {
+		if extractArchive && removeArchive {
+			return fmt.Errorf("--write-checksum with --extract-archive requires --keep-archive")
+		}
+		if !slices.Contains(digestAlgos, "sha256") {
+			digestAlgos = append(slices.Clone(digestAlgos), "sha256")
+		}
+	}
+	if writeMetadata {
+		if extractArchive && removeArchive {
+			return fmt.Errorf("--write-metadata with --extract-archive requires --keep-archive")
+		}
+		if !slices.Contains(digestAlgos, "sha256") {
+			digestAlgos = append(slices.Clone(digestAlgos), "sha256")
+		}
+	}
+	if (execPostDownload != "" || execPostExtract != "") && !slices.Contains(digestAlgos, "sha256") {
+		// RIPVEX_HOOK_HASH falls back to it when nothing was verified
+		digestAlgos = append(slices.Clone(digestAlgos), "sha256")
+	}
+	if provenanceSrc != "" && !slices.Contains(digestAlgos, provenanceSubjectAlgo) {
+		digestAlgos = append(slices.Clone(digestAlgos), provenanceSubjectAlgo)
 	}
 
-	if parsedURL.Scheme == "http" && hashDigest == "" && !allowUnsafeHTTP {
-		return fmt.Errorf("plain http downloads require --hash or --allow-unsafe-http")
+	// Resolve the URL and output name of every download before starting any of them
+	jobs := make([]job, 0, len(combos))
+	for _, vars := range combos {
+		j, err := newJob(vars)
+		if err != nil {
+			return err
+		}
+		if j.parsedURL.Scheme == "http" && hashDigest == "" && hashURL == "" && signingKey == nil && provenanceSrc == "" && !allowUnsafeHTTP {
+			return fmt.Errorf("plain http downloads require --hash, --hash-url, --minisign-key, --provenance or --allow-unsafe-http")
+		}
+		if j.hashURL != nil && j.hashURL.Scheme == "http" && !allowUnsafeHTTP {
+			return fmt.Errorf("a plain http --hash-url requires --allow-unsafe-http")
+		}
+		j.hashAlgo, j.hashDigest = hashAlgo, hashDigest
+		if requireHash && j.hashDigest == "" && j.hashURL == nil && signingKey == nil && j.provenanceSrc == "" {
+			return fmt.Errorf("--require-hash: %s has no --hash, --hash-url, --minisign-key or --provenance to verify it", j.url)
+		}
+		jobs = append(jobs, j)
+	}
+	seenOutputs := make(map[string]bool, len(jobs))
+	for _, j := range jobs {
+		if len(printHashAlgos) > 0 && j.output == "-" {
+			return fmt.Errorf("--print-hash cannot print to stdout when output is stdout (-)")
+		}
+		if writeChecksum && j.output == "-" {
+			return fmt.Errorf("--write-checksum requires a file output, not stdout (-)")
+		}
+		if writeMetadata && j.output == "-" {
+			return fmt.Errorf("--write-metadata requires a file output, not stdout (-)")
+		}
+		if seenOutputs[j.output] {
+			return fmt.Errorf("--matrix produces duplicate output %q: reference matrix variables in --output (e.g. {os})", j.output)
+		}
+		seenOutputs[j.output] = true
 	}
 
 	// Validate max-redirs
 	if maxRedirects < 0 {
 		return fmt.Errorf("--max-redirs must be non-negative, got %d", maxRedirects)
 	}
-
-	// Validate strip-components
-	if stripComponents < 0 {
-		return fmt.Errorf("--extract-strip-components must be non-negative, got %d", stripComponents)
+	if err := downloader.ValidateRedirectPolicy(redirectPolicy); err != nil {
+		return fmt.Errorf("invalid --redirect-policy value: %w", err)
 	}
-
-	if logProgressStep <= 0 || logProgressStep > 50 {
-		return fmt.Errorf("--log-progress-step must be between 1 and 50, got %d", logProgressStep)
+	hostPolicy, err := newHostPolicy()
+	if err != nil {
+		return err
 	}
 
-	// Quiet overrides logging verbosity and progress output
+	// Quiet overrides logging verbosity, tracing and progress output
 	if quiet {
-		logLevel = "error"
+		verbose = 0
 	}
 
-	logger, err := logging.New(logLevel, logFormat)
+	ctx, logger, err := setupLogger(ctx)
 	if err != nil {
-		return fmt.Errorf("invalid logging configuration: %w", err)
+		return err
+	}
+
+	warnWeakHash(logger, hashAlgo)
+	if fipsMode && !fips.ModuleEnabled() {
+		logger.Warn("fips_module_inactive", "hint", "FIPS policy restricts algorithms, but crypto is not running in the validated Go FIPS 140-3 module; use a FIPS build or GODEBUG=fips140=on")
 	}
-	cleanup.SetLogger(logger)
-	ctx = logging.WithContext(ctx, logger)
 
 	// Parse and validate authorization flags
 	headersMap := make(map[string]string)
@@ -295,6 +699,39 @@ func run(cmd *cobra.Command, args []string) error {
 		headersMap[key] = value
 	}
 
+	if writeOut != "" {
+		writeOutTemplate, err = parseWriteOut(writeOut)
+		if err != nil {
+			return fmt.Errorf("invalid --write-out value: %w", err)
+		}
+	}
+
+	var headerAssertions []downloader.HeaderAssertion
+	for _, a := range assertHeaders {
+		assertion, err := downloader.ParseHeaderAssertion(a)
+		if err != nil {
+			return fmt.Errorf("invalid --assert-header value: %w", err)
+		}
+		headerAssertions = append(headerAssertions, assertion)
+	}
+
+	var maxAge time.Duration
+	if maxAgeStr != "" {
+		maxAge, err = util.ParseDuration(maxAgeStr)
+		if err != nil {
+			return fmt.Errorf("invalid --max-age value: %w", err)
+		}
+		if maxAge <= 0 {
+			return fmt.Errorf("invalid --max-age value: must be positive")
+		}
+	} else if maxAgeWarn {
+		return fmt.Errorf("--max-age-warn requires --max-age")
+	}
+
+	if err := readAuthSecrets(ctx); err != nil {
+		return err
+	}
+
 	// Count auth methods to enforce mutual exclusion
 	authMethods := 0
 	if auth != "" {
@@ -309,6 +746,16 @@ func run(cmd *cobra.Command, args []string) error {
 	if authBasic != "" {
 		authMethods++
 	}
+	if authHelper != "" {
+		authMethods++
+	}
+	grant, err := oauth2Grant()
+	if err != nil {
+		return err
+	}
+	if grant != "" {
+		authMethods++
+	}
 
 	if authMethods > 1 {
 		return fmt.Errorf("only one authentication method can be specified at a time")
@@ -331,30 +778,687 @@ func run(cmd *cobra.Command, args []string) error {
 	} else if authBasic != "" {
 		headersMap["Authorization"] = "Basic " + authBasic
 	}
+	// Credentials never reach logs, traces or errors, whatever quotes them
+	redact.Register(auth, authBearer, authBasicPass, authBasic)
+	for key, value := range headersMap {
+		if redact.SensitiveHeader(key) {
+			redact.Register(value)
+		}
+	}
+
+	tokens, err := newAuthHelper()
+	if err != nil {
+		return err
+	}
+	netrcCreds, err := loadNetrc(logger)
+	if err != nil {
+		return err
+	}
+
+	var traceFile *os.File
+	if tracePath != "" {
+		traceFile, err = os.Create(tracePath)
+		if err != nil {
+			return fmt.Errorf("failed to create trace file: %w", err)
+		}
+		defer traceFile.Close()
+	}
+
+	progressLogger, progressTerminal, err := newProgressOutput(jobs)
+	if err != nil {
+		return err
+	}
+
+	var dumpHeaderWriter io.Writer
+	switch dumpHeaderPath {
+	case "":
+		if dumpHeaderRedirects {
+			return fmt.Errorf("--dump-header-redirects requires --dump-header")
+		}
+	case "-":
+		for _, j := range jobs {
+			if j.output == "-" {
+				return fmt.Errorf("--dump-header cannot write to stdout when output is stdout (-)")
+			}
+		}
+		dumpHeaderWriter = os.Stdout
+	default:
+		f, err := os.Create(dumpHeaderPath)
+		if err != nil {
+			return fmt.Errorf("failed to create header dump file: %w", err)
+		}
+		defer f.Close()
+		dumpHeaderWriter = f
+	}
 
-	// Perform download
-	opts := downloader.Options{
-		URL:                    urlStr,
-		Output:                 output,
-		OutputExplicit:         outputExplicit,
+	baseOpts := downloader.Options{
 		Quiet:                  quiet,
-		HashAlgorithm:          hashAlgo,
-		ExpectedHash:           hashDigest,
+		InferExtension:         inferExtension,
+		DigestAlgorithms:       digestAlgos,
+		StreamUnverified:       streamUnverified,
+		HashFromHeaders:        hashFromHeaders,
 		ConnectTimeout:         connectTimeout,
 		MaxTime:                maxTime,
 		MaxRedirects:           maxRedirects,
+		RedirectPolicy:         redirectPolicy,
+		HostPolicy:             hostPolicy,
 		UserAgent:              userAgent,
 		MaxBytes:               maxBytes,
+		Preallocate:            !noPreallocate,
 		AllowInsecureTLS:       allowInsecureTLS,
+		FIPS:                   fipsMode,
 		Headers:                headersMap,
+		AuthHelper:             tokens,
+		Netrc:                  netrcCreds,
+		Verbose:                verbose,
+		VerboseWriter:          logOutput,
+		DumpHeaderWriter:       dumpHeaderWriter,
+		DumpRedirectHeaders:    dumpHeaderRedirects,
+		HeaderAssertions:       headerAssertions,
+		MaxAge:                 maxAge,
+		MaxAgeWarnOnly:         maxAgeWarn,
 		ProgressInterval:       progressInterval,
 		LogFormat:              logFormat,
 		LogProgressStep:        logProgressStep,
 		LogProgressStepUnknown: logProgressStepUnknown,
+		ProgressLogger:         progressLogger,
+		ProgressTerminal:       progressTerminal,
+	}
+	if traceFile != nil {
+		baseOpts.TraceWriter = traceFile
+	}
+	if grant != "" {
+		downloading = true
+		token, err := oauth2Token(ctx, logger, baseOpts, grant)
+		if err != nil {
+			return err
+		}
+		// baseOpts shares the map
+		headersMap["Authorization"] = "Bearer " + token
+	}
+	if hashURL != "" {
+		// Fetching the checksum file is the first download
+		downloading = true
+		if err := resolveHashURLs(ctx, logger, baseOpts, jobs); err != nil {
+			return err
+		}
+	}
+	if signingKey != nil {
+		downloading = true
+		if err := resolveSignatures(ctx, logger, baseOpts, jobs); err != nil {
+			return err
+		}
+	}
+	if provenanceSrc != "" {
+		downloading = true
+		if err := resolveProvenance(ctx, logger, baseOpts, jobs); err != nil {
+			return err
+		}
+	}
+	if extractIfMissing != "" {
+		// Failing to read the marker is an I/O error, not a usage error
+		downloading = true
+		state, err := checkMarker(extractIfMissing, hashStamp(jobs[0].hashAlgo, jobs[0].hashDigest))
+		if err != nil {
+			return err
+		}
+		switch state {
+		case markerPresent:
+			logger.Info("extraction_skipped", "marker", extractIfMissing, "reason", "marker exists")
+			if writeOutTemplate != nil {
+				skipped := &downloader.Result{URL: jobs[0].url}
+				return renderWriteOut(os.Stdout, writeOutTemplate, writeOutData{Result: skipped, Filename: jobs[0].output, Skipped: true})
+			}
+			return nil
+		case markerStale:
+			logger.Info("marker_outdated", "marker", extractIfMissing, "hint", "the stamp records a different --hash; downloading again")
+		}
+	}
+	if skipVerified {
+		// Hashing existing files may fail with I/O errors, which are not usage errors
+		downloading = true
+		if jobs, err = skipVerifiedJobs(logger, jobs); err != nil || len(jobs) == 0 {
+			return err
+		}
+	}
+
+	downloading = true
+	if preflight {
+		if err := runPreflight(ctx, logger, baseOpts, jobs, preflightMaxBytes); err != nil {
+			return err
+		}
+	}
+
+	for _, j := range jobs {
+		if extractArchive {
+			// Each job extracts at most once, so it needs its own bar
+			extractOpts.Progress = newPhaseBar("extract", logger, progressLogger, progressTerminal, progressInterval)
+		}
+		if len(jobs) > 1 {
+			logger.Info("matrix_item_start", "url", j.url, "output", j.output)
+		}
+		if j.createDir != "" {
+			if err := os.MkdirAll(j.createDir, 0755); err != nil {
+				return fmt.Errorf("failed to create --output-dir: %w", err)
+			}
+		}
+		// Held until the job's files are kept or removed, so a waiting
+		// process never sees them half-written
+		var lock *outputLock
+		if j.output != "-" {
+			if lock, err = lockOutput(ctx, logger, j.output, lockTimeout); err != nil {
+				return err
+			}
+			if lock.waited && skipVerified {
+				// The process we waited for may have downloaded it
+				remaining, err := skipVerifiedJobs(logger, []job{j})
+				if err != nil || len(remaining) == 0 {
+					lock.release()
+					if err != nil {
+						return err
+					}
+					continue
+				}
+			}
+		}
+		opts := baseOpts
+		opts.URL = j.url
+		opts.Output = j.output
+		opts.OutputExplicit = j.outputExplicit
+		opts.OutputDir = j.outputDir
+		opts.HashAlgorithm = j.hashAlgo
+		opts.ExpectedHash = j.hashDigest
+		opts.Partial = partial
+		opts.Sync = syncFiles
+		opts.FsyncIntervalBytes = fsyncInterval
+		// A failed job removes its own files at once; the ones of earlier jobs are kept
+		scope := tracker.Scope()
+		if err := runJob(ctx, scope, logger, opts, extractOpts, j); err != nil {
+			scope.Cleanup()
+			if lock != nil {
+				lock.release()
+			}
+			if len(jobs) > 1 {
+				return fmt.Errorf("%s: %w", j.url, err)
+			}
+			return err
+		}
+		scope.Release()
+		if lock != nil {
+			lock.release()
+		}
+	}
+
+	return nil
+}
+
+// usageArgs makes a positional argument validator fail with ExitUsage
+func usageArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		return withExitCode(ExitUsage, validate(cmd, args))
+	}
+}
+
+// shareFlags adds root flags to a subcommand. They are the same flag objects,
+// so they bind the same variables and RIPVEX_* environment variables.
+func shareFlags(cmd *cobra.Command, names ...string) {
+	for _, name := range names {
+		cmd.Flags().AddFlag(rootCmd.Flags().Lookup(name))
+	}
+}
+
+// applyChdir changes into --chdir, creating it first with --chdir-create
+func applyChdir() error {
+	if chdir == "" {
+		if chdirCreate {
+			return fmt.Errorf("--chdir-create requires --chdir to be specified")
+		}
+		return nil
+	}
+	if chdirCreate {
+		if err := os.MkdirAll(chdir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %q: %w", chdir, err)
+		}
+	}
+	if err := os.Chdir(chdir); err != nil {
+		return fmt.Errorf("failed to change directory to %q: %w", chdir, err)
+	}
+	return nil
+}
+
+// parseExtractFlags validates the extraction flags shared by the root and
+// extract commands. It also sets extractTimeout.
+func parseExtractFlags(cmd *cobra.Command) (archive.ExtractOptions, error) {
+	if stripComponents < 0 {
+		return archive.ExtractOptions{}, fmt.Errorf("--extract-strip-components must be non-negative, got %d", stripComponents)
+	}
+
+	if extractAtomic && extractDir == "" {
+		return archive.ExtractOptions{}, fmt.Errorf("--extract-atomic requires --extract-dir")
+	}
+	if stripTopLevel && stripComponents != 0 {
+		return archive.ExtractOptions{}, fmt.Errorf("--extract-strip-toplevel and --extract-strip-components are mutually exclusive")
+	}
+
+	extractMaxBytes, err := util.ParseByteSize(extractMaxBytesStr)
+	if err != nil {
+		return archive.ExtractOptions{}, fmt.Errorf("invalid --extract-max-bytes value: %w", err)
+	}
+
+	var extractMaxFileBytes int64
+	if extractMaxFileBytesStr != "" {
+		extractMaxFileBytes, err = util.ParseByteSize(extractMaxFileBytesStr)
+		if err != nil {
+			return archive.ExtractOptions{}, fmt.Errorf("invalid --extract-max-file-bytes value: %w", err)
+		}
+	}
+
+	if extractMaxDepth < 0 {
+		return archive.ExtractOptions{}, fmt.Errorf("--extract-max-depth must be non-negative, got %d", extractMaxDepth)
+	}
+	if err := archive.ValidateAllowPaths(extractAllowPaths); err != nil {
+		return archive.ExtractOptions{}, fmt.Errorf("invalid --extract-allow-paths value: %w", err)
+	}
+	if lenient && len(extractAllowPaths) == 0 && extractMaxDepth == 0 {
+		return archive.ExtractOptions{}, fmt.Errorf("--lenient requires --extract-allow-paths or --extract-max-depth")
+	}
+
+	// --no-mtime is the negative form of --preserve-mtime
+	if cmd.Flags().Changed("no-mtime") {
+		if cmd.Flags().Changed("preserve-mtime") && preserveMtime == noMtime {
+			return archive.ExtractOptions{}, fmt.Errorf("--no-mtime conflicts with --preserve-mtime")
+		}
+		preserveMtime = !noMtime
+	}
+	overwrite := archive.OverwriteAlways
+	policies := 0
+	if extractOverwrite {
+		policies++
+	}
+	if extractSkipExisting {
+		overwrite = archive.OverwriteSkipExisting
+		policies++
+	}
+	if extractKeepNewer {
+		overwrite = archive.OverwriteKeepNewer
+		policies++
+	}
+	if policies > 1 {
+		return archive.ExtractOptions{}, fmt.Errorf("--extract-overwrite, --extract-skip-existing and --extract-keep-newer are mutually exclusive")
+	}
+	if err := archive.ValidateLinkPolicy(extractLinks); err != nil {
+		return archive.ExtractOptions{}, fmt.Errorf("invalid --extract-links value: %w", err)
+	}
+	if err := archive.ValidateZipCharset(zipCharset); err != nil {
+		return archive.ExtractOptions{}, fmt.Errorf("invalid --zip-charset value: %w", err)
+	}
+	forcedArchiveType = archive.Unknown
+	if archiveTypeStr != "" {
+		if forcedArchiveType, err = archive.ParseType(archiveTypeStr); err != nil {
+			return archive.ExtractOptions{}, fmt.Errorf("invalid --archive-type value: %w", err)
+		}
+	}
+	var zstdDict []byte
+	if zstdDictPath != "" {
+		zstdDict, err = os.ReadFile(zstdDictPath)
+		if err != nil {
+			return archive.ExtractOptions{}, fmt.Errorf("failed to read --zstd-dict: %w", err)
+		}
+		if err := archive.ValidateZstdDict(zstdDict); err != nil {
+			return archive.ExtractOptions{}, fmt.Errorf("invalid --zstd-dict: %w", err)
+		}
+	}
+	var manifest archive.Manifest
+	if verifyManifestPath != "" {
+		if manifest, err = readManifest(verifyManifestPath); err != nil {
+			return archive.ExtractOptions{}, fmt.Errorf("invalid --verify-manifest: %w", err)
+		}
+	}
+	if noUmask && !preservePermissions {
+		return archive.ExtractOptions{}, fmt.Errorf("--no-umask requires --preserve-permissions")
+	}
+	if extractNestedDepth < 1 {
+		return archive.ExtractOptions{}, fmt.Errorf("--extract-nested-depth must be at least 1, got %d", extractNestedDepth)
+	}
+	if extractNestedDepth != 1 && !extractNested {
+		return archive.ExtractOptions{}, fmt.Errorf("--extract-nested-depth requires --extract-nested")
+	}
+	if extractSandbox {
+		if err := sandbox.Available(); err != nil {
+			return archive.ExtractOptions{}, fmt.Errorf("--extract-sandbox is unavailable: %w", err)
+		}
 	}
 
+	extractTimeout, err = util.ParseDuration(extractTimeoutStr)
+	if err != nil {
+		return archive.ExtractOptions{}, fmt.Errorf("invalid --extract-timeout value: %w", err)
+	}
+
+	return archive.ExtractOptions{
+		DestDir:         extractDir,
+		StripComponents: stripComponents,
+		StripTopLevel:   stripTopLevel,
+		MaxBytes:        extractMaxBytes,
+		MaxFileBytes:    extractMaxFileBytes,
+		AllowPaths:      extractAllowPaths,
+		MaxDepth:        extractMaxDepth,
+		Lenient:         lenient,
+
+		PreservePermissions: preservePermissions,
+		NoUmask:             noUmask,
+		NoMtime:             !preserveMtime,
+		Overwrite:           overwrite,
+		Links:               extractLinks,
+		StrictNames:         extractStrictNames,
+		ZipCharset:          zipCharset,
+		ZstdDict:            zstdDict,
+		Manifest:            manifest,
+	}, nil
+}
+
+// logOutput is where setupLogger sends log records: stderr, or --log-file
+var logOutput io.Writer = logging.Stderr
+
+// setupLogger builds the logger from --log-level, --log-format, --log-file
+// and --quiet and attaches it to ctx and the cleanup tracker
+func setupLogger(ctx context.Context) (context.Context, *slog.Logger, error) {
+	level := logLevel
+	if quiet {
+		level = "error"
+	}
+	if logFilePath != "" {
+		f, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return ctx, nil, fmt.Errorf("failed to open --log-file: %w", err)
+		}
+		// Like stderr, a log file that fills up must not abort the download
+		logOutput = logging.NewFailsafeWriter(f)
+	}
+	logger, err := logging.NewWriter(level, logFormat, logOutput)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("invalid logging configuration: %w", err)
+	}
+	cleanup.SetLogger(logger)
+	return logging.WithContext(ctx, logger), logger, nil
+}
+
+// parseProgressFlags validates the progress flags and returns the update interval
+func parseProgressFlags() (time.Duration, error) {
+	progressInterval, err := util.ParseDuration(progressIntervalStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --progress-interval value: %w", err)
+	}
+	if progressInterval <= 0 {
+		return 0, fmt.Errorf("--progress-interval must be greater than 0, got %s", progressIntervalStr)
+	}
+
+	logProgressStepUnknown, err = util.ParseByteSize(logProgressStepUnknownStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --log-progress-step-unknown value: %w", err)
+	}
+	if logProgressStepUnknown <= 0 {
+		return 0, fmt.Errorf("--log-progress-step-unknown must be greater than 0, got %s", logProgressStepUnknownStr)
+	}
+
+	if logProgressStep <= 0 || logProgressStep > 50 {
+		return 0, fmt.Errorf("--log-progress-step must be between 1 and 50, got %d", logProgressStep)
+	}
+	return progressInterval, nil
+}
+
+// newPhaseBar creates the progress bar for one extraction or verification
+func newPhaseBar(phase string, logger, progressLogger *slog.Logger, terminal *os.File, interval time.Duration) *progress.Bar {
+	barLogger := logger
+	if progressLogger != nil {
+		barLogger = progressLogger
+	}
+	bar := progress.New(0, logProgressStep, logProgressStepUnknown, interval, barLogger, quiet && progressLogger == nil)
+	bar.Phase = phase
+	bar.Terminal = terminal
+	return bar
+}
+
+// newProgressOutput resolves --progress into either the event logger for
+// json mode or the terminal to draw a progress bar on. Both are nil when
+// progress goes to the regular log.
+func newProgressOutput(jobs []job) (*slog.Logger, *os.File, error) {
+	switch progressMode {
+	case "auto", "bar", "log":
+		if progressFD != 2 {
+			return nil, nil, fmt.Errorf("--progress-fd requires --progress=json")
+		}
+		if progressMode == "log" || !progress.IsTerminal(os.Stderr) {
+			return nil, nil, nil
+		}
+		// auto keeps structured logs intact when they are requested as JSON
+		if progressMode == "auto" && logFormat != "text" {
+			return nil, nil, nil
+		}
+		return nil, os.Stderr, nil
+	case "json":
+	default:
+		return nil, nil, fmt.Errorf("unsupported --progress value %q: must be auto, bar, log or json", progressMode)
+	}
+
+	if progressFD < 1 {
+		return nil, nil, fmt.Errorf("--progress-fd must be a positive file descriptor, got %d", progressFD)
+	}
+	if progressFD == 1 {
+		for _, j := range jobs {
+			if j.output == "-" {
+				return nil, nil, fmt.Errorf("--progress-fd cannot be stdout (1) when output is stdout (-)")
+			}
+		}
+	}
+	// A reader that goes away must not abort the download
+	var w io.Writer
+	switch progressFD {
+	case 1:
+		w = logging.NewFailsafeWriter(os.Stdout)
+	case 2:
+		w = logging.Stderr
+	default:
+		f := os.NewFile(uintptr(progressFD), "progress")
+		if _, err := f.Stat(); err != nil {
+			return nil, nil, fmt.Errorf("--progress-fd %d is not an open file descriptor: %w", progressFD, err)
+		}
+		w = logging.NewFailsafeWriter(f)
+	}
+	return logging.NewEventLogger(w), nil, nil
+}
+
+// job is a single download resolved from the CLI flags (one per matrix combination)
+type job struct {
+	url            string
+	parsedURL      *url.URL
+	output         string
+	outputExplicit bool
+	outputDir      string   // Existing directory --output named, or --output-dir without --output; output is a file inside it
+	createDir      string   // --output-dir, created before the download
+	hashURL        *url.URL // Checksum file to look up the hash in, if --hash-url is set
+	hashAlgo       string
+	hashDigest     string
+	signatureSrc   string             // Path or URL of the --minisign-key signature
+	signature      *minisignSignature // Set by resolveSignatures
+	provenanceSrc  string             // Path or URL of the --provenance attestation
+	provenance     *provenance        // Set by resolveProvenance
+}
+
+// newJob expands matrix variables into the URL and output and resolves the output filename
+func newJob(vars map[string]string) (job, error) {
+	rawURL, err := expandMatrix(urlStr, vars)
+	if err != nil {
+		return job{}, fmt.Errorf("invalid --url value: %w", err)
+	}
+	out, err := expandMatrix(output, vars)
+	if err != nil {
+		return job{}, fmt.Errorf("invalid --output value: %w", err)
+	}
+
+	// Response headers are only known after the request, so they can only name the output
+	if strings.Contains(rawURL, "{header:") {
+		return job{}, fmt.Errorf("invalid --url value: {header:...} placeholders are only supported in --output")
+	}
+
+	// Validate URL
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return job{}, fmt.Errorf("invalid URL: %w", err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return job{}, fmt.Errorf("unsupported URL scheme %q: only http and https are supported", parsedURL.Scheme)
+	}
+	j := job{
+		url:       parsedURL.String(),
+		parsedURL: parsedURL,
+		// Track whether --output was explicitly set
+		outputExplicit: out != "",
+	}
+
+	// --output-dir holds the output, whether named by --output or by the server
+	if outputDir != "" {
+		dir, err := expandMatrix(outputDir, vars)
+		if err != nil {
+			return job{}, fmt.Errorf("invalid --output-dir value: %w", err)
+		}
+		j.createDir = dir
+		if out == "" {
+			j.outputDir = dir
+		} else {
+			out = filepath.Join(dir, out)
+		}
+	}
+
+	// An existing directory receives the server-derived name, like curl -o dir/ and wget -P
+	if out != "" && out != "-" {
+		if info, err := os.Stat(out); err == nil && info.IsDir() {
+			j.outputDir = out
+			j.outputExplicit = false
+			out = ""
+		}
+	}
+
+	// Determine output filename (fallback if not explicitly set)
+	if out == "" {
+		if idx := strings.LastIndex(j.url, "/"); idx != -1 {
+			out = j.url[idx+1:]
+		}
+		if out == "" || out == "/" {
+			out = "download"
+		}
+		// Strip query string if present
+		if idx := strings.Index(out, "?"); idx != -1 {
+			out = out[:idx]
+		}
+		out = filepath.Join(j.outputDir, out)
+	}
+	j.output = out
+
+	if hashURL != "" {
+		rawHashURL, err := expandMatrix(hashURL, vars)
+		if err != nil {
+			return job{}, fmt.Errorf("invalid --hash-url value: %w", err)
+		}
+		j.hashURL, err = url.Parse(rawHashURL)
+		if err != nil {
+			return job{}, fmt.Errorf("invalid --hash-url value: %w", err)
+		}
+		if j.hashURL.Scheme != "http" && j.hashURL.Scheme != "https" {
+			return job{}, fmt.Errorf("unsupported --hash-url scheme %q: only http and https are supported", j.hashURL.Scheme)
+		}
+	}
+
+	if minisignKeyStr != "" {
+		j.signatureSrc, err = expandMatrix(signature, vars)
+		if err != nil {
+			return job{}, fmt.Errorf("invalid --signature value: %w", err)
+		}
+		if j.signatureSrc == "" {
+			sigURL := *parsedURL
+			sigURL.Path += ".minisig"
+			sigURL.RawPath = ""
+			j.signatureSrc = sigURL.String()
+		}
+	}
+
+	if provenanceSrc != "" {
+		j.provenanceSrc, err = expandMatrix(provenanceSrc, vars)
+		if err != nil {
+			return job{}, fmt.Errorf("invalid --provenance value: %w", err)
+		}
+	}
+
+	// Cannot extract when outputting to stdout
+	if extractArchive && j.output == "-" {
+		return job{}, fmt.Errorf("cannot extract archive when output is stdout (-)")
+	}
+
+	return j, nil
+}
+
+// skipVerifiedJobs drops the jobs whose output file already exists with the
+// expected hash, and returns the ones still to download
+func skipVerifiedJobs(logger *slog.Logger, jobs []job) ([]job, error) {
+	newBar, err := verifyBars(logger)
+	if err != nil {
+		return nil, withExitCode(ExitUsage, err)
+	}
+	remaining := jobs[:0:0]
+	for _, j := range jobs {
+		if info, err := os.Stat(j.output); err != nil || !info.Mode().IsRegular() {
+			remaining = append(remaining, j)
+			continue
+		}
+		digest, err := hashFile(j.output, j.hashAlgo, newBar)
+		if err != nil {
+			return nil, err
+		}
+		if digest != j.hashDigest {
+			logger.Info("existing_file_mismatch", "file", j.output, "hint", "the file does not match the expected hash; downloading again")
+			remaining = append(remaining, j)
+			continue
+		}
+		logger.Info("download_skipped", "url", j.url, "file", j.output, "reason", "existing file matches the expected hash")
+		if writeOutTemplate != nil {
+			skipped := &downloader.Result{URL: j.url}
+			if err := renderWriteOut(os.Stdout, writeOutTemplate, writeOutData{Result: skipped, Filename: j.output, Skipped: true}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return remaining, nil
+}
+
+// runJob downloads a single URL and extracts it if requested. opts comes
+// from j; j supplies the signature and attestation to check.
+func runJob(ctx context.Context, tracker *cleanup.Tracker, logger *slog.Logger, opts downloader.Options, extractOpts archive.ExtractOptions, j job) error {
+	if err := runHook(ctx, logger, hookPre, execPre, hookArtifact{url: opts.URL, path: opts.Output}); err != nil {
+		return err
+	}
+	var stream *streamExtraction
+	if extractStream {
+		stream = newStreamExtraction(ctx, tracker, logger, opts.Output, extractOpts)
+		opts.Sink = stream
+	}
 	result, err := downloader.Download(ctx, tracker, opts)
+	// streamed is set when the archive was extracted from the body and never stored
+	streamed := false
+	if stream != nil {
+		streamed, err = stream.finish(err, result)
+	}
 	if err != nil {
+		if opts.StreamUnverified && errors.Is(err, downloader.ErrHashMismatch) {
+			logger.Error("streamed_output_unverified", "hint", "the data was already written to stdout; discard it")
+		}
+		var httpErr *downloader.HTTPError
+		if optional && errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			logger.Warn("optional_download_skipped", "url", opts.URL, "status", httpErr.StatusCode)
+			if writeOutTemplate != nil {
+				skipped := &downloader.Result{URL: opts.URL, HTTPCode: httpErr.StatusCode}
+				return renderWriteOut(os.Stdout, writeOutTemplate, writeOutData{Result: skipped, Filename: opts.Output, Skipped: true})
+			}
+			return nil
+		}
 		return err
 	}
 
@@ -362,86 +1466,149 @@ func run(cmd *cobra.Command, args []string) error {
 	finalOutputFile := result.OutputFile
 	if finalOutputFile == "" {
 		// Fallback to original output if result doesn't have OutputFile set (shouldn't happen, but safety)
-		finalOutputFile = output
+		finalOutputFile = opts.Output
 	}
 
 	// Note: file is already registered by downloader for cleanup
 
-	// Extract archive if requested
-	if extractArchive {
-		logger.Info("archive_detect_start")
-
-		archiveType, err := archive.Detect(finalOutputFile)
+	// A signature is checked before anything acts on the file; on failure the tracker removes it
+	if sig := j.signature; sig != nil {
+		if err := verifyMinisign(finalOutputFile, signingKey, sig); err != nil {
+			return withExitCode(ExitHashMismatch, err)
+		}
+		logger.Info("signature_verified", "file", finalOutputFile, "key_id", signingKey.ID(), "trusted_comment", sig.trustedComment)
+	}
+	var builder string
+	if j.provenance != nil {
+		s, err := j.provenance.verify(finalOutputFile, result.Digests[provenanceSubjectAlgo], provenanceBuilder)
 		if err != nil {
-			return fmt.Errorf("error detecting archive type: %w", err)
+			return withExitCode(ExitHashMismatch, err)
 		}
+		builder = s.builderID()
+		logger.Info("provenance_verified", "file", finalOutputFile, "builder", s.builderID(), "predicate_type", s.PredicateType, "source", j.provenance.source)
+	}
+	if xattr && !streamed {
+		writeXattrs(logger, finalOutputFile, opts.URL, result)
+	}
 
-		if archiveType == archive.Unknown {
-			return fmt.Errorf("unknown or unsupported archive format")
+	if fileMode != nil {
+		if err := os.Chmod(finalOutputFile, *fileMode); err != nil {
+			return fmt.Errorf("failed to set --chmod permissions: %w", err)
 		}
+		logger.Info("file_mode_set", "file", finalOutputFile, "mode", fmt.Sprintf("%04o", *fileMode))
+	}
 
-		logger.Info("archive_detected", "type", archiveType)
-		logger.Info("extraction_start")
+	if paranoid {
+		logger.Info("stream_checksum", "file", finalOutputFile, "algorithm", "crc32c", "digest", result.Digests["crc32c"], "bytes", result.BytesDownloaded)
+	}
 
-		// Get list of files before extraction to identify extracted files later
-		filesBeforeExtraction := tracker.GetAll()
+	artifact := hookArtifact{url: opts.URL, path: finalOutputFile, result: result}
+	if err := runHook(ctx, logger, hookPostDownload, execPostDownload, artifact); err != nil {
+		return err
+	}
 
-		// Create timeout context for extraction if specified
-		extractCtx := ctx
-		if extractTimeout > 0 {
-			var cancel context.CancelFunc
-			extractCtx, cancel = context.WithTimeout(ctx, extractTimeout)
-			defer cancel()
+	// A self-contained binary has nothing to extract: make it executable and
+	// keep it. --archive-type forces extraction, e.g. of a self-extracting zip.
+	executable := false
+	if extractArchive && !streamed && forcedArchiveType == archive.Unknown {
+		format, err := archive.DetectExecutable(finalOutputFile)
+		if err != nil {
+			return withExitCode(ExitExtraction, fmt.Errorf("error detecting file type: %w", err))
+		}
+		if format != "" {
+			// --chmod already chose the permissions
+			if fileMode == nil {
+				if err := os.Chmod(finalOutputFile, 0755); err != nil {
+					return fmt.Errorf("failed to set executable permission: %w", err)
+				}
+			}
+			logger.Info("executable_detected", "file", finalOutputFile, "format", format, "hint", "not an archive; extraction skipped and the executable bit set")
+			executable = true
 		}
+	}
 
-		opts := archive.ExtractOptions{
-			StripComponents: stripComponents,
-			MaxBytes:        extractMaxBytes,
+	// The downloader synced the file before its mode and attributes changed
+	if opts.Sync && !streamed && (fileMode != nil || xattr || executable) {
+		if err := util.SyncFile(finalOutputFile); err != nil {
+			return fmt.Errorf("failed to sync %s: %w", finalOutputFile, err)
 		}
-		if err := archive.Extract(extractCtx, tracker, finalOutputFile, archiveType, opts); err != nil {
-			return fmt.Errorf("error extracting archive: %w", err)
+	}
+
+	// Extract archive if requested
+	if extractArchive && !executable && !streamed {
+		if err := extractFile(ctx, tracker, logger, finalOutputFile, result.ContentType, extractOpts); err != nil {
+			return err
 		}
 
-		logger.Info("extraction_complete")
+		// Extraction succeeded, so the archive is kept even if a later step
+		// fails; it is only removed once every step below has succeeded
+		tracker.Unregister(finalOutputFile)
+	} else {
+		// No extraction requested - unregister on successful completion
+		if finalOutputFile != "" && finalOutputFile != "-" {
+			tracker.Unregister(finalOutputFile)
+		}
+	}
 
-		// Get list of files after extraction
-		filesAfterExtraction := tracker.GetAll()
+	if extractArchive && !executable {
+		artifact.extractDir = extractOpts.DestDir
+		if artifact.extractDir == "" {
+			artifact.extractDir = "."
+		}
+		if err := runHook(ctx, logger, hookPostExtract, execPostExtract, artifact); err != nil {
+			return err
+		}
+	}
 
-		// Unregister all extracted files (extraction succeeded, so keep them)
-		// This includes the archive file if removeArchive is false
-		for _, file := range filesAfterExtraction {
-			// Skip files that were already registered before extraction (archive file)
-			isArchiveFile := false
-			for _, beforeFile := range filesBeforeExtraction {
-				if file == beforeFile {
-					isArchiveFile = true
-					break
-				}
-			}
-			// Unregister extracted files (not the archive file)
-			if !isArchiveFile {
-				tracker.Unregister(file)
+	// Record the extraction for the next --extract-if-missing run, unless the archive provided the marker
+	if extractIfMissing != "" {
+		stamp := hashStamp(opts.HashAlgorithm, opts.ExpectedHash)
+		state, err := checkMarker(extractIfMissing, stamp)
+		if err != nil {
+			return err
+		}
+		if state != markerPresent {
+			if err := writeMarker(extractIfMissing, stamp); err != nil {
+				return err
 			}
+			logger.Info("marker_written", "marker", extractIfMissing)
 		}
+	}
 
-		// Handle archive file removal
-		if removeArchive {
-			if err := os.Remove(finalOutputFile); err != nil {
-				logger.Warn("archive_removal_failed", "file", finalOutputFile, "error", err)
-			} else {
-				logger.Info("archive_removed", "file", finalOutputFile)
-			}
-			// Unregister archive file since it was removed
-			tracker.Unregister(finalOutputFile)
+	// Remove the archive last, after every step that could still fail
+	archiveRemoved := false
+	if extractArchive && removeArchive && !executable && !streamed {
+		if err := os.Remove(finalOutputFile); err != nil {
+			logger.Warn("archive_removal_failed", "file", finalOutputFile, "error", err)
 		} else {
-			// Unregister archive file since extraction succeeded (keep the file)
-			tracker.Unregister(finalOutputFile)
+			logger.Info("archive_removed", "file", finalOutputFile)
+			archiveRemoved = true
 		}
-	} else {
-		// No extraction requested - unregister on successful completion
-		if finalOutputFile != "" && finalOutputFile != "-" {
-			tracker.Unregister(finalOutputFile)
+	}
+
+	if writeChecksum {
+		checksumPath := finalOutputFile + ".sha256"
+		line := fmt.Sprintf("%s  %s\n", result.Digests["sha256"], filepath.Base(finalOutputFile))
+		if err := os.WriteFile(checksumPath, []byte(line), 0644); err != nil {
+			return fmt.Errorf("failed to write checksum file: %w", err)
+		}
+		logger.Info("checksum_written", "file", checksumPath)
+	}
+
+	if writeMetadata {
+		metaPath, err := writeMetadataFile(finalOutputFile, opts.URL, result, builder)
+		if err != nil {
+			return err
 		}
+		logger.Info("metadata_written", "file", metaPath)
+	}
+
+	for _, algo := range printHashAlgos {
+		printDigest(os.Stdout, algo, result.Digests[algo], finalOutputFile)
+	}
+
+	if writeOutTemplate != nil {
+		return renderWriteOut(os.Stdout, writeOutTemplate, writeOutData{Result: result, Filename: finalOutputFile, ArchiveRemoved: archiveRemoved})
 	}
 
 	return nil
@@ -449,24 +1616,118 @@ func run(cmd *cobra.Command, args []string) error {
 
 // hashConfig holds configuration for a hash algorithm
 type hashConfig struct {
-	name      string
-	digestLen int
-	newHash   func() hash.Hash
+	name         string
+	digestLen    int
+	newHash      func() hash.Hash
+	fipsApproved bool // Allowed when --fips is active
+	weak         bool // Broken against deliberate collisions; accepted with a warning
 }
 
-// supportedHashes is a registry of supported hash algorithms
-// This design makes it easy to add blake3, sha3, etc. in the future
+// supportedHashes is a registry of supported hash algorithms. Entries that
+// are not fipsApproved are refused under --fips, and weak ones are accepted
+// with a warning.
 var supportedHashes = map[string]hashConfig{
 	"sha256": {
-		name:      "SHA-256",
-		digestLen: 64, // 256 bits = 64 hex chars
-		newHash:   sha256.New,
+		name:         "SHA-256",
+		digestLen:    64, // 256 bits = 64 hex chars
+		newHash:      sha256.New,
+		fipsApproved: true,
 	},
 	"sha512": {
-		name:      "SHA-512",
-		digestLen: 128, // 512 bits = 128 hex chars
-		newHash:   sha512.New,
+		name:         "SHA-512",
+		digestLen:    128, // 512 bits = 128 hex chars
+		newHash:      sha512.New,
+		fipsApproved: true,
+	},
+	"blake3": {
+		name:      "BLAKE3",
+		digestLen: 64, // 256-bit default output = 64 hex chars
+		newHash:   func() hash.Hash { return blake3.New(32, nil) },
+	},
+	// Legacy algorithms, for mirrors that only publish SHA1SUMS or MD5SUMS
+	"sha1": {
+		name:      "SHA-1",
+		digestLen: 40, // 160 bits = 40 hex chars
+		newHash:   sha1.New,
+		weak:      true,
+	},
+	"md5": {
+		name:      "MD5",
+		digestLen: 32, // 128 bits = 32 hex chars
+		newHash:   md5.New,
+		weak:      true,
 	},
+	// Non-cryptographic checksums, as published by GCS (x-goog-hash) and some firmware vendors
+	"crc32": {
+		name:      "CRC-32",
+		digestLen: 8, // 32 bits = 8 hex chars, big-endian
+		newHash:   func() hash.Hash { return crc32.NewIEEE() },
+		weak:      true,
+	},
+	"crc32c": {
+		name:      "CRC-32C",
+		digestLen: 8, // 32 bits = 8 hex chars, big-endian
+		newHash:   func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+		weak:      true,
+	},
+}
+
+// warnWeakHash logs a warning when algo only protects against accidental corruption
+func warnWeakHash(logger *slog.Logger, algo string) {
+	if config := supportedHashes[algo]; config.weak {
+		logger.Warn("weak_hash_algorithm", "algorithm", config.name, "hint", config.name+" collisions can be forged, so it only detects accidental corruption, not tampering; prefer sha256 or sha512 when the publisher offers them")
+	}
+}
+
+// checkHashPolicy rejects hash algorithms that the active crypto policy forbids
+func checkHashPolicy(algo string) error {
+	config, ok := supportedHashes[algo]
+	if !ok {
+		return fmt.Errorf("unsupported hash algorithm %q", algo)
+	}
+	if fipsMode && !config.fipsApproved {
+		return fmt.Errorf("hash algorithm %s is not FIPS-approved and cannot be used in FIPS mode", config.name)
+	}
+	return nil
+}
+
+// hashStamp formats a parsed --hash as "algo:digest", or "" without one
+func hashStamp(algo, digest string) string {
+	if digest == "" {
+		return ""
+	}
+	return algo + ":" + digest
+}
+
+// parsePrintHash parses the comma-separated --print-hash algorithms,
+// dropping duplicates and applying the hash policy to each
+func parsePrintHash(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var algos []string
+	for _, algo := range strings.Split(value, ",") {
+		algo = strings.ToLower(strings.TrimSpace(algo))
+		if algo == "" {
+			return nil, fmt.Errorf("empty algorithm name in %q", value)
+		}
+		if err := checkHashPolicy(algo); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(algos, algo) {
+			algos = append(algos, algo)
+		}
+	}
+	return algos, nil
+}
+
+// parseFileMode parses an octal permission mode such as "0755" or "644"
+func parseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("must be an octal mode from 0000 to 0777, got %q", value)
+	}
+	return os.FileMode(mode), nil
 }
 
 // parseExpectedHash parses a hash string that may include an algorithm prefix.
@@ -482,7 +1743,6 @@ func parseExpectedHash(hashStr string) (string, string, error) {
 	if len(parts) == 2 {
 		// Has prefix
 		algo := strings.ToLower(parts[0])
-		digest := strings.ToLower(parts[1])
 
 		// Validate algorithm is supported
 		config, ok := supportedHashes[algo]
@@ -494,20 +1754,181 @@ func parseExpectedHash(hashStr string) (string, string, error) {
 			return "", "", fmt.Errorf("unsupported hash algorithm %q. Supported algorithms: %s", algo, strings.Join(supported, ", "))
 		}
 
-		// Validate digest length
-		if len(digest) != config.digestLen {
-			return "", "", fmt.Errorf("invalid %s hash: expected %d hex characters, got %d", config.name, config.digestLen, len(digest))
+		digest, err := decodeDigest(config, parts[1])
+		if err != nil {
+			return "", "", err
 		}
+		return algo, digest, nil
+	} else {
+		return "", "", fmt.Errorf("hash must be prefixed with the algorithm name followed by a colon. example: sha256:{value}")
+	}
+}
 
-		// Validate hex characters
-		for _, c := range digest {
-			if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
-				return "", "", fmt.Errorf("invalid %s hash: contains non-hex character '%c'", config.name, c)
-			}
+// decodeDigest converts a digest to lowercase hex. It may be given in hex,
+// base64 (standard or URL alphabet, padded or not) or base32, either with
+// an explicit "hex:", "b64:" or "b32:" prefix or detected from its length.
+func decodeDigest(config hashConfig, value string) (string, error) {
+	size := config.digestLen / 2
+	if encoding, rest, ok := strings.Cut(value, ":"); ok {
+		var raw []byte
+		switch strings.ToLower(encoding) {
+		case "hex":
+			return checkHexDigest(config, rest)
+		case "b64", "base64":
+			raw = decodeBase64Digest(rest, size)
+		case "b32", "base32":
+			raw = decodeBase32Digest(rest, size)
+		default:
+			return "", fmt.Errorf("unsupported digest encoding %q: use hex, b64 or b32", encoding)
 		}
+		if raw == nil {
+			return "", fmt.Errorf("invalid %s hash: not %s of %d bytes", config.name, encoding, size)
+		}
+		return hex.EncodeToString(raw), nil
+	}
 
-		return algo, digest, nil
+	// Hex wins when it fits: a short base64 or base32 digest can have the
+	// same length as the hex one, but is unlikely to be all hex characters
+	digest, hexErr := checkHexDigest(config, value)
+	if hexErr == nil {
+		return digest, nil
+	}
+	if raw := decodeBase64Digest(value, size); raw != nil {
+		return hex.EncodeToString(raw), nil
+	}
+	if raw := decodeBase32Digest(value, size); raw != nil {
+		return hex.EncodeToString(raw), nil
+	}
+	if len(value) == config.digestLen {
+		return "", hexErr
+	}
+	return "", fmt.Errorf("invalid %s hash: expected %d hex characters or base64/base32 of %d bytes, got %d characters", config.name, config.digestLen, size, len(value))
+}
+
+// checkHexDigest validates a hex digest and returns it in lowercase
+func checkHexDigest(config hashConfig, digest string) (string, error) {
+	digest = strings.ToLower(digest)
+	if len(digest) != config.digestLen {
+		return "", fmt.Errorf("invalid %s hash: expected %d hex characters, got %d", config.name, config.digestLen, len(digest))
+	}
+	for _, c := range digest {
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
+			return "", fmt.Errorf("invalid %s hash: contains non-hex character '%c'", config.name, c)
+		}
+	}
+	return digest, nil
+}
+
+// decodeBase64Digest decodes a base64 digest of size bytes in any common
+// variant, or returns nil
+func decodeBase64Digest(value string, size int) []byte {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if raw, err := enc.DecodeString(value); err == nil && len(raw) == size {
+			return raw
+		}
+	}
+	return nil
+}
+
+// decodeBase32Digest decodes a base32 digest of size bytes, padded or not
+// and in either case, or returns nil
+func decodeBase32Digest(value string, size int) []byte {
+	value = strings.ToUpper(value)
+	for _, enc := range []*base32.Encoding{base32.StdEncoding, base32.StdEncoding.WithPadding(base32.NoPadding)} {
+		if raw, err := enc.DecodeString(value); err == nil && len(raw) == size {
+			return raw
+		}
+	}
+	return nil
+}
+
+// prepareExtractDir creates the --extract-dir directory, if one is set
+func prepareExtractDir(logger *slog.Logger, extractOpts archive.ExtractOptions) error {
+	if extractOpts.DestDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(extractOpts.DestDir, 0755); err != nil {
+		return withExitCode(ExitExtraction, fmt.Errorf("failed to create --extract-dir: %w", err))
+	}
+	logger.Info("extract_dir", "dir", extractOpts.DestDir)
+	return nil
+}
+
+// extractFile detects the archive type of path and extracts it into the
+// working directory. contentType is the Content-Type the file was served
+// with, used when neither its magic bytes nor its name give the type, and
+// --archive-type skips detection. Extracted files are removed if extraction
+// fails and kept (unregistered from the tracker) once it succeeds.
+func extractFile(ctx context.Context, tracker *cleanup.Tracker, logger *slog.Logger, path, contentType string, extractOpts archive.ExtractOptions) error {
+	var err error
+	detected, source := forcedArchiveType, "flag"
+	if detected == archive.Unknown {
+		logger.Info("archive_detect_start")
+		detected, source, err = archive.DetectWithFallback(path, contentType)
+		if err != nil {
+			return withExitCode(ExitExtraction, fmt.Errorf("error detecting archive type: %w", err))
+		}
+	}
+
+	if detected == archive.Unknown {
+		return withExitCode(ExitExtraction, fmt.Errorf("unknown or unsupported archive format (set --archive-type if the file is an archive)"))
+	}
+
+	logger.Info("archive_detected", "type", detected, "source", source)
+	if extractOpts.Manifest != nil {
+		logManifest(logger, extractOpts.Manifest)
+	}
+	logger.Info("extraction_start")
+
+	// The extracted files get their own scope, so the archive is left to the caller
+	scope := tracker.Scope()
+	var atomic *atomicExtraction
+	if extractAtomic {
+		if atomic, err = stageExtraction(logger, scope, &extractOpts); err != nil {
+			scope.Cleanup()
+			return withExitCode(ExitExtraction, err)
+		}
+	} else if err := prepareExtractDir(logger, extractOpts); err != nil {
+		scope.Release()
+		return err
+	}
+
+	// Create timeout context for extraction if specified
+	extractCtx := ctx
+	if extractTimeout > 0 {
+		var cancel context.CancelFunc
+		extractCtx, cancel = context.WithTimeout(ctx, extractTimeout)
+		defer cancel()
+	}
+
+	if extractSandbox {
+		// The child extracts nested archives too, in the same sandbox
+		if err := extractSandboxed(extractCtx, scope, logger, path, detected, extractOpts); err != nil {
+			scope.Cleanup()
+			return err
+		}
 	} else {
-		return "", "", fmt.Errorf("hash must be prefixed with the algorithm name followed by a colon. example: sha256:{value}")
+		if err := archive.Extract(extractCtx, scope, path, detected, extractOpts); err != nil {
+			scope.Cleanup()
+			return withExitCode(ExitExtraction, fmt.Errorf("error extracting archive: %w", err))
+		}
+		if extractNested {
+			if err := extractNestedArchives(extractCtx, scope, logger, extractOpts); err != nil {
+				scope.Cleanup()
+				return nestedExtractionError(err)
+			}
+		}
+	}
+	if atomic != nil {
+		if err := atomic.commit(logger); err != nil {
+			scope.Cleanup()
+			return withExitCode(ExitExtraction, err)
+		}
 	}
+
+	logger.Info("extraction_complete")
+
+	// Extraction succeeded, so keep the extracted files
+	scope.Release()
+	return nil
 }
@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/archive"
+	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// sandboxRequest is what an --extract-sandbox extraction passes to its child
+// process on stdin: the archive, the extraction options and the settings of
+// the flags the child needs
+type sandboxRequest struct {
+	Archive       string                 `json:"archive"`
+	Type          archive.Type           `json:"type"`
+	Options       archive.ExtractOptions `json:"options"` // Without Progress and Manifest
+	Manifest      map[string]string      `json:"manifest,omitempty"`
+	LogLevel      string                 `json:"log_level"`
+	LogFormat     string                 `json:"log_format"`
+	Nested        bool                   `json:"nested"`
+	NestedDepth   int                    `json:"nested_depth"`
+	RemoveArchive bool                   `json:"remove_archive"`
+}
+
+// newExtractSandboxedCmd returns the hidden command --extract-sandbox runs
+// as its child process
+func newExtractSandboxedCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:    "extract-sandboxed",
+		Short:  "Extract an archive in a sandbox (internal to --extract-sandbox)",
+		Args:   usageArgs(cobra.NoArgs),
+		Hidden: true,
+		RunE:   runExtractSandboxed,
+	}
+}
+
+// extractSandboxed extracts the archive at path into opts.DestDir, nested
+// archives included with --extract-nested, in a child process confined to
+// them with the sandbox package. The child reports the files it creates on
+// stdout; they are registered with scope if they are in the extraction
+// directory, so a compromised child cannot have other files removed.
+func extractSandboxed(ctx context.Context, scope *cleanup.Tracker, logger *slog.Logger, path string, archiveType archive.Type, opts archive.ExtractOptions) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return withExitCode(ExitExtraction, fmt.Errorf("failed to find the ripvex executable: %w", err))
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return withExitCode(ExitExtraction, fmt.Errorf("failed to get absolute path: %w", err))
+	}
+	dest := opts.DestDir
+	if dest == "" {
+		dest = "."
+	}
+	if dest, err = filepath.Abs(dest); err != nil {
+		return withExitCode(ExitExtraction, fmt.Errorf("failed to get absolute path: %w", err))
+	}
+	if dest, err = filepath.EvalSymlinks(dest); err != nil {
+		return withExitCode(ExitExtraction, fmt.Errorf("failed to resolve destination path: %w", err))
+	}
+
+	req := sandboxRequest{
+		Archive:       path,
+		Type:          archiveType,
+		Options:       opts,
+		LogLevel:      logLevel,
+		LogFormat:     logFormat,
+		Nested:        extractNested,
+		NestedDepth:   extractNestedDepth,
+		RemoveArchive: removeArchive,
+	}
+	if quiet {
+		req.LogLevel = "error"
+	}
+	req.Options.DestDir = dest
+	req.Options.Progress = nil
+	req.Options.Manifest = nil
+	if len(opts.Manifest) > 0 {
+		req.Manifest = make(map[string]string, len(opts.Manifest))
+		for name, e := range opts.Manifest {
+			req.Manifest[name] = e.Algorithm + ":" + e.Digest
+		}
+	}
+	input, err := json.Marshal(req)
+	if err != nil {
+		return withExitCode(ExitExtraction, fmt.Errorf("failed to encode sandbox request: %w", err))
+	}
+
+	var journal bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, "extract-sandboxed")
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &journal
+	cmd.Stderr = logOutput // The child logs to stderr
+	logger.Info("extract_sandbox_start", "archive", path, "dir", dest)
+	runErr := cmd.Run()
+
+	// Only the child has exited at this point, so the symlinks checked here
+	// can no longer change
+	var files int
+	for _, file := range strings.Split(journal.String(), "\x00") {
+		if !filepath.IsAbs(file) {
+			continue
+		}
+		parent, err := filepath.EvalSymlinks(filepath.Dir(file))
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(dest, filepath.Join(parent, filepath.Base(file))); err != nil || !filepath.IsLocal(rel) {
+			logger.Warn("extract_sandbox_path_rejected", "file", file)
+			continue
+		}
+		scope.Register(file)
+		files++
+	}
+
+	if runErr != nil {
+		if ctx.Err() != nil {
+			return withExitCode(ExitExtraction, fmt.Errorf("error extracting archive: %w", ctx.Err()))
+		}
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) && exitErr.ExitCode() > 0 {
+			// The child logged its error; keep the exit code it chose
+			return withExitCode(exitErr.ExitCode(), fmt.Errorf("sandboxed extraction failed with exit code %d", exitErr.ExitCode()))
+		}
+		return withExitCode(ExitExtraction, fmt.Errorf("sandboxed extraction failed: %w", runErr))
+	}
+	logger.Info("extract_sandbox_complete", "files", files)
+	return nil
+}
+
+// runExtractSandboxed is the child process of extractSandboxed. It confines
+// itself to the archive and the extraction directory before it reads a
+// byte of the archive.
+func runExtractSandboxed(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	tracker, ok := ctx.Value(trackerKey).(*cleanup.Tracker)
+	if !ok || tracker == nil {
+		return fmt.Errorf("internal error: cleanup tracker not found in context")
+	}
+
+	var req sandboxRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		return withExitCode(ExitUsage, fmt.Errorf("invalid sandbox request: %w", err))
+	}
+	logLevel, logFormat, quiet = req.LogLevel, req.LogFormat, false
+	ctx, logger, err := setupLogger(ctx)
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	opts := req.Options
+	if len(req.Manifest) > 0 {
+		opts.Manifest = make(archive.Manifest, len(req.Manifest))
+		for name, value := range req.Manifest {
+			algo, digest, _ := strings.Cut(value, ":")
+			hc, ok := supportedHashes[algo]
+			if !ok {
+				return withExitCode(ExitUsage, fmt.Errorf("invalid sandbox request: unsupported hash algorithm %q", algo))
+			}
+			opts.Manifest[name] = archive.ManifestEntry{Algorithm: algo, Digest: digest, NewHash: hc.newHash}
+		}
+	}
+	extractNestedDepth, removeArchive = req.NestedDepth, req.RemoveArchive
+
+	// The local time zone is loaded on first use, from a file the sandbox hides
+	_, _ = time.Now().Zone()
+	if err := sandbox.Restrict(sandbox.Paths{ReadFiles: []string{req.Archive}, WriteDirs: []string{opts.DestDir}}); err != nil {
+		return withExitCode(ExitExtraction, fmt.Errorf("failed to enter sandbox: %w", err))
+	}
+	logger.Debug("extract_sandbox_entered", "archive", req.Archive, "dir", opts.DestDir)
+
+	tracker.Journal(os.Stdout)
+	scope := tracker.Scope()
+	if err := archive.Extract(ctx, scope, req.Archive, req.Type, opts); err != nil {
+		return withExitCode(ExitExtraction, fmt.Errorf("error extracting archive: %w", err))
+	}
+	if req.Nested {
+		if err := extractNestedArchives(ctx, scope, logger, opts); err != nil {
+			return nestedExtractionError(err)
+		}
+	}
+	scope.Release()
+	return nil
+}
@@ -0,0 +1,479 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/archive"
+	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/lucrnz/ripvex/internal/jobserver"
+	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/lucrnz/ripvex/internal/sandbox"
+	"github.com/spf13/cobra"
+)
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Run end-to-end checks against an in-process HTTP server",
+	Long: `Run end-to-end checks against an in-process HTTP server.
+
+Exercises download, redirects, hash verification (including to stdout over a
+dropped connection), size limits and extraction of generated tar.gz
+(including multi-member gzip) and zip archives, the hash algorithm inferred
+for checksum file lines, and that the serve API refuses browser requests
+and the extraction sandbox denies io_uring, printing PASS or FAIL for each
+check.
+Useful for validating packaged builds on unusual platforms. Nothing leaves
+the machine and all files are written to a temporary directory.`,
+	Args: cobra.NoArgs,
+	RunE: runSelftest,
+}
+
+func init() {
+	rootCmd.AddCommand(selftestCmd, &cobra.Command{
+		Use:    "selftest-sandboxed",
+		Short:  "Check the syscalls denied in a sandbox (internal to selftest)",
+		Args:   usageArgs(cobra.NoArgs),
+		Hidden: true,
+		RunE:   runSelftestSandboxed,
+	})
+}
+
+// selftestPayload is the body served for plain downloads and archive members
+var selftestPayload = bytes.Repeat([]byte("ripvex selftest payload\n"), 4096)
+
+// selftestCheck is one named end-to-end check run against the test server
+type selftestCheck struct {
+	name string
+	run  func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error
+}
+
+func runSelftest(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	tracker, ok := ctx.Value(trackerKey).(*cleanup.Tracker)
+	if !ok || tracker == nil {
+		return fmt.Errorf("internal error: cleanup tracker not found in context")
+	}
+	// The checks provoke failures on purpose; their logs would only be noise
+	ctx = logging.WithContext(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	server, err := newSelftestServer()
+	if err != nil {
+		return fmt.Errorf("failed to prepare test server: %w", err)
+	}
+	defer server.Close()
+
+	// Extraction writes to the working directory, so run inside a scratch one
+	dir, err := os.MkdirTemp("", "ripvex-selftest-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	// Everything the checks left behind goes away with the scratch directory
+	tracker = tracker.Scope()
+	defer func() {
+		tracker.Release()
+		os.RemoveAll(dir)
+	}()
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		return fmt.Errorf("failed to change directory to %q: %w", dir, err)
+	}
+	defer os.Chdir(wd)
+
+	out := cmd.OutOrStdout()
+	failed := 0
+	for _, check := range selftestChecks {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		start := time.Now()
+		err := check.run(ctx, tracker, server.URL)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "FAIL %-24s %8s  %v\n", check.name, elapsed, err)
+			continue
+		}
+		fmt.Fprintf(out, "PASS %-24s %8s\n", check.name, elapsed)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d self-tests failed", failed, len(selftestChecks))
+	}
+	fmt.Fprintf(out, "all %d self-tests passed\n", len(selftestChecks))
+	return nil
+}
+
+// newSelftestServer serves the payload, a redirect to it and generated archives
+func newSelftestServer() (*httptest.Server, error) {
+	tarGz, err := selftestTarGz()
+	if err != nil {
+		return nil, err
+	}
+	zipData, err := selftestZip()
+	if err != nil {
+		return nil, err
+	}
+	multiGz, err := selftestMultiMemberTarGz()
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	serve := func(data []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			w.Write(data)
+		}
+	}
+	mux.HandleFunc("/payload.txt", serve(selftestPayload))
+	mux.HandleFunc("/archive.tar.gz", serve(tarGz))
+	mux.HandleFunc("/archive.zip", serve(zipData))
+	mux.HandleFunc("/multi.tar.gz", serve(multiGz))
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/payload.txt", http.StatusFound)
+	})
+	mux.HandleFunc("/truncated", func(w http.ResponseWriter, r *http.Request) {
+		// Promise the whole payload, then drop the connection halfway
+		w.Header().Set("Content-Length", fmt.Sprint(len(selftestPayload)))
+		w.Write(selftestPayload[:len(selftestPayload)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	})
+	return httptest.NewServer(mux), nil
+}
+
+func selftestTarGz() ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: "tgz/payload.txt", Mode: 0644, Size: int64(len(selftestPayload)), Typeflag: tar.TypeReg}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(selftestPayload); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// selftestMultiMemberTarGz compresses a tarball as two concatenated gzip
+// members, as parallel compressors like pigz do
+func selftestMultiMemberTarGz() ([]byte, error) {
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	if err := tw.WriteHeader(&tar.Header{Name: "multi/payload.txt", Mode: 0644, Size: int64(len(selftestPayload)), Typeflag: tar.TypeReg}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(selftestPayload); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	data := tarBuf.Bytes()
+	for _, member := range [][]byte{data[:len(data)/2], data[len(data)/2:]} {
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(member); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func selftestZip() ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("zip/payload.txt")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(selftestPayload); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// selftestDownload downloads path from the test server into output
+func selftestDownload(ctx context.Context, tracker *cleanup.Tracker, baseURL, path, output string, modify func(*downloader.Options)) (*downloader.Result, error) {
+	opts := downloader.Options{
+		URL:            baseURL + path,
+		Output:         output,
+		OutputExplicit: true,
+		Quiet:          true,
+		ConnectTimeout: 10 * time.Second,
+		MaxTime:        time.Minute,
+		MaxRedirects:   5,
+		RedirectPolicy: downloader.RedirectAny,
+		UserAgent:      "ripvex-selftest",
+		MaxBytes:       1 << 30,
+	}
+	if modify != nil {
+		modify(&opts)
+	}
+	result, err := downloader.Download(ctx, tracker, opts)
+	if err == nil {
+		// A successful download is kept like the CLI does; the scratch directory is removed later
+		tracker.Unregister(output)
+	}
+	return result, err
+}
+
+// checkFileContent fails unless path holds exactly the selftest payload
+func checkFileContent(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, selftestPayload) {
+		return fmt.Errorf("%s: content differs from the served payload (%d bytes, want %d)", path, len(data), len(selftestPayload))
+	}
+	return nil
+}
+
+// selftestExtract downloads an archive and extracts it into the scratch directory
+func selftestExtract(ctx context.Context, tracker *cleanup.Tracker, baseURL, path, output string, opts archive.ExtractOptions) error {
+	if _, err := selftestDownload(ctx, tracker, baseURL, path, output, nil); err != nil {
+		return err
+	}
+	archiveType, err := archive.Detect(output)
+	if err != nil {
+		return fmt.Errorf("error detecting archive type: %w", err)
+	}
+	return archive.Extract(ctx, tracker, output, archiveType, opts)
+}
+
+var selftestChecks = []selftestCheck{
+	{"download", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		result, err := selftestDownload(ctx, tracker, baseURL, "/payload.txt", "download.txt", nil)
+		if err != nil {
+			return err
+		}
+		if result.BytesDownloaded != int64(len(selftestPayload)) {
+			return fmt.Errorf("downloaded %d bytes, want %d", result.BytesDownloaded, len(selftestPayload))
+		}
+		return checkFileContent("download.txt")
+	}},
+	{"redirect", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		result, err := selftestDownload(ctx, tracker, baseURL, "/redirect", "redirect.txt", nil)
+		if err != nil {
+			return err
+		}
+		if result.RedirectCount != 1 {
+			return fmt.Errorf("followed %d redirects, want 1", result.RedirectCount)
+		}
+		return checkFileContent("redirect.txt")
+	}},
+	{"hash-match", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		sum := sha256.Sum256(selftestPayload)
+		result, err := selftestDownload(ctx, tracker, baseURL, "/payload.txt", "hash.txt", func(o *downloader.Options) {
+			o.HashAlgorithm = "sha256"
+			o.ExpectedHash = hex.EncodeToString(sum[:])
+		})
+		if err != nil {
+			return err
+		}
+		if !result.HashMatched {
+			return fmt.Errorf("hash was not reported as matched")
+		}
+		return nil
+	}},
+	{"hash-mismatch", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		_, err := selftestDownload(ctx, tracker, baseURL, "/payload.txt", "mismatch.txt", func(o *downloader.Options) {
+			o.HashAlgorithm = "sha256"
+			o.ExpectedHash = hex.EncodeToString(make([]byte, sha256.Size))
+		})
+		if !errors.Is(err, downloader.ErrHashMismatch) {
+			return fmt.Errorf("expected a hash mismatch, got: %v", err)
+		}
+		if _, statErr := os.Stat("mismatch.txt"); !os.IsNotExist(statErr) {
+			return fmt.Errorf("mismatched download was not removed")
+		}
+		return nil
+	}},
+	{"max-bytes", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		_, err := selftestDownload(ctx, tracker, baseURL, "/payload.txt", "limited.txt", func(o *downloader.Options) {
+			o.MaxBytes = int64(len(selftestPayload) / 2)
+		})
+		if !errors.Is(err, downloader.ErrMaxBytes) {
+			return fmt.Errorf("expected the size limit to trip, got: %v", err)
+		}
+		return nil
+	}},
+	{"stdout-hash-dropped", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("download panicked: %v", p)
+			}
+		}()
+		sum := sha256.Sum256(selftestPayload)
+		_, err = selftestDownload(ctx, tracker, baseURL, "/truncated", "-", func(o *downloader.Options) {
+			o.HashAlgorithm = "sha256"
+			o.ExpectedHash = hex.EncodeToString(sum[:])
+		})
+		if err == nil {
+			return fmt.Errorf("truncated download succeeded")
+		}
+		return nil
+	}},
+	{"checksum-inference", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		// 64 hex characters fit both SHA-256 and BLAKE3; only a named
+		// algorithm may pick BLAKE3
+		digest64, digest128 := strings.Repeat("ab", 32), strings.Repeat("cd", 64)
+		entries, err := parseChecksumFile(strings.NewReader(digest64 + "  a.bin\n" + digest128 + " *b.bin\nBLAKE3 (c.bin) = " + digest64 + "\n"))
+		if err != nil {
+			return err
+		}
+		if len(entries) != 3 {
+			return fmt.Errorf("parsed %d checksum lines, want 3", len(entries))
+		}
+		for i, want := range []string{"sha256", "sha512", "blake3"} {
+			if entries[i].algo != want {
+				return fmt.Errorf("checksum line %d: got %s, want %s", i+1, entries[i].algo, want)
+			}
+		}
+		return nil
+	}},
+	{"extract-tar-gz", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		if err := selftestExtract(ctx, tracker, baseURL, "/archive.tar.gz", "archive.tar.gz", archive.ExtractOptions{MaxBytes: 1 << 30}); err != nil {
+			return err
+		}
+		return checkFileContent("tgz/payload.txt")
+	}},
+	{"extract-multi-member", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		if err := selftestExtract(ctx, tracker, baseURL, "/multi.tar.gz", "multi.tar.gz", archive.ExtractOptions{MaxBytes: 1 << 30}); err != nil {
+			return err
+		}
+		return checkFileContent("multi/payload.txt")
+	}},
+	{"extract-zip", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		if err := selftestExtract(ctx, tracker, baseURL, "/archive.zip", "archive.zip", archive.ExtractOptions{MaxBytes: 1 << 30}); err != nil {
+			return err
+		}
+		return checkFileContent("zip/payload.txt")
+	}},
+	{"extract-max-bytes", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		err := selftestExtract(ctx, tracker, baseURL, "/archive.tar.gz", "limited.tar.gz", archive.ExtractOptions{MaxBytes: int64(len(selftestPayload) / 2)})
+		if !errors.Is(err, archive.ErrMaxBytes) {
+			return fmt.Errorf("expected the extraction limit to trip, got: %v", err)
+		}
+		return nil
+	}},
+	{"serve-rejects-browsers", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		return selftestServeRejects(ctx)
+	}},
+	{"sandbox-denies-io-uring", func(ctx context.Context, tracker *cleanup.Tracker, baseURL string) error {
+		// --extract-sandbox refuses to run where the sandbox is unavailable
+		if runtime.GOOS != "linux" || sandbox.Available() != nil {
+			return nil
+		}
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		if out, err := exec.CommandContext(ctx, exe, "selftest-sandboxed").CombinedOutput(); err != nil {
+			return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+		}
+		return nil
+	}},
+}
+
+// runSelftestSandboxed confines itself like an --extract-sandbox child and
+// fails unless io_uring_setup(2) is denied with EPERM
+func runSelftestSandboxed(cmd *cobra.Command, args []string) error {
+	if err := sandbox.Restrict(sandbox.Paths{}); err != nil {
+		return fmt.Errorf("failed to enter sandbox: %w", err)
+	}
+	if err := selftestIOURingSetup(); !errors.Is(err, syscall.EPERM) {
+		return fmt.Errorf("io_uring_setup in the sandbox returned %v, want EPERM", err)
+	}
+	return nil
+}
+
+// selftestServeRejects checks that the serve API refuses requests a web
+// page could send: a POST without a JSON content type, a request with an
+// Origin header, and a request for another host
+func selftestServeRejects(ctx context.Context) error {
+	// Prepare refuses every job, so an accepted request answers 400
+	errPrepared := errors.New("prepared")
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config.Handler = jobserver.New(jobserver.Config{
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Hosts:   []string{ts.Listener.Addr().String()},
+		Prepare: func(req jobserver.Request) (jobserver.Request, error) { return req, errPrepared },
+	})
+	ts.Start()
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	body := `{"url": "https://example.com/file.iso"}`
+	cases := []struct {
+		name   string
+		modify func(*http.Request)
+		status int
+	}{
+		{"json", func(r *http.Request) { r.Header.Set("Content-Type", "application/json; charset=utf-8") }, http.StatusBadRequest},
+		{"no content type", func(r *http.Request) {}, http.StatusUnsupportedMediaType},
+		{"form content type", func(r *http.Request) { r.Header.Set("Content-Type", "application/x-www-form-urlencoded") }, http.StatusUnsupportedMediaType},
+		{"text content type", func(r *http.Request) { r.Header.Set("Content-Type", "text/plain") }, http.StatusUnsupportedMediaType},
+		{"origin", func(r *http.Request) {
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Origin", "https://attacker.example")
+		}, http.StatusForbidden},
+		{"rebound host", func(r *http.Request) {
+			r.Header.Set("Content-Type", "application/json")
+			r.Host = net.JoinHostPort("attacker.example", port)
+		}, http.StatusMisdirectedRequest},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL+"/jobs", strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		c.modify(req)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			return fmt.Errorf("%s: got status %d, want %d", c.name, resp.StatusCode, c.status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package cli
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// selftestIOURingSetup creates an io_uring instance and closes it again
+func selftestIOURingSetup() error {
+	var params [120]byte // struct io_uring_params, zeroed
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, 1, uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return unix.Close(int(fd))
+}
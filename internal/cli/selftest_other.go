@@ -0,0 +1,10 @@
+//go:build !linux
+
+package cli
+
+import "errors"
+
+// selftestIOURingSetup creates an io_uring instance, which only Linux has
+func selftestIOURingSetup() error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,271 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/lucrnz/ripvex/internal/jobserver"
+	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/lucrnz/ripvex/internal/redact"
+	"github.com/lucrnz/ripvex/internal/util"
+	"github.com/lucrnz/ripvex/internal/version"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveListen          string
+	serveDir             string
+	serveWorkers         int
+	serveQueueSize       int
+	serveToken           string
+	serveMaxBytesStr     string
+	serveAllowUnsafeHTTP bool
+	serveLogFormat       string
+)
+
+// serveConnectTimeout is the connection timeout of the jobs, the default of --connect-timeout
+const serveConnectTimeout = 300 * time.Second
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a download manager with an HTTP API and a job queue",
+	Long: `Run a download manager with an HTTP API and a job queue.
+
+Jobs are posted as JSON, queued, and downloaded into --dir by --workers
+workers at a time:
+
+  POST   /jobs        enqueue {"url": "...", "output": "name", "hash": "sha256:..."}
+  GET    /jobs        list jobs with their state and progress
+  GET    /jobs/{id}   one job: state, bytes, total, percent, speed, error
+  DELETE /jobs/{id}   cancel a queued or running job
+
+"output" is a relative path below --dir, named after the URL by default, and
+"hash" is verified like --hash. Listening on anything but a loopback address
+requires --token, which clients send as "Authorization: Bearer <token>".
+
+The API is for programs, not browsers: POST /jobs requires
+"Content-Type: application/json", requests carrying an Origin header are
+refused, and so are requests whose Host is not the listen address.`,
+	Example: `  ripvex serve --dir /srv/downloads
+  curl -H 'Content-Type: application/json' -d '{"url": "https://example.com/file.iso", "hash": "sha256:abc123..."}' http://127.0.0.1:8780/jobs
+  RIPVEX_TOKEN=secret ripvex serve --listen 0.0.0.0:8780 --workers 4`,
+	Args:    cobra.NoArgs,
+	PreRunE: applyEnv,
+	RunE:    runServe,
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveListen, "listen", "127.0.0.1:8780", "Address to listen on")
+	serveCmd.Flags().StringVar(&serveDir, "dir", ".", "Directory the jobs download into")
+	serveCmd.Flags().IntVar(&serveWorkers, "workers", 2, "Number of jobs downloading at the same time")
+	serveCmd.Flags().IntVar(&serveQueueSize, "queue-size", 100, "Number of jobs that can wait for a worker; more are refused with 503")
+	serveCmd.Flags().StringVar(&serveToken, "token", "", "Bearer token API clients must send. Required unless listening on a loopback address; prefer RIPVEX_TOKEN to keep it out of process arguments")
+	serveCmd.Flags().StringVar(&serveMaxBytesStr, "max-bytes", "4GiB", "Maximum bytes to download per job (e.g., \"4GiB\", \"512MB\")")
+	serveCmd.Flags().BoolVar(&serveAllowUnsafeHTTP, "allow-unsafe-http", false, "Accept plain HTTP jobs without a hash (unsafe)")
+	serveCmd.Flags().StringVar(&serveLogFormat, "log-format", "text", "Log format: text or json")
+	_ = serveCmd.RegisterFlagCompletionFunc("log-format", cobra.FixedCompletions([]string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp))
+	_ = serveCmd.MarkFlagDirname("dir")
+	// Defined by root's init, which runs first as root.go sorts before serve.go
+	shareFlags(serveCmd, "allow-host", "deny-host")
+	rootCmd.AddCommand(serveCmd)
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	tracker, ok := ctx.Value(trackerKey).(*cleanup.Tracker)
+	if !ok || tracker == nil {
+		return fmt.Errorf("internal error: cleanup tracker not found in context")
+	}
+
+	logger, err := logging.New("info", serveLogFormat)
+	if err != nil {
+		return withExitCode(ExitUsage, fmt.Errorf("invalid logging configuration: %w", err))
+	}
+	cleanup.SetLogger(logger)
+	ctx = logging.WithContext(ctx, logger)
+
+	maxBytes, err := util.ParseByteSize(serveMaxBytesStr)
+	if err != nil {
+		return withExitCode(ExitUsage, fmt.Errorf("invalid --max-bytes value: %w", err))
+	}
+	if serveWorkers < 1 || serveQueueSize < 1 {
+		return withExitCode(ExitUsage, fmt.Errorf("--workers and --queue-size must be at least 1"))
+	}
+	hostPolicy, err := newHostPolicy()
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	dir, err := filepath.Abs(serveDir)
+	if err != nil {
+		return withExitCode(ExitUsage, fmt.Errorf("invalid --dir value: %w", err))
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return withExitCode(ExitUsage, fmt.Errorf("--dir %s is not a directory", serveDir))
+	}
+	host, _, err := net.SplitHostPort(serveListen)
+	if err != nil {
+		return withExitCode(ExitUsage, fmt.Errorf("invalid --listen value: %w", err))
+	}
+	if ip := net.ParseIP(host); serveToken == "" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return withExitCode(ExitUsage, fmt.Errorf("--token is required to listen on %s: anyone who can reach it could write files into --dir", serveListen))
+	}
+
+	ln, err := net.Listen("tcp", serveListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", serveListen, err)
+	}
+
+	redact.Register(serveToken)
+	server := jobserver.New(jobserver.Config{
+		Workers:   serveWorkers,
+		QueueSize: serveQueueSize,
+		Token:     serveToken,
+		Logger:    logger,
+		Hosts:     serveHosts(host, ln.Addr()),
+		Prepare: func(req jobserver.Request) (jobserver.Request, error) {
+			return prepareServeJob(req, dir, hostPolicy)
+		},
+		Run: func(ctx context.Context, req jobserver.Request, progress downloader.ProgressFactory) (string, error) {
+			return runServeJob(ctx, tracker, req, maxBytes, hostPolicy, progress)
+		},
+		ExitCode: ExitCode,
+	})
+	httpServer := &http.Server{Handler: server, ReadHeaderTimeout: 10 * time.Second}
+	logger.Info("serve_listening", "url", "http://"+ln.Addr().String(), "dir", dir, "workers", serveWorkers)
+
+	workersDone := make(chan struct{})
+	go func() {
+		server.Run(ctx)
+		close(workersDone)
+	}()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpServer.Serve(ln)
+	}()
+
+	var serveErr error
+	select {
+	case err := <-errCh:
+		serveErr = fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	// Running jobs are canceled with ctx and remove their files
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) && serveErr == nil {
+		serveErr = fmt.Errorf("server shutdown failed: %w", err)
+	}
+	if serveErr == nil {
+		<-workersDone
+	}
+	logger.Info("serve_stopped")
+	return serveErr
+}
+
+// serveHosts returns the Host headers the API accepts when listening on
+// host: the listen address, and localhost on a loopback address. A wildcard
+// address has no fixed name, so any host is accepted there; --token, which
+// it requires, keeps rebound pages out instead.
+func serveHosts(host string, addr net.Addr) []string {
+	ip := net.ParseIP(host)
+	if host == "" || ip != nil && ip.IsUnspecified() {
+		return nil
+	}
+	_, port, _ := net.SplitHostPort(addr.String())
+	hosts := []string{addr.String(), net.JoinHostPort(host, port)}
+	if host == "localhost" || ip.IsLoopback() {
+		hosts = append(hosts, net.JoinHostPort("localhost", port))
+	}
+	return hosts
+}
+
+// prepareServeJob validates a posted job like the download flags are
+// validated, and resolves its output below dir
+func prepareServeJob(req jobserver.Request, dir string, policy *downloader.HostPolicy) (jobserver.Request, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return req, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return req, fmt.Errorf("unsupported URL scheme %q: only http and https are supported", u.Scheme)
+	}
+	if err := policy.Check(u); err != nil {
+		return req, err
+	}
+	if _, _, err := parseExpectedHash(req.Hash); err != nil {
+		return req, fmt.Errorf("invalid hash: %w", err)
+	}
+	if u.Scheme == "http" && req.Hash == "" && !serveAllowUnsafeHTTP {
+		return req, fmt.Errorf("plain http jobs require a hash, or the server to run with --allow-unsafe-http")
+	}
+
+	out := req.Output
+	if out == "" {
+		out = path.Base(u.Path)
+		if out == "/" || out == "." {
+			out = "download"
+		}
+	}
+	if !filepath.IsLocal(out) {
+		return req, fmt.Errorf("output %q must be a relative path below the download directory", out)
+	}
+	resolved, err := util.ResolvePathWithinBase(filepath.Join(dir, out), dir)
+	if err != nil {
+		return req, fmt.Errorf("output %q must be a relative path below the download directory", out)
+	}
+	if info, err := os.Stat(resolved); err == nil && info.IsDir() {
+		return req, fmt.Errorf("output %q is a directory", out)
+	}
+	req.URL, req.Output = u.String(), resolved
+	return req, nil
+}
+
+// runServeJob downloads a prepared job. Its files are removed if it fails
+// or is canceled.
+func runServeJob(ctx context.Context, tracker *cleanup.Tracker, req jobserver.Request, maxBytes int64, policy *downloader.HostPolicy, progress downloader.ProgressFactory) (string, error) {
+	logger := logging.FromContext(ctx)
+	if err := os.MkdirAll(filepath.Dir(req.Output), 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	// Other ripvex processes may write the same file
+	lock, err := lockOutput(ctx, logger, req.Output, -1)
+	if err != nil {
+		return "", err
+	}
+	defer lock.release()
+
+	hashAlgo, hashDigest, _ := parseExpectedHash(req.Hash)
+	scope := tracker.Scope()
+	result, err := downloader.Download(ctx, scope, downloader.Options{
+		URL:              req.URL,
+		Output:           req.Output,
+		OutputExplicit:   true,
+		HashAlgorithm:    hashAlgo,
+		ExpectedHash:     hashDigest,
+		DigestAlgorithms: []string{"sha256"},
+		ConnectTimeout:   serveConnectTimeout,
+		MaxRedirects:     30,
+		HostPolicy:       policy,
+		UserAgent:        version.UserAgent(),
+		MaxBytes:         maxBytes,
+		Preallocate:      true,
+		Progress:         progress,
+	})
+	if err != nil {
+		scope.Cleanup()
+		return "", err
+	}
+	scope.Release()
+	return "sha256:" + result.Digests["sha256"], nil
+}
@@ -0,0 +1,208 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/lucrnz/ripvex/internal/archive"
+	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/downloader"
+)
+
+// streamExtraction is the download sink of --extract-stream. It holds back
+// the first archive.DetectSize bytes of the body to detect the archive
+// type, then pipes a tar-based archive into archive.ExtractStream while the
+// download goes on. Anything else is spooled to a temporary file next to
+// the output, which finish renames to the output for the regular
+// extraction path.
+type streamExtraction struct {
+	ctx     context.Context
+	tracker *cleanup.Tracker
+	scope   *cleanup.Tracker // Extracted files; released with the job
+	logger  *slog.Logger
+	output  string
+	opts    archive.ExtractOptions
+
+	head    []byte
+	decided bool
+	pw      *io.PipeWriter
+	done    chan error
+	spool   *os.File
+	atomic  *atomicExtraction
+}
+
+func newStreamExtraction(ctx context.Context, tracker *cleanup.Tracker, logger *slog.Logger, output string, opts archive.ExtractOptions) *streamExtraction {
+	return &streamExtraction{
+		ctx:     ctx,
+		tracker: tracker,
+		scope:   tracker.Scope(),
+		logger:  logger,
+		output:  output,
+		opts:    opts,
+		head:    make([]byte, 0, archive.DetectSize),
+	}
+}
+
+// Write receives the response body from the downloader
+func (s *streamExtraction) Write(p []byte) (int, error) {
+	n := len(p)
+	if !s.decided {
+		take := min(len(p), archive.DetectSize-len(s.head))
+		s.head = append(s.head, p[:take]...)
+		if len(s.head) < archive.DetectSize {
+			return n, nil
+		}
+		if err := s.start(); err != nil {
+			return 0, err
+		}
+		p = p[take:]
+	}
+	if err := s.write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// write passes body data on to the extraction or the spool file
+func (s *streamExtraction) write(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if s.pw != nil {
+		_, err := s.pw.Write(p)
+		return err
+	}
+	_, err := s.spool.Write(p)
+	return err
+}
+
+// start picks streaming or spooling from the archive type of the head, or
+// --archive-type, and passes the head on
+func (s *streamExtraction) start() error {
+	archiveType, source := forcedArchiveType, "flag"
+	if archiveType == archive.Unknown {
+		archiveType, source = archive.DetectBytes(s.head), archive.DetectedByMagic
+	}
+	s.logger.Info("archive_detected", "type", archiveType, "source", source)
+
+	if archive.Streamable(archiveType) {
+		if extractAtomic {
+			atomic, err := stageExtraction(s.logger, s.scope, &s.opts)
+			if err != nil {
+				return withExitCode(ExitExtraction, err)
+			}
+			s.atomic = atomic
+		} else if err := prepareExtractDir(s.logger, s.opts); err != nil {
+			return err
+		}
+		if s.opts.Manifest != nil {
+			logManifest(s.logger, s.opts.Manifest)
+		}
+		s.logger.Info("extraction_start", "streamed", true)
+		pr, pw := io.Pipe()
+		s.pw = pw
+		s.done = make(chan error, 1)
+		go func() {
+			err := archive.ExtractStream(s.ctx, s.scope, pr, s.output, archiveType, s.opts)
+			if err != nil {
+				// Fail the download instead of leaving it blocked on the pipe
+				pr.CloseWithError(err)
+			} else {
+				// The body is still hashed to the end, past the tar trailer
+				io.Copy(io.Discard, pr)
+			}
+			s.done <- err
+		}()
+	} else {
+		f, err := os.CreateTemp(filepath.Dir(s.output), ".ripvex-stream-*")
+		if err != nil {
+			return fmt.Errorf("error creating temp file: %w", err)
+		}
+		s.tracker.Register(f.Name())
+		s.spool = f
+		s.logger.Info("extract_stream_spooled", "type", archiveType, "file", f.Name(), "hint", "only tar-based archives are extracted while downloading")
+	}
+	s.decided = true
+	return s.write(s.head)
+}
+
+// finish ends the extraction once the download has returned with
+// downloadErr. It reports whether the archive was extracted from the
+// stream; otherwise the body was spooled and is now at the output path,
+// ready for the regular extraction.
+func (s *streamExtraction) finish(downloadErr error, result *downloader.Result) (bool, error) {
+	if !s.decided {
+		if downloadErr != nil {
+			s.scope.Cleanup()
+			return false, downloadErr
+		}
+		// A body shorter than the detection window
+		if err := s.start(); err != nil {
+			s.scope.Cleanup()
+			return false, err
+		}
+	}
+
+	if s.spool != nil {
+		spoolPath := s.spool.Name()
+		closeErr := s.spool.Close()
+		s.scope.Cleanup()
+		if downloadErr == nil && closeErr != nil {
+			downloadErr = fmt.Errorf("error closing temp file: %w", closeErr)
+		}
+		if downloadErr != nil {
+			os.Remove(spoolPath)
+			s.tracker.Unregister(spoolPath)
+			return false, downloadErr
+		}
+		output := s.output
+		if result != nil && result.OutputFile != "" {
+			output = result.OutputFile
+		}
+		if err := os.Rename(spoolPath, output); err != nil {
+			os.Remove(spoolPath)
+			s.tracker.Unregister(spoolPath)
+			return false, fmt.Errorf("error moving temp file to output: %w", err)
+		}
+		s.tracker.Unregister(spoolPath)
+		s.tracker.Register(output)
+		return false, nil
+	}
+
+	if downloadErr != nil {
+		s.pw.CloseWithError(downloadErr)
+	} else {
+		s.pw.Close()
+	}
+	extractErr := <-s.done
+	if extractErr != nil && (downloadErr == nil || !errors.Is(extractErr, downloadErr)) {
+		// The extraction failed first, which also failed the download
+		s.scope.Cleanup()
+		return false, withExitCode(ExitExtraction, fmt.Errorf("error extracting archive: %w", extractErr))
+	}
+	if downloadErr != nil {
+		// Never keep files extracted from a body that failed verification
+		s.scope.Cleanup()
+		return false, downloadErr
+	}
+
+	if extractNested {
+		if err := extractNestedArchives(s.ctx, s.scope, s.logger, s.opts); err != nil {
+			s.scope.Cleanup()
+			return false, nestedExtractionError(err)
+		}
+	}
+	if s.atomic != nil {
+		if err := s.atomic.commit(s.logger); err != nil {
+			s.scope.Cleanup()
+			return false, withExitCode(ExitExtraction, err)
+		}
+	}
+	s.logger.Info("extraction_complete", "streamed", true)
+	return true, nil
+}
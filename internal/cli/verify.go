@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyHashFile      string
+	verifyCheck         bool
+	verifyIgnoreMissing bool
+)
+
+// newVerifyCmd returns `ripvex verify`, a portable `sha256sum -c`
+func newVerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify [<file> <hash> | --hash <hash> <file> | --hash-file <sums> [--check | <file>...]]",
+		Short: "Check local files against expected hashes",
+		Long: `Check local files against expected hashes, like sha256sum -c.
+
+The expected hash is given as an argument or with --hash, in --hash format
+(e.g. sha256:abc...). --hash-file reads a checksum file in sha256sum/shasum,
+BSD (SHA256 (name) = ...) or "ripvex hash" format; the algorithm is taken
+from the line or inferred from the digest length. With --check every listed
+file is verified, otherwise only the named files are looked up in it.
+
+Prints "<file>: OK" or "<file>: FAILED" per file. Exits 5 if any file does
+not match and 1 if a file cannot be read. Use "-" for stdin.`,
+		Example: `  ripvex verify release.tar.gz sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+  ripvex verify -H sha256:9f86d0... release.tar.gz
+  ripvex verify --hash-file SHA256SUMS --check
+  ripvex verify --hash-file SHA256SUMS release.tar.gz`,
+		Args:    cobra.ArbitraryArgs,
+		PreRunE: applyEnv,
+		RunE:    runVerify,
+	}
+	cmd.Flags().StringVar(&verifyHashFile, "hash-file", "", "Checksum file listing expected hashes (\"-\" for stdin)")
+	cmd.Flags().BoolVarP(&verifyCheck, "check", "c", false, "Verify every file listed in --hash-file")
+	cmd.Flags().BoolVar(&verifyIgnoreMissing, "ignore-missing", false, "With --check, skip listed files that do not exist")
+	shareFlags(cmd, append([]string{"hash"}, localHashFlags...)...)
+	return cmd
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	entries, err := verifyEntries(cmd, args)
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	_, logger, err := setupLogger(cmd.Context())
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	var algos []string
+	for _, e := range entries {
+		if err := checkLocalHashPolicy(e.algo); err != nil {
+			return withExitCode(ExitUsage, err)
+		}
+		if !slices.Contains(algos, e.algo) {
+			algos = append(algos, e.algo)
+			warnWeakHash(logger, e.algo)
+		}
+	}
+	newBar, err := verifyBars(logger)
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+
+	out := cmd.OutOrStdout()
+	var mismatched, unreadable, checked int
+	var lastMismatch error
+	for _, e := range entries {
+		if cmd.Context().Err() != nil {
+			return cmd.Context().Err()
+		}
+		if verifyIgnoreMissing && e.name != "-" {
+			if _, err := os.Stat(e.name); errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+		}
+		checked++
+
+		computed, err := hashFile(e.name, e.algo, newBar)
+		if err != nil {
+			unreadable++
+			fmt.Fprintf(out, "%s: FAILED open or read\n", e.name)
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if computed != e.digest {
+			mismatched++
+			lastMismatch = fmt.Errorf("%s: %w: expected %s, got %s", e.name, downloader.ErrHashMismatch, e.digest, computed)
+			fmt.Fprintf(out, "%s: FAILED\n", e.name)
+			continue
+		}
+		if !quiet {
+			fmt.Fprintf(out, "%s: OK\n", e.name)
+		}
+	}
+
+	switch {
+	case mismatched == 1 && len(entries) == 1:
+		return lastMismatch
+	case mismatched > 0:
+		return fmt.Errorf("%w: %d of %d files did not match", downloader.ErrHashMismatch, mismatched, checked)
+	case unreadable > 0:
+		return fmt.Errorf("%d of %d files could not be read", unreadable, checked)
+	case checked == 0:
+		return fmt.Errorf("no listed file was found")
+	}
+	return nil
+}
+
+// verifyEntries resolves the arguments of verify into the files to check
+func verifyEntries(cmd *cobra.Command, args []string) ([]checksumEntry, error) {
+	if verifyHashFile == "" {
+		if verifyCheck {
+			return nil, fmt.Errorf("--check requires --hash-file")
+		}
+		if verifyIgnoreMissing {
+			return nil, fmt.Errorf("--ignore-missing requires --hash-file")
+		}
+		hashStr := expectedHash
+		switch {
+		case cmd.Flags().Changed("hash") && len(args) == 1:
+		case !cmd.Flags().Changed("hash") && len(args) == 2:
+			hashStr = args[1]
+		default:
+			return nil, fmt.Errorf("expected <file> <hash>, --hash <hash> <file> or --hash-file")
+		}
+		algo, digest, err := parseExpectedHash(hashStr)
+		if err != nil {
+			return nil, err
+		}
+		return []checksumEntry{{algo: algo, digest: digest, name: args[0]}}, nil
+	}
+
+	if cmd.Flags().Changed("hash") {
+		return nil, fmt.Errorf("--hash cannot be combined with --hash-file")
+	}
+	if verifyCheck && len(args) > 0 {
+		return nil, fmt.Errorf("--check verifies every file in --hash-file and takes no file arguments")
+	}
+	if !verifyCheck && len(args) == 0 {
+		return nil, fmt.Errorf("name the files to look up in --hash-file, or pass --check to verify all of them")
+	}
+	if verifyIgnoreMissing && !verifyCheck {
+		return nil, fmt.Errorf("--ignore-missing requires --check")
+	}
+
+	var r io.Reader = os.Stdin
+	if verifyHashFile != "-" {
+		f, err := os.Open(verifyHashFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open --hash-file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	all, err := parseChecksumFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --hash-file %s: %w", verifyHashFile, err)
+	}
+	if verifyCheck {
+		return all, nil
+	}
+
+	// Look up only the named files
+	entries := make([]checksumEntry, 0, len(args))
+	for _, name := range args {
+		i := slices.IndexFunc(all, func(e checksumEntry) bool { return e.name == name })
+		if i == -1 {
+			return nil, fmt.Errorf("no checksum for %s in %s", name, verifyHashFile)
+		}
+		entries = append(entries, all[i])
+	}
+	return entries, nil
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+)
+
+// writeOutData is the data passed to the --write-out template. It exposes
+// every downloader.Result field plus CLI-level details.
+type writeOutData struct {
+	*downloader.Result
+	Filename string // Output file name ("-" for stdout)
+	Skipped  bool   // Download was skipped (e.g. --optional and HTTP 404)
+	// ArchiveRemoved reports whether the archive was deleted after extraction
+	ArchiveRemoved bool
+}
+
+// parseWriteOut compiles a --write-out template. The escapes \n, \t and \\
+// are interpreted like curl does, so templates can be passed in single
+// quotes from a shell.
+func parseWriteOut(format string) (*template.Template, error) {
+	format = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t").Replace(format)
+	tmpl, err := template.New("write-out").Option("missingkey=error").Parse(format)
+	if err != nil {
+		return nil, err
+	}
+	// Render once against empty data so unknown fields fail before downloading
+	if err := tmpl.Execute(io.Discard, writeOutData{Result: &downloader.Result{}}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// renderWriteOut executes the --write-out template for one download
+func renderWriteOut(w io.Writer, tmpl *template.Template, data writeOutData) error {
+	if data.Result == nil {
+		data.Result = &downloader.Result{}
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		return fmt.Errorf("error rendering --write-out template: %w", err)
+	}
+	return nil
+}
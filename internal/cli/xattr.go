@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// writeXattrs records where a download came from and how it was verified in
+// user.ripvex.* extended attributes, plus user.xdg.origin.url as wget
+// --xattr writes it. Failures only log a warning: many filesystems have no
+// user xattrs, and the download itself succeeded.
+func writeXattrs(logger *slog.Logger, path, rawURL string, result *downloader.Result) {
+	origin := withoutUserinfo(rawURL)
+	attrs := [][2]string{
+		{"user.xdg.origin.url", origin},
+		{"user.ripvex.url", origin},
+		{"user.ripvex.time", time.Now().UTC().Format(time.RFC3339)},
+	}
+	if result.ETag != "" {
+		attrs = append(attrs, [2]string{"user.ripvex.etag", result.ETag})
+	}
+	if result.Hash != "" {
+		attrs = append(attrs, [2]string{"user.ripvex.digest", result.HashAlgorithm + ":" + result.Hash})
+	}
+
+	for _, a := range attrs {
+		if err := util.SetXattr(path, a[0], []byte(a[1])); err != nil {
+			logger.Warn("xattr_failed", "file", path, "attribute", a[0], "error", err)
+			return
+		}
+	}
+	logger.Debug("xattrs_written", "file", path, "count", len(attrs))
+}
@@ -0,0 +1,165 @@
+// Package credhelper looks up the token of a host in the OS credential store
+// or with an external command, for --auth-helper. Stores hold one entry per
+// host under the service name "ripvex":
+//
+//   - keychain: a macOS generic password with service ripvex and the host as account
+//   - secretservice: a Secret Service item with attributes service=ripvex and host=<host>
+//   - wincred: a Windows generic credential with target ripvex:<host>
+//   - command:<cmd>: cmd run through the shell, printing the token
+package credhelper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/lucrnz/ripvex/internal/logging"
+)
+
+// Service is the service name entries are stored under
+const Service = "ripvex"
+
+// Names of the stores, the values of --auth-helper besides command:<cmd>
+const (
+	Keychain      = "keychain"
+	SecretService = "secretservice"
+	WinCred       = "wincred"
+)
+
+// commandPrefix introduces an external helper command
+const commandPrefix = "command:"
+
+// Environment of a helper command. The names must not be those of flags
+// under RIPVEX_, or a ripvex run by the helper would read them as its own.
+const (
+	envHost = "RIPVEX_CREDENTIAL_HOST"
+	envURL  = "RIPVEX_CREDENTIAL_URL"
+)
+
+// Helper looks up tokens. Each host is looked up once; later requests to it
+// reuse the answer.
+type Helper struct {
+	kind    string
+	command string
+
+	mu     sync.Mutex
+	tokens map[string]string // By host; "" when the store has none
+}
+
+// New returns the helper spec names: keychain, secretservice, wincred or
+// command:<cmd>
+func New(spec string) (*Helper, error) {
+	h := &Helper{kind: spec, tokens: make(map[string]string)}
+	switch {
+	case spec == Keychain:
+		if runtime.GOOS != "darwin" {
+			return nil, fmt.Errorf("the keychain helper is only available on macOS")
+		}
+	case spec == SecretService:
+		if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+			return nil, fmt.Errorf("the secretservice helper is not available on %s", runtime.GOOS)
+		}
+	case spec == WinCred:
+		if runtime.GOOS != "windows" {
+			return nil, fmt.Errorf("the wincred helper is only available on Windows")
+		}
+	case strings.HasPrefix(spec, commandPrefix):
+		h.kind, h.command = commandPrefix, strings.TrimSpace(strings.TrimPrefix(spec, commandPrefix))
+		if h.command == "" {
+			return nil, fmt.Errorf("command: needs a command to run")
+		}
+	default:
+		return nil, fmt.Errorf("unknown helper %q: must be keychain, secretservice, wincred or command:<cmd>", spec)
+	}
+	return h, nil
+}
+
+// Token returns the token stored for host, or "" if there is none. rawURL
+// is the requested URL, passed on to helper commands.
+func (h *Helper) Token(ctx context.Context, host, rawURL string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if token, ok := h.tokens[host]; ok {
+		return token, nil
+	}
+
+	var token string
+	var err error
+	switch h.kind {
+	case Keychain:
+		// Exit status 44: the item could not be found
+		token, err = lookup(ctx, 44, "security", "find-generic-password", "-s", Service, "-a", host, "-w")
+	case SecretService:
+		// secret-tool exits 1 without output when nothing matches
+		token, err = lookup(ctx, 1, "secret-tool", "lookup", "service", Service, "host", host)
+	case WinCred:
+		token, err = readWinCred(Service + ":" + host)
+	case commandPrefix:
+		token, err = h.runCommand(ctx, host, rawURL)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s helper failed for %s: %w", h.Name(), host, err)
+	}
+	h.tokens[host] = token
+	return token, nil
+}
+
+// Name returns the helper name for logs: the store, or "command"
+func (h *Helper) Name() string {
+	return strings.TrimSuffix(h.kind, ":")
+}
+
+// lookup runs a store's command line tool and returns the first line it
+// prints. Exiting with notFound means the store has no entry.
+func lookup(ctx context.Context, notFound int, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == notFound {
+		return "", nil
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return firstLine(out), nil
+}
+
+// runCommand runs the helper command through the shell, with the host and
+// URL in RIPVEX_CREDENTIAL_HOST and RIPVEX_CREDENTIAL_URL. It prints the
+// token, or nothing if it has none; its stderr goes to ours, so it can
+// prompt.
+func (h *Helper) runCommand(ctx context.Context, host, rawURL string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", h.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", h.command)
+	}
+	cmd.Env = append(os.Environ(), envHost+"="+host, envURL+"="+rawURL)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = logging.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", context.Cause(ctx)
+		}
+		return "", err
+	}
+	return firstLine(out), nil
+}
+
+func firstLine(out []byte) string {
+	line, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimRight(line, "\r")
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package credhelper
+
+import "errors"
+
+// readWinCred is only reached on Windows; New refuses wincred elsewhere
+func readWinCred(target string) (string, error) {
+	return "", errors.ErrUnsupported
+}
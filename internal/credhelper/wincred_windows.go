@@ -0,0 +1,77 @@
+package credhelper
+
+import (
+	"errors"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	advapi32     = windows.NewLazySystemDLL("advapi32.dll")
+	procCredRead = advapi32.NewProc("CredReadW")
+	procCredFree = advapi32.NewProc("CredFree")
+)
+
+// credTypeGeneric is CRED_TYPE_GENERIC, the type cmdkey /generic creates
+const credTypeGeneric = 1
+
+// credential mirrors CREDENTIALW
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// readWinCred returns the secret of the generic credential target, or "" if
+// there is none
+func readWinCred(target string) (string, error) {
+	name, err := windows.UTF16PtrFromString(target)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	ret, _, err := procCredRead.Call(uintptr(unsafe.Pointer(name)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		if errors.Is(err, windows.ERROR_NOT_FOUND) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	if !isUTF16(blob) {
+		return string(blob), nil
+	}
+	u := make([]uint16, len(blob)/2)
+	for i := range u {
+		u[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+// isUTF16 reports whether blob looks like the UTF-16LE text cmdkey and the
+// Credential Manager store, rather than the UTF-8 other tools write: tokens
+// are ASCII, so every second byte is zero
+func isUTF16(blob []byte) bool {
+	if len(blob) == 0 || len(blob)%2 != 0 {
+		return false
+	}
+	for i := 1; i < len(blob); i += 2 {
+		if blob[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
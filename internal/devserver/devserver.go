@@ -0,0 +1,322 @@
+// Package devserver implements a local HTTP server with injectable faults,
+// for developing wrappers and retry configurations against realistic
+// failure modes.
+package devserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// Faults understood by the "fault" parameter
+const (
+	FaultNone        = ""
+	FaultDrip        = "drip"         // Send the body at "rate" bytes per second
+	FaultReset       = "reset"        // Reset the TCP connection after "after" bytes
+	FaultLengthLong  = "length-long"  // Announce "by" more bytes than are sent, then close
+	FaultLengthShort = "length-short" // Announce "by" fewer bytes than are sent
+	FaultStall       = "stall"        // Send headers and "after" bytes, then hang
+	FaultTooMany     = "429"          // Answer 429 "count" times per path before succeeding
+	FaultStatus      = "status"       // Answer with status "code"
+)
+
+// Faults lists every supported fault, for help text and validation
+var Faults = []string{FaultDrip, FaultReset, FaultLengthLong, FaultLengthShort, FaultStall, FaultTooMany, FaultStatus}
+
+// Config configures a Server
+type Config struct {
+	Dir    string       // Serve files from this directory (optional)
+	Faults url.Values   // Default fault parameters; the request query overrides them
+	Logger *slog.Logger // Receives one record per request
+}
+
+// Server serves fixtures and injects the faults requested by the query string.
+// Fixtures are files under Config.Dir and generated bodies at /bytes/<size>.
+type Server struct {
+	cfg Config
+
+	mu       sync.Mutex
+	attempts map[string]int // 429 responses sent per path since its last success
+}
+
+// New creates a Server
+func New(cfg Config) *Server {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Server{cfg: cfg, attempts: make(map[string]int)}
+}
+
+// fixture is a body the server can send
+type fixture struct {
+	name    string
+	size    int64
+	modTime time.Time
+	content io.ReadSeeker
+	close   func() error
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := s.params(r)
+	fault := params.Get("fault")
+	s.cfg.Logger.Info("devserver_request", "method", r.Method, "path", r.URL.Path, "fault", fault, "remote", r.RemoteAddr)
+
+	if d := params.Get("delay"); d != "" {
+		delay, err := util.ParseDuration(d)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid delay: %v", err), http.StatusBadRequest)
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	// Faults that do not need a body
+	switch fault {
+	case FaultTooMany:
+		if s.tooMany(w, r, params) {
+			return
+		}
+	case FaultStatus:
+		code, err := strconv.Atoi(paramOr(params, "code", "503"))
+		if err != nil || code < 100 || code > 999 {
+			http.Error(w, "invalid code", http.StatusBadRequest)
+			return
+		}
+		if ra := params.Get("retry-after"); ra != "" {
+			w.Header().Set("Retry-After", ra)
+		}
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	f, err := s.open(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.close()
+
+	switch fault {
+	case FaultNone, FaultTooMany:
+		// ServeContent also answers Range and conditional requests
+		http.ServeContent(w, r, f.name, f.modTime, f.content)
+	case FaultDrip:
+		rate, err := sizeParam(params, "rate", 1024)
+		if err != nil || rate <= 0 {
+			http.Error(w, "invalid rate", http.StatusBadRequest)
+			return
+		}
+		s.drip(w, r, f, rate)
+	case FaultStall:
+		after, err := sizeParam(params, "after", 0)
+		if err != nil {
+			http.Error(w, "invalid after", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(f.size, 10))
+		w.WriteHeader(http.StatusOK)
+		io.CopyN(w, f.content, min(after, f.size))
+		http.NewResponseController(w).Flush()
+		<-r.Context().Done()
+	case FaultReset, FaultLengthLong, FaultLengthShort:
+		s.raw(w, r, f, fault, params)
+	default:
+		http.Error(w, fmt.Sprintf("unknown fault %q (supported: %s)", fault, strings.Join(Faults, ", ")), http.StatusBadRequest)
+	}
+}
+
+// params merges the configured defaults with the request query
+func (s *Server) params(r *http.Request) url.Values {
+	params := url.Values{}
+	for k, v := range s.cfg.Faults {
+		params[k] = v
+	}
+	for k, v := range r.URL.Query() {
+		params[k] = v
+	}
+	return params
+}
+
+// open resolves a request path to a generated or on-disk fixture
+func (s *Server) open(p string) (*fixture, error) {
+	if sizeStr, ok := strings.CutPrefix(p, "/bytes/"); ok {
+		size, err := util.ParseByteSize(sizeStr)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size %q", sizeStr)
+		}
+		return &fixture{
+			name:    path.Base(p),
+			size:    size,
+			content: io.NewSectionReader(patternReader{}, 0, size),
+			close:   func() error { return nil },
+		}, nil
+	}
+
+	if s.cfg.Dir == "" {
+		return nil, fmt.Errorf("not found: serve /bytes/<size> or start devserver with --dir")
+	}
+	// http.Dir rejects paths escaping the directory
+	file, err := http.Dir(s.cfg.Dir).Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("not found")
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, fmt.Errorf("not found")
+	}
+	return &fixture{name: info.Name(), size: info.Size(), modTime: info.ModTime(), content: file, close: file.Close}, nil
+}
+
+// tooMany answers 429 until the path has been refused "count" times in a row,
+// and reports whether it did
+func (s *Server) tooMany(w http.ResponseWriter, r *http.Request, params url.Values) bool {
+	count, err := strconv.Atoi(paramOr(params, "count", "3"))
+	if err != nil || count < 0 {
+		http.Error(w, "invalid count", http.StatusBadRequest)
+		return true
+	}
+
+	s.mu.Lock()
+	s.attempts[r.URL.Path]++
+	refused := s.attempts[r.URL.Path] <= count
+	if !refused {
+		// Start a new storm on the next request
+		delete(s.attempts, r.URL.Path)
+	}
+	s.mu.Unlock()
+
+	if !refused {
+		return false
+	}
+	w.Header().Set("Retry-After", paramOr(params, "retry-after", "1"))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	return true
+}
+
+// drip sends the body in ten chunks per second so the rate stays smooth
+func (s *Server) drip(w http.ResponseWriter, r *http.Request, f *fixture, rate int64) {
+	w.Header().Set("Content-Length", strconv.FormatInt(f.size, 10))
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	chunk := max(rate/10, 1)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		n, err := io.CopyN(w, f.content, chunk)
+		if n > 0 {
+			rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// raw takes over the connection to send responses net/http refuses to
+// produce: a wrong Content-Length or a body cut off by a TCP reset
+func (s *Server) raw(w http.ResponseWriter, r *http.Request, f *fixture, fault string, params url.Values) {
+	by, err := sizeParam(params, "by", 1024)
+	if err != nil {
+		http.Error(w, "invalid by", http.StatusBadRequest)
+		return
+	}
+	after, err := sizeParam(params, "after", f.size/2)
+	if err != nil {
+		http.Error(w, "invalid after", http.StatusBadRequest)
+		return
+	}
+
+	conn, bw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("fault %s needs HTTP/1.1: %v", fault, err), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	announced, send := f.size, f.size
+	switch fault {
+	case FaultLengthLong:
+		announced = f.size + by
+	case FaultLengthShort:
+		announced = max(f.size-by, 0)
+	case FaultReset:
+		send = min(after, f.size)
+	}
+
+	fmt.Fprintf(bw, "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\nConnection: close\r\n\r\n", announced)
+	io.CopyN(bw, f.content, send)
+	bw.Flush()
+
+	if fault == FaultReset {
+		resetConn(conn)
+	}
+}
+
+// resetConn closes conn with SO_LINGER 0, so the peer sees a TCP RST instead
+// of an orderly shutdown
+func resetConn(conn net.Conn) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// patternReader generates deterministic content, so clients can hash what
+// they received from /bytes/<size>
+type patternReader struct{}
+
+func (patternReader) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = byte((off + int64(i)) % 251)
+	}
+	return len(p), nil
+}
+
+func paramOr(params url.Values, key, fallback string) string {
+	if v := params.Get(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func sizeParam(params url.Values, key string, fallback int64) (int64, error) {
+	v := params.Get(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := util.ParseByteSize(v)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("%s must not be negative", key)
+	}
+	return n, nil
+}
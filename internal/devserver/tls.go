@@ -0,0 +1,73 @@
+package devserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// TLS modes. Every mode uses a throwaway self-signed certificate, so even
+// "self-signed" fails verification in a client that does not trust it.
+const (
+	TLSSelfSigned = "self-signed" // Valid for localhost, but signed by nobody
+	TLSExpired    = "expired"     // Expired yesterday
+	TLSWrongHost  = "wrong-host"  // Issued for invalid.example
+	TLSLegacy     = "legacy"      // Only TLS 1.0 and 1.1, below ripvex's default minimum
+)
+
+// TLSModes lists every supported TLS mode
+var TLSModes = []string{TLSSelfSigned, TLSExpired, TLSWrongHost, TLSLegacy}
+
+// TLSConfig returns a server TLS configuration exhibiting the given fault
+func TLSConfig(mode string) (*tls.Config, error) {
+	now := time.Now()
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "ripvex devserver"},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(24 * time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	config := &tls.Config{}
+
+	switch mode {
+	case TLSSelfSigned:
+	case TLSExpired:
+		template.NotBefore = now.Add(-48 * time.Hour)
+		template.NotAfter = now.Add(-24 * time.Hour)
+	case TLSWrongHost:
+		template.DNSNames = []string{"invalid.example"}
+		template.IPAddresses = nil
+	case TLSLegacy:
+		config.MinVersion = tls.VersionTLS10
+		config.MaxVersion = tls.VersionTLS11
+	default:
+		return nil, fmt.Errorf("unknown TLS mode %q", mode)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	template.SerialNumber = serial
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	config.Certificates = []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}
+	return config, nil
+}
@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/redact"
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// HeaderAssertion is a predicate on a response header, checked before any
+// data is written
+type HeaderAssertion struct {
+	Name    string // Canonical header name
+	Pattern string // Glob the value must match ('*' any sequence, '?' one character)
+	Absent  bool   // Header must not be present
+}
+
+// ParseHeaderAssertion parses an assertion in one of these forms:
+//
+//	"Name: pattern"  header present with a value matching the glob
+//	"Name"           header present with any value
+//	"!Name"          header absent
+func ParseHeaderAssertion(s string) (HeaderAssertion, error) {
+	s = strings.TrimSpace(s)
+	if rest, ok := strings.CutPrefix(s, "!"); ok {
+		name := strings.TrimSpace(rest)
+		if name == "" || strings.Contains(name, ":") {
+			return HeaderAssertion{}, fmt.Errorf("invalid header assertion %q: expected \"!Name\"", s)
+		}
+		return HeaderAssertion{Name: textproto.CanonicalMIMEHeaderKey(name), Absent: true}, nil
+	}
+
+	name, pattern, hasPattern := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return HeaderAssertion{}, fmt.Errorf("invalid header assertion %q: header name cannot be empty", s)
+	}
+	pattern = strings.TrimSpace(pattern)
+	if !hasPattern || pattern == "" {
+		pattern = "*"
+	}
+	return HeaderAssertion{Name: textproto.CanonicalMIMEHeaderKey(name), Pattern: pattern}, nil
+}
+
+// String returns the assertion in the form accepted by ParseHeaderAssertion
+func (a HeaderAssertion) String() string {
+	if a.Absent {
+		return "!" + a.Name
+	}
+	return a.Name + ": " + a.Pattern
+}
+
+// Check verifies the assertion against response headers
+func (a HeaderAssertion) Check(h http.Header) error {
+	values, present := h[a.Name]
+	if a.Absent {
+		if present {
+			return &AssertionError{Assertion: a, Reason: "header is present"}
+		}
+		return nil
+	}
+	if !present {
+		return &AssertionError{Assertion: a, Reason: "header is missing"}
+	}
+	for _, v := range values {
+		if util.MatchGlob(a.Pattern, v) {
+			return nil
+		}
+	}
+	return &AssertionError{Assertion: a, Reason: fmt.Sprintf("got %q", redact.Header(a.Name, strings.Join(values, ", ")))}
+}
+
+// AssertionError is returned when a response fails a HeaderAssertion
+type AssertionError struct {
+	Assertion HeaderAssertion
+	Reason    string
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("header assertion %q failed: %s", e.Assertion.String(), e.Reason)
+}
@@ -0,0 +1,43 @@
+package downloader
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/lucrnz/ripvex/internal/redact"
+)
+
+// TokenSource looks up the bearer token of a host, such as a
+// credhelper.Helper. Token returns "" when it has none for host.
+type TokenSource interface {
+	Token(ctx context.Context, host, rawURL string) (string, error)
+	Name() string
+}
+
+// applyAuthHelper sends the token the credential helper has for the request
+// host as a bearer token. Like applyNetrc, it leaves requests alone that
+// carry an Authorization header or a URL password, and newCheckRedirect
+// drops the token when a redirect leaves the origin.
+func applyAuthHelper(ctx context.Context, req *http.Request, h TokenSource, logger *slog.Logger) error {
+	if h == nil || req.Header.Get("Authorization") != "" {
+		return nil
+	}
+	if u := req.URL.User; u != nil {
+		if _, ok := u.Password(); ok {
+			return nil
+		}
+	}
+	token, err := h.Token(ctx, req.URL.Hostname(), redact.URL(req.URL))
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		logger.Debug("auth_helper_no_credentials", "helper", h.Name(), "host", req.URL.Hostname())
+		return nil
+	}
+	redact.Register(token)
+	req.Header.Set("Authorization", "Bearer "+token)
+	logger.Debug("auth_helper_credentials", "helper", h.Name(), "host", req.URL.Hostname())
+	return nil
+}
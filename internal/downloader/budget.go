@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Phases of a download, as reported by TimeoutError
+const (
+	PhaseConnect  = "connect"  // Until the final response headers arrive, redirects included
+	PhaseTransfer = "transfer" // Reading, hashing and writing the body
+	PhaseVerify   = "verify"   // Releasing a body that was buffered for verification
+)
+
+// TimeoutError is returned when Options.Deadline or a phase budget runs out.
+// It unwraps to context.DeadlineExceeded.
+type TimeoutError struct {
+	Phase  string        // Phase that was running when time ran out
+	Budget time.Duration // The phase budget that ran out; 0 when Options.Deadline passed
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Budget == 0 {
+		return fmt.Sprintf("deadline exceeded during %s phase", e.Phase)
+	}
+	return fmt.Sprintf("%s phase exceeded its %s budget", e.Phase, e.Budget)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// phaseBudget cancels a download's context, with a TimeoutError as the
+// cause, when Options.Deadline passes or the running phase overruns its budget
+type phaseBudget struct {
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	mu       sync.Mutex
+	phase    string
+	timer    *time.Timer // Budget of the running phase
+	deadline *time.Timer
+}
+
+func newPhaseBudget(ctx context.Context, deadline time.Time) *phaseBudget {
+	b := &phaseBudget{}
+	b.ctx, b.cancel = context.WithCancelCause(ctx)
+	if !deadline.IsZero() {
+		b.deadline = time.AfterFunc(time.Until(deadline), func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.cancel(&TimeoutError{Phase: b.phase})
+		})
+	}
+	return b
+}
+
+// enter starts phase, replacing the budget of the previous one. A zero
+// budget leaves the phase bounded only by the deadline and the context.
+func (b *phaseBudget) enter(phase string, budget time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.phase = phase
+	if budget > 0 {
+		b.timer = time.AfterFunc(budget, func() {
+			b.cancel(&TimeoutError{Phase: phase, Budget: budget})
+		})
+	}
+}
+
+// stop releases the timers and the context
+func (b *phaseBudget) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	if b.deadline != nil {
+		b.deadline.Stop()
+	}
+	b.cancel(nil)
+}
+
+// explain replaces an error caused by cancellation with its cause: a
+// TimeoutError, or the cause the caller gave its own context
+func (b *phaseBudget) explain(err error) error {
+	if err == nil || b.ctx.Err() == nil {
+		return err
+	}
+	cause := context.Cause(b.ctx)
+	var timeout *TimeoutError
+	if errors.As(cause, &timeout) {
+		return timeout
+	}
+	if errors.Is(err, cause) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", err, cause)
+}
+
+// contextReader fails reads once ctx is done, for copies that would not
+// otherwise notice cancellation
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if c.ctx.Err() != nil {
+		return 0, context.Cause(c.ctx)
+	}
+	return c.r.Read(p)
+}
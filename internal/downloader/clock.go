@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// clockSkewThreshold is how far the server Date header may drift from the
+// local clock before a warning is emitted. Generous enough to ignore caches
+// that don't send Age, small enough to catch devices booted without NTP.
+const clockSkewThreshold = 15 * time.Minute
+
+// checkClockSkew warns when the response Date header (adjusted by Age) is far
+// from the local clock. Date is only second-precision and may be cached, so
+// this is advisory and never fails the download.
+func checkClockSkew(resp *http.Response, now time.Time, logger *slog.Logger) {
+	dateHeader := resp.Header.Get("Date")
+	if dateHeader == "" {
+		return
+	}
+	serverTime, err := http.ParseTime(dateHeader)
+	if err != nil {
+		return
+	}
+	if age, err := strconv.Atoi(resp.Header.Get("Age")); err == nil && age > 0 {
+		serverTime = serverTime.Add(time.Duration(age) * time.Second)
+	}
+
+	skew := now.Sub(serverTime)
+	if skew.Abs() < clockSkewThreshold {
+		return
+	}
+	direction := "ahead of"
+	if skew < 0 {
+		direction = "behind"
+	}
+	logger.Warn("clock_skew_detected",
+		"skew", skew.Round(time.Second).String(),
+		"local_time", now.UTC().Format(time.RFC3339),
+		"server_time", serverTime.UTC().Format(time.RFC3339),
+		"hint", fmt.Sprintf("local clock appears to be %s the server by %s; check NTP/RTC if TLS or freshness checks fail", direction, skew.Abs().Round(time.Second)),
+	)
+}
+
+// explainCertificateTimeError adds a clock-skew explanation to certificate
+// validity errors. A certificate that is "not yet valid" almost always means
+// the local clock is behind (common on embedded devices without an RTC).
+// Returns err unchanged when it is not a validity-period failure.
+func explainCertificateTimeError(err error, now time.Time, logger *slog.Logger) error {
+	var certErr x509.CertificateInvalidError
+	if !errors.As(err, &certErr) || certErr.Reason != x509.Expired || certErr.Cert == nil {
+		return err
+	}
+	cert := certErr.Cert
+	local := now.UTC().Format(time.RFC3339)
+
+	if now.Before(cert.NotBefore) {
+		logger.Warn("clock_skew_suspected",
+			"local_time", local,
+			"cert_not_before", cert.NotBefore.UTC().Format(time.RFC3339),
+			"cert_subject", cert.Subject.String(),
+		)
+		return fmt.Errorf("%w (certificate is not valid until %s but local time is %s: the system clock is probably wrong)",
+			err, cert.NotBefore.UTC().Format(time.RFC3339), local)
+	}
+
+	logger.Warn("certificate_expired",
+		"local_time", local,
+		"cert_not_after", cert.NotAfter.UTC().Format(time.RFC3339),
+		"cert_subject", cert.Subject.String(),
+	)
+	return fmt.Errorf("%w (certificate expired at %s, local time is %s: if the server certificate is current, the system clock is wrong)",
+		err, cert.NotAfter.UTC().Format(time.RFC3339), local)
+}
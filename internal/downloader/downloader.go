@@ -1,103 +1,229 @@
 package downloader
 
 import (
+	"bufio"
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
+	"hash/crc32"
 	"io"
 	"log/slog"
 	"mime"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/lucrnz/ripvex/internal/cleanup"
+	"github.com/lucrnz/ripvex/internal/fips"
 	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/lucrnz/ripvex/internal/netrc"
 	"github.com/lucrnz/ripvex/internal/progress"
 	"github.com/lucrnz/ripvex/internal/util"
+	"lukechampine.com/blake3"
 )
 
 // Options configures the download behavior
 type Options struct {
 	URL                    string
-	Output                 string // Output file path, or "-" for stdout
-	OutputExplicit         bool   // Whether --output was explicitly set by user
+	Output                 string      // Output file path, or "-" for stdout; only a label when Sink or SinkAt is set
+	Sink                   io.Writer   // Receives the body instead of Output. Data is streamed before hash verification, so discard it on error
+	SinkAt                 io.WriterAt // Like Sink, with the body written at its offsets from 0; for buffers and regions addressed by position
+	OutputExplicit         bool        // Whether --output was explicitly set by user
+	OutputDir              string      // Directory for a server-derived name; Output already includes it
+	InferExtension         bool        // Append an extension sniffed from the body or Content-Type when the output name has none
 	Quiet                  bool
 	HashAlgorithm          string            // Hash algorithm name (e.g., "sha256", "sha512")
 	ExpectedHash           string            // Hex string to verify against (digest only, without algorithm prefix)
+	DigestAlgorithms       []string          // Also compute these digests of the body, reported in Result.Digests
+	StreamUnverified       bool              // With Output "-", stream the body before verifying ExpectedHash instead of buffering it
+	HashFromHeaders        bool              // Without ExpectedHash, verify against a digest advertised in the response headers
 	ConnectTimeout         time.Duration     // Maximum time for connection establishment
 	MaxTime                time.Duration     // Maximum total time for the entire operation (0 = unlimited)
+	Deadline               time.Time         // Deadline for this call, on top of the context's (zero = none)
+	ConnectBudget          time.Duration     // Maximum time until the final response headers, redirects included (0 = unlimited)
+	TransferBudget         time.Duration     // Maximum time to read, hash and write the body (0 = unlimited)
+	VerifyBudget           time.Duration     // Maximum time to release a body buffered for verification to stdout (0 = unlimited)
 	MaxRedirects           int               // Maximum number of redirects to follow
+	RedirectPolicy         string            // Redirect policy: any, same-host, same-origin, https-upgrade-only
+	HostPolicy             *HostPolicy       // Hosts the request and its redirects may go to (nil = any)
 	UserAgent              string            // User-Agent header to send with HTTP requests
 	MaxBytes               int64             // Maximum allowed download size in bytes (0 = unlimited)
 	ProgressInterval       time.Duration     // Interval between progress updates
 	LogFormat              string            // text or json
 	LogProgressStep        int               // Percentage step for milestone logs
 	LogProgressStepUnknown int64             // Byte step for milestone logs when size unknown
+	ProgressLogger         *slog.Logger      // Destination for progress events (nil = the context logger)
+	ProgressTerminal       *os.File          // Draw an interactive progress bar on this terminal instead of logging progress
+	Progress               ProgressFactory   // Reports progress instead of the logged or drawn progress
 	AllowInsecureTLS       bool              // Allow TLS 1.0/1.1 (insecure)
+	FIPS                   bool              // Restrict TLS to FIPS-approved versions, cipher suites and curves
 	Headers                map[string]string // Custom HTTP headers to send
+	Netrc                  *netrc.File       // Credentials for requests without an Authorization header (nil = none)
+	AuthHelper             TokenSource       // Bearer tokens for requests without an Authorization header, looked up before Netrc (nil = none)
+	Verbose                int               // Verbosity of request/response tracing (0 = off)
+	VerboseWriter          io.Writer         // Destination for verbose tracing (defaults to stderr)
+	TraceWriter            io.Writer         // Destination for JSON wire trace events (nil = disabled)
+	DumpHeaderWriter       io.Writer         // Destination for raw response headers (nil = disabled)
+	DumpRedirectHeaders    bool              // Also dump headers of intermediate redirect responses
+	HeaderAssertions       []HeaderAssertion // Predicates the final response headers must satisfy
+	MaxAge                 time.Duration     // Fail with StaleError when Last-Modified is older than this; 0 disables
+	MaxAgeWarnOnly         bool              // Log a stale resource instead of failing
+	Partial                bool              // Download to Output+".part" and keep it on failure, resuming it on the next call
+	Sync                   bool              // Flush the output file and its directory to stable storage before returning
+	FsyncIntervalBytes     int64             // Also flush the output file every this many bytes while writing (0 = disabled)
+	Preallocate            bool              // Reserve disk space for the output file when the body size is known
 }
 
 // Result contains the outcome of a download
 type Result struct {
 	BytesDownloaded int64
 	HashMatched     bool
-	OutputFile      string // Final output filename used (for archive extraction)
+	OutputFile      string            // Final output filename used (for archive extraction)
+	URL             string            // Effective URL after redirects
+	HTTPCode        int               // Status code of the final response
+	ContentType     string            // Content-Type of the final response
+	ETag            string            // ETag of the final response, if any
+	ContentLength   int64             // Content-Length of the final response (-1 if unknown)
+	RedirectCount   int               // Number of redirects followed
+	Redirects       []string          // URLs that redirected to URL, from the requested one on
+	Header          http.Header       // Headers of the final response
+	StartTime       time.Time         // When the request was sent
+	TimeResponse    time.Duration     // Time until the final response headers were received
+	TimeTotal       time.Duration     // Time until the download finished
+	HTTPVersion     string            // Protocol of the final response, e.g. "HTTP/2.0"
+	TLSVersion      string            // Negotiated TLS version, empty for plain HTTP
+	SpeedAverage    int64             // Average body transfer rate in bytes per second
+	SpeedPeak       int64             // Highest rate over any one-second window, in bytes per second
+	Digests         map[string]string // Hex digest of the body for each of Options.DigestAlgorithms
+	HashAlgorithm   string            // Algorithm the body was verified with; empty when it was not verified
+	Hash            string            // Hex digest the body was verified against
 }
 
-// Download fetches a URL and writes it to the specified output
-func Download(ctx context.Context, tracker *cleanup.Tracker, opts Options) (*Result, error) {
+// HTTPError is returned when the server responds with a non-200 status
+type HTTPError struct {
+	StatusCode int
+	Status     string // e.g. "404 Not Found"
+}
+
+func (e *HTTPError) Error() string {
+	return "HTTP " + e.Status
+}
+
+// NetworkError marks a failure talking to the server: DNS, connect, TLS,
+// redirects, timeouts or a body that ended early
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// ErrMaxBytes is returned when the body exceeds Options.MaxBytes
+	ErrMaxBytes = errors.New("download exceeded maximum size limit")
+	// ErrHashMismatch is returned when the body does not match Options.ExpectedHash
+	ErrHashMismatch = errors.New("hash mismatch")
+)
+
+// Download fetches a URL and writes it to the specified output, or to
+// Options.Sink or Options.SinkAt without touching the filesystem
+func Download(ctx context.Context, tracker *cleanup.Tracker, opts Options) (result *Result, err error) {
 	// Check for cancellation before starting
 	if ctx.Err() != nil {
-		return nil, ctx.Err()
+		return nil, context.Cause(ctx)
 	}
+	if opts.SinkAt != nil {
+		if opts.Sink != nil {
+			return nil, fmt.Errorf("only one of Sink and SinkAt can be set")
+		}
+		opts.Sink = io.NewOffsetWriter(opts.SinkAt, 0)
+	}
+
+	// The deadline and phase budgets cancel this context with a TimeoutError as the cause
+	budget := newPhaseBudget(ctx, opts.Deadline)
+	ctx = budget.ctx
+	defer func() {
+		err = budget.explain(err)
+		budget.stop()
+	}()
 
 	logger := logging.FromContext(ctx)
 
-	tlsConfig := &tls.Config{
-		MinVersion: tls.VersionTLS12, // Secure default
-	}
-	if opts.AllowInsecureTLS {
-		tlsConfig.MinVersion = tls.VersionTLS10
+	client, err := newClient(opts, logger)
+	if err != nil {
+		return nil, err
 	}
 
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout: opts.ConnectTimeout,
-		}).DialContext,
-		TLSClientConfig: tlsConfig,
+	req, err := http.NewRequestWithContext(ctx, "GET", opts.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
 	}
-
-	client := &http.Client{
-		Transport: transport,
+	if err := opts.HostPolicy.Check(req.URL); err != nil {
+		return nil, &NetworkError{Err: err}
 	}
 
-	if opts.MaxTime > 0 {
-		client.Timeout = opts.MaxTime
+	var tracer *verboseTracer
+	if opts.Verbose > 0 {
+		w := opts.VerboseWriter
+		if w == nil {
+			w = logging.Stderr
+		}
+		tracer = newVerboseTracer(w, opts.Verbose, req)
+		req = req.WithContext(httptrace.WithClientTrace(ctx, tracer.clientTrace()))
+		checkRedirect := client.CheckRedirect
+		client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+			tracer.redirect(r, via)
+			return checkRedirect(r, via)
+		}
 	}
 
-	// Configure redirect handling
-	if opts.MaxRedirects >= 0 {
-		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-			if len(via) > opts.MaxRedirects {
-				return fmt.Errorf("stopped after %d redirects", opts.MaxRedirects)
+	if opts.DumpHeaderWriter != nil && opts.DumpRedirectHeaders {
+		checkRedirect := client.CheckRedirect
+		client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+			if r.Response != nil {
+				if err := dumpHeaders(opts.DumpHeaderWriter, r.Response); err != nil {
+					return err
+				}
 			}
-			return nil
+			return checkRedirect(r, via)
 		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", opts.URL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
+	var wire *wireTracer
+	if opts.TraceWriter != nil {
+		wire = newWireTracer(opts.TraceWriter)
+		wire.request(req)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), wire.clientTrace()))
+		checkRedirect := client.CheckRedirect
+		client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+			wire.redirect(r, via)
+			return checkRedirect(r, via)
+		}
+		defer func() {
+			var n int64
+			if result != nil {
+				n = result.BytesDownloaded
+			}
+			wire.done(n, err)
+		}()
 	}
 
 	if opts.UserAgent != "" {
@@ -108,15 +234,100 @@ func Download(ctx context.Context, tracker *cleanup.Tracker, opts Options) (*Res
 	for key, value := range opts.Headers {
 		req.Header.Set(key, value)
 	}
+	if err := applyAuthHelper(ctx, req, opts.AuthHelper, logger); err != nil {
+		return nil, err
+	}
+	applyNetrc(req, opts.Netrc, logger)
 
+	// Resume the part a previous partial download left, if any
+	var partial *partialDownload
+	if opts.Partial && opts.Sink == nil && opts.Output != "-" {
+		partial = loadPartial(opts.Output, opts.URL, opts.ExpectedHash != "", logger)
+		partial.request(req)
+	}
+
+	start := time.Now()
+	budget.enter(PhaseConnect, opts.ConnectBudget)
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching URL: %w", err)
+		return nil, &NetworkError{Err: fmt.Errorf("error fetching URL: %w", explainCertificateTimeError(err, time.Now(), logger))}
 	}
+	budget.enter(PhaseTransfer, opts.TransferBudget)
 	defer resp.Body.Close()
+	timeResponse := time.Since(start)
+	checkClockSkew(resp, time.Now(), logger)
+
+	defer func() {
+		if result == nil {
+			return
+		}
+		result.URL = resp.Request.URL.String()
+		result.HTTPCode = resp.StatusCode
+		result.ContentType = resp.Header.Get("Content-Type")
+		result.ETag = resp.Header.Get("ETag")
+		result.ContentLength = resp.ContentLength
+		result.RedirectCount = redirectCount(resp)
+		result.Redirects = redirectChain(resp)
+		result.Header = resp.Header
+		result.StartTime = start
+		result.TimeResponse = timeResponse
+		result.TimeTotal = time.Since(start)
+		result.HTTPVersion = resp.Proto
+		if resp.TLS != nil {
+			result.TLSVersion = tls.VersionName(resp.TLS.Version)
+		}
+		if body := result.TimeTotal - timeResponse; body > 0 {
+			result.SpeedAverage = int64(float64(result.BytesDownloaded) / body.Seconds())
+		}
+		if result.SpeedPeak < result.SpeedAverage {
+			// Transfers shorter than one window never complete a sample
+			result.SpeedPeak = result.SpeedAverage
+		}
+		if err == nil {
+			logger.Info("transfer_summary",
+				"elapsed", result.TimeTotal.Round(time.Millisecond).String(),
+				"time_to_response", timeResponse.Round(time.Millisecond).String(),
+				"average_speed", util.HumanReadableBytes(result.SpeedAverage)+"/s",
+				"average_speed_bytes_per_sec", result.SpeedAverage,
+				"peak_speed", util.HumanReadableBytes(result.SpeedPeak)+"/s",
+				"peak_speed_bytes_per_sec", result.SpeedPeak,
+				"redirects", result.RedirectCount,
+				"http_version", result.HTTPVersion,
+				"tls_version", result.TLSVersion,
+			)
+		}
+	}()
+
+	if tracer != nil {
+		tracer.response(resp)
+	}
+	if wire != nil {
+		wire.response(resp)
+	}
+	if opts.DumpHeaderWriter != nil {
+		if err := dumpHeaders(opts.DumpHeaderWriter, resp); err != nil {
+			return nil, err
+		}
+	}
+
+	if partial != nil {
+		if err := partial.accept(resp, logger); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK && (partial == nil || partial.offset == 0) {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	for _, assertion := range opts.HeaderAssertions {
+		if err := assertion.Check(resp.Header); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MaxAge > 0 {
+		if err := checkFreshness(logger, resp.Header, opts.MaxAge, opts.MaxAgeWarnOnly); err != nil {
+			return nil, err
+		}
 	}
 
 	// Extract filename from Content-Disposition header if output was not explicitly set
@@ -126,19 +337,72 @@ func Download(ctx context.Context, tracker *cleanup.Tracker, opts Options) (*Res
 		if contentDisposition != "" {
 			cdFilename := extractFilenameFromContentDisposition(contentDisposition)
 			if cdFilename != "" {
-				finalOutput = cdFilename
+				finalOutput = filepath.Join(opts.OutputDir, cdFilename)
 			}
 		}
 	}
 
+	// Resolve {header:Name} placeholders in an explicit output name
+	if opts.OutputExplicit && strings.Contains(finalOutput, headerPlaceholder) {
+		finalOutput, err = expandHeaderPlaceholders(finalOutput, resp.Header)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("output_name_resolved", "output", finalOutput)
+	}
+
+	// Verify against a digest the server advertises, e.g. GCS x-goog-hash
+	hashAlgorithm, expectedHash := opts.HashAlgorithm, opts.ExpectedHash
+	if expectedHash == "" && opts.HashFromHeaders {
+		switch algo, digest, source := hashFromHeaders(resp.Header, opts.FIPS); {
+		case digest == "":
+			logger.Warn("header_hash_missing", "hint", "the response advertises no usable digest; the download is not verified")
+		case resp.Uncompressed:
+			// The advertised digest covers the encoded body, not the decompressed one
+			logger.Warn("header_hash_skipped", "header", source, "reason", "response was transparently decompressed")
+		default:
+			logger.Info("header_hash_found", "algorithm", algo, "header", source)
+			hashAlgorithm, expectedHash = algo, digest
+		}
+	}
+
 	// Enforce maximum download size by limiting the reader.
 	var bodyReader io.Reader = resp.Body
+	total := resp.ContentLength
+	var partFile *os.File
+	if partial != nil {
+		var prefix *os.File
+		if partFile, prefix, err = partial.open(); err != nil {
+			return nil, fmt.Errorf("error opening partial file: %w", err)
+		}
+		defer partFile.Close()
+		if prefix != nil {
+			defer prefix.Close()
+			// The kept bytes are read back, so the hash and limits cover the whole file
+			bodyReader = io.MultiReader(io.LimitReader(prefix, partial.offset), resp.Body)
+			if total >= 0 {
+				total += partial.offset
+			}
+		}
+		partial.begin(resp, logger)
+	}
+	if opts.InferExtension && !opts.OutputExplicit && opts.Sink == nil && finalOutput != "-" && filepath.Ext(finalOutput) == "" {
+		// Sniff the body without consuming it; a short body just yields fewer bytes
+		br := bufio.NewReaderSize(resp.Body, inferPeekSize)
+		head, _ := br.Peek(inferPeekSize)
+		if ext, source := inferExtension(resp.Header.Get("Content-Type"), head); ext != "" {
+			logger.Info("extension_inferred", "output", finalOutput+ext, "extension", ext, "source", source)
+			finalOutput += ext
+		}
+		bodyReader = br
+	}
 	if opts.MaxBytes > 0 {
-		bodyReader = io.LimitReader(resp.Body, opts.MaxBytes+1)
+		bodyReader = io.LimitReader(bodyReader, opts.MaxBytes+1)
 	}
 
-	// Special handling: stdout + hash requires buffering to verify before output
-	if finalOutput == "-" && opts.ExpectedHash != "" {
+	// Special handling: stdout + hash requires buffering to verify before output,
+	// unless the caller discards the output itself when verification fails
+	if finalOutput == "-" && expectedHash != "" && !opts.StreamUnverified {
 		tempFile, err := os.CreateTemp("", "ripvex-*")
 		if err != nil {
 			return nil, fmt.Errorf("error creating temp file: %w", err)
@@ -154,40 +418,80 @@ func Download(ctx context.Context, tracker *cleanup.Tracker, opts Options) (*Res
 			}
 		}()
 
-		result, err := downloadWithProgress(ctx, tempFile, bodyReader, resp.ContentLength, finalOutput, opts.Quiet, opts.HashAlgorithm, opts.ExpectedHash, opts.MaxBytes, opts.ProgressInterval, logger, opts.LogFormat, opts.LogProgressStep, opts.LogProgressStepUnknown)
+		result, err := downloadWithProgress(ctx, tempFile, bodyReader, total, finalOutput, hashAlgorithm, expectedHash, opts.DigestAlgorithms, opts.MaxBytes, newProgressBar(opts, total, logger), logger)
 		if err := tempFile.Close(); err != nil {
 			return nil, fmt.Errorf("error closing temp file: %w", err)
 		}
 		if err != nil {
-			result.OutputFile = finalOutput
+			if result != nil {
+				result.OutputFile = finalOutput
+			}
 			return result, err
 		}
 
 		// Hash verification passed, stream temp file to stdout
+		budget.enter(PhaseVerify, opts.VerifyBudget)
 		tempFile, err = os.Open(tempPath)
 		if err != nil {
 			return nil, fmt.Errorf("error reopening temp file: %w", err)
 		}
 		defer tempFile.Close()
 
-		if _, err := io.Copy(os.Stdout, tempFile); err != nil {
+		if _, err := io.Copy(os.Stdout, &contextReader{ctx: ctx, r: tempFile}); err != nil {
 			return nil, fmt.Errorf("error writing to stdout: %w", err)
 		}
 		result.OutputFile = finalOutput
 		return result, nil
 	}
 
-	// Standard flow: file output or stdout without hash (stream directly)
+	// Embedder-provided sink: stream directly, nothing is created or removed on disk
+	if opts.Sink != nil {
+		result, err := downloadWithProgress(ctx, opts.Sink, bodyReader, total, finalOutput, hashAlgorithm, expectedHash, opts.DigestAlgorithms, opts.MaxBytes, newProgressBar(opts, total, logger), logger)
+		if result != nil {
+			result.OutputFile = finalOutput
+		}
+		return result, err
+	}
+
+	// Standard flow: file output, or stdout without hash or with StreamUnverified (stream directly)
 	var writer io.Writer
 	if finalOutput == "-" {
 		writer = os.Stdout
-		result, err := downloadWithProgress(ctx, writer, bodyReader, resp.ContentLength, finalOutput, opts.Quiet, opts.HashAlgorithm, opts.ExpectedHash, opts.MaxBytes, opts.ProgressInterval, logger, opts.LogFormat, opts.LogProgressStep, opts.LogProgressStepUnknown)
+		result, err := downloadWithProgress(ctx, writer, bodyReader, total, finalOutput, hashAlgorithm, expectedHash, opts.DigestAlgorithms, opts.MaxBytes, newProgressBar(opts, total, logger), logger)
 		if result != nil {
 			result.OutputFile = finalOutput
 		}
 		return result, err
 	}
 
+	if partial != nil {
+		if err := preallocate(partFile, partial.offset, total, opts, logger); err != nil {
+			partial.keep(resp, err, logger)
+			return nil, err
+		}
+		result, err = downloadWithProgress(ctx, partial.writer(outputWriter(partFile, opts)), bodyReader, total, finalOutput, hashAlgorithm, expectedHash, opts.DigestAlgorithms, opts.MaxBytes, newProgressBar(opts, total, logger), logger)
+		if result != nil {
+			result.OutputFile = finalOutput
+		}
+		if err == nil {
+			err = syncOutput(partFile, opts)
+		}
+		if closeErr := partFile.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("error closing output file: %w", closeErr)
+		}
+		if err == nil {
+			err = partial.complete(finalOutput)
+		}
+		if err != nil {
+			partial.keep(resp, err, logger)
+			return result, err
+		}
+		if tracker != nil {
+			tracker.Register(finalOutput)
+		}
+		return result, syncOutputDir(finalOutput, opts, logger)
+	}
+
 	file, err := os.Create(finalOutput)
 	if err != nil {
 		return nil, fmt.Errorf("error creating file: %w", err)
@@ -196,16 +500,125 @@ func Download(ctx context.Context, tracker *cleanup.Tracker, opts Options) (*Res
 	if tracker != nil {
 		tracker.Register(finalOutput)
 	}
-	result, err := downloadWithProgress(ctx, file, bodyReader, resp.ContentLength, finalOutput, opts.Quiet, opts.HashAlgorithm, opts.ExpectedHash, opts.MaxBytes, opts.ProgressInterval, logger, opts.LogFormat, opts.LogProgressStep, opts.LogProgressStepUnknown)
+	if err := preallocate(file, 0, total, opts, logger); err != nil {
+		file.Close()
+		if os.Remove(finalOutput) == nil && tracker != nil {
+			tracker.Unregister(finalOutput)
+		}
+		return nil, err
+	}
+	result, err = downloadWithProgress(ctx, outputWriter(file, opts), bodyReader, total, finalOutput, hashAlgorithm, expectedHash, opts.DigestAlgorithms, opts.MaxBytes, newProgressBar(opts, total, logger), logger)
 	if result != nil {
 		result.OutputFile = finalOutput
 	}
+	if err == nil {
+		err = syncOutput(file, opts)
+	}
 	if closeErr := file.Close(); closeErr != nil && err == nil {
-		return result, fmt.Errorf("error closing output file: %w", closeErr)
+		err = fmt.Errorf("error closing output file: %w", closeErr)
+	}
+	if err == nil {
+		err = syncOutputDir(finalOutput, opts, logger)
+	}
+	if err != nil {
+		// Never leave a truncated, oversized or corrupted file behind
+		if rmErr := os.Remove(finalOutput); rmErr != nil && !os.IsNotExist(rmErr) {
+			logger.Warn("remove_failed_download_failed", "file", finalOutput, "error", rmErr)
+		} else if tracker != nil {
+			tracker.Unregister(finalOutput)
+		}
 	}
 	return result, err
 }
 
+// newClient builds the HTTP client for opts: TLS policy, timeouts and redirect handling
+func newClient(opts Options, logger *slog.Logger) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12, // Secure default
+	}
+	if opts.AllowInsecureTLS {
+		tlsConfig.MinVersion = tls.VersionTLS10
+	}
+	if opts.FIPS {
+		fips.ApplyTLS(tlsConfig)
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout: opts.ConnectTimeout,
+		}).DialContext,
+		TLSClientConfig: tlsConfig,
+	}
+
+	client := &http.Client{
+		Transport: transport,
+	}
+
+	if opts.MaxTime > 0 {
+		client.Timeout = opts.MaxTime
+	}
+
+	// Configure redirect handling
+	if opts.RedirectPolicy != "" {
+		if err := ValidateRedirectPolicy(opts.RedirectPolicy); err != nil {
+			return nil, err
+		}
+	}
+	client.CheckRedirect = newCheckRedirect(opts.MaxRedirects, opts.RedirectPolicy, logger)
+	if opts.HostPolicy != nil {
+		checkRedirect := client.CheckRedirect
+		client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+			if err := opts.HostPolicy.Check(r.URL); err != nil {
+				return fmt.Errorf("redirect to %s refused: %w", r.URL.Host, err)
+			}
+			return checkRedirect(r, via)
+		}
+	}
+	return client, nil
+}
+
+// NewClient returns a client with the TLS, proxy, timeout and redirect
+// settings of opts, for requests other than downloads, such as fetching a
+// token
+func NewClient(ctx context.Context, opts Options) (*http.Client, error) {
+	return newClient(opts, logging.FromContext(ctx))
+}
+
+// redirectCount returns how many redirects led to resp
+func redirectCount(resp *http.Response) int {
+	n := 0
+	for r := resp.Request; r != nil && r.Response != nil; r = r.Response.Request {
+		n++
+	}
+	return n
+}
+
+// redirectChain returns the URLs of the requests that redirected to resp,
+// from the first one on
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for r := resp.Request; r != nil && r.Response != nil; r = r.Response.Request {
+		chain = append(chain, r.Response.Request.URL.String())
+	}
+	slices.Reverse(chain)
+	return chain
+}
+
+// dumpHeaders writes the status line and headers of resp in HTTP wire format
+func dumpHeaders(w io.Writer, resp *http.Response) error {
+	if _, err := fmt.Fprintf(w, "%s %s\r\n", resp.Proto, resp.Status); err != nil {
+		return fmt.Errorf("error writing response headers: %w", err)
+	}
+	if err := resp.Header.Write(w); err != nil {
+		return fmt.Errorf("error writing response headers: %w", err)
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return fmt.Errorf("error writing response headers: %w", err)
+	}
+	return nil
+}
+
 // extractFilenameFromContentDisposition extracts the filename from Content-Disposition header
 // Returns empty string if header is missing or invalid
 func extractFilenameFromContentDisposition(header string) string {
@@ -261,25 +674,70 @@ func newHashFromAlgorithm(algo string) (hash.Hash, string, error) {
 		return sha256.New(), "SHA-256", nil
 	case "sha512":
 		return sha512.New(), "SHA-512", nil
+	case "blake3":
+		return blake3.New(32, nil), "BLAKE3", nil
+	case "sha1":
+		return sha1.New(), "SHA-1", nil
+	case "md5":
+		return md5.New(), "MD5", nil
+	case "crc32":
+		return crc32.NewIEEE(), "CRC-32", nil
+	case "crc32c":
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), "CRC-32C", nil
 	default:
 		return nil, "", fmt.Errorf("unsupported hash algorithm: %s", algo)
 	}
 }
 
-// downloadWithProgress reads from reader in chunks and writes to writer, showing real-time progress
-// throttled to update every progressInterval, with optional hash verification
-func downloadWithProgress(ctx context.Context, writer io.Writer, reader io.Reader, total int64, outName string, quiet bool, hashAlgorithm string, expectedHash string, maxBytes int64, progressInterval time.Duration, logger *slog.Logger, logFormat string, logProgressStep int, logProgressStepUnknown int64) (*Result, error) {
-	updateInterval := progressInterval
-	if updateInterval <= 0 {
-		updateInterval = 500 * time.Millisecond
+// peakWindow is the sampling window for Result.SpeedPeak
+const peakWindow = time.Second
+
+// ProgressReporter receives the progress of a download. progress.Bar, which
+// logs or draws it, is the default; Options.Progress supplies another, such
+// as a progress.Callback delivering events to an embedder's UI.
+type ProgressReporter interface {
+	Start()         // Before the first byte
+	Update(n int64) // After each n bytes of the body are written
+	Stop()          // Once the body ended or failed; may be called more than once
+}
+
+// ProgressFactory builds the reporter for a body of total bytes, -1 if unknown
+type ProgressFactory func(total int64) ProgressReporter
+
+// newProgressBar builds the download progress reporter selected by opts
+func newProgressBar(opts Options, total int64, logger *slog.Logger) ProgressReporter {
+	if opts.Progress != nil {
+		return opts.Progress(total)
+	}
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+	// A dedicated progress logger (e.g. --progress=json) is explicitly requested output, so quiet does not mute it
+	if opts.ProgressLogger != nil {
+		return progress.New(total, opts.LogProgressStep, opts.LogProgressStepUnknown, interval, opts.ProgressLogger, false)
 	}
-	bar := progress.New(total, logProgressStep, logProgressStepUnknown, updateInterval, logger, quiet)
+	bar := progress.New(total, opts.LogProgressStep, opts.LogProgressStepUnknown, interval, logger, opts.Quiet)
+	bar.Terminal = opts.ProgressTerminal
+	return bar
+}
+
+// downloadWithProgress reads from reader in chunks and writes to writer, reporting progress
+// through bar, with optional hash verification and digests. It never touches the filesystem:
+// discarding a failed download's output is up to the caller.
+func downloadWithProgress(ctx context.Context, writer io.Writer, reader io.Reader, total int64, outName string, hashAlgorithm string, expectedHash string, digestAlgorithms []string, maxBytes int64, bar ProgressReporter, logger *slog.Logger) (*Result, error) {
 	bar.Start()
 	defer bar.Stop()
 
 	var downloaded int64
 	buf := make([]byte, 4096)
 
+	// Peak throughput is sampled over fixed windows, independent of progress output
+	var peakSpeed, windowBytes int64
+	windowStart := time.Now()
+
+	// One hasher per algorithm, shared by verification and the requested digests
+	hashers := make(map[string]hash.Hash)
 	var hasher hash.Hash
 	var hashName string
 	var err error
@@ -288,14 +746,39 @@ func downloadWithProgress(ctx context.Context, writer io.Writer, reader io.Reade
 		if err != nil {
 			return nil, err
 		}
+		hashers[strings.ToLower(hashAlgorithm)] = hasher
+	}
+	for _, algo := range digestAlgorithms {
+		algo = strings.ToLower(algo)
+		if _, ok := hashers[algo]; ok {
+			continue
+		}
+		h, _, err := newHashFromAlgorithm(algo)
+		if err != nil {
+			return nil, err
+		}
+		hashers[algo] = h
+	}
+
+	var hashSink io.Writer
+	if len(hashers) > 0 {
+		sinks := make([]io.Writer, 0, len(hashers))
+		for _, h := range hashers {
+			sinks = append(sinks, h)
+		}
+		hashSink = io.MultiWriter(sinks...)
 	}
 
+	// Debug-level io_stats show whether network, disk or hashing limits the transfer
+	stats := newTransferStats(ctx, logger)
+	reader, writer, hashSink = stats.wrap(reader, writer, hashSink)
+
 	// Check cancellation periodically (every 10 iterations to avoid overhead)
 	iterCount := 0
 	for {
 		// Check for cancellation every 10 iterations
 		if iterCount%10 == 0 && ctx.Err() != nil {
-			return nil, ctx.Err()
+			return nil, context.Cause(ctx)
 		}
 		iterCount++
 
@@ -304,8 +787,8 @@ func downloadWithProgress(ctx context.Context, writer io.Writer, reader io.Reade
 		// Process bytes FIRST (even if err == io.EOF)
 		// Per io.Reader contract, Read() may return n > 0 AND io.EOF simultaneously
 		if n > 0 {
-			if hasher != nil {
-				hasher.Write(buf[:n])
+			if hashSink != nil {
+				hashSink.Write(buf[:n])
 			}
 			n2, writeErr := writer.Write(buf[:n])
 			if writeErr != nil {
@@ -316,14 +799,19 @@ func downloadWithProgress(ctx context.Context, writer io.Writer, reader io.Reade
 			}
 			downloaded += int64(n)
 			if maxBytes > 0 && downloaded > maxBytes {
-				if outName != "-" {
-					if err := os.Remove(outName); err != nil && !os.IsNotExist(err) && !quiet {
-						logger.Warn("remove_oversized_failed", "file", outName, "error", err)
-					}
-				}
-				return nil, fmt.Errorf("download exceeded maximum size limit of %s", util.HumanReadableBytes(maxBytes))
+				return nil, fmt.Errorf("%w of %s", ErrMaxBytes, util.HumanReadableBytes(maxBytes))
 			}
 			bar.Update(int64(n))
+
+			windowBytes += int64(n)
+			if elapsed := time.Since(windowStart); elapsed >= peakWindow {
+				if speed := int64(float64(windowBytes) / elapsed.Seconds()); speed > peakSpeed {
+					peakSpeed = speed
+				}
+				windowBytes = 0
+				windowStart = time.Now()
+			}
+			stats.tick()
 		}
 
 		// THEN check for errors
@@ -331,24 +819,22 @@ func downloadWithProgress(ctx context.Context, writer io.Writer, reader io.Reade
 			if err == io.EOF {
 				break
 			}
-			return nil, fmt.Errorf("error reading: %w", err)
+			return nil, &NetworkError{Err: fmt.Errorf("error reading: %w", err)}
 		}
 	}
+	// Finish progress output before the completion logs below
+	bar.Stop()
+	stats.finish()
 
 	// Content-Length validation (skip if hash verification is enabled, as it provides stronger integrity)
 	if total > 0 && downloaded != total && expectedHash == "" {
-		// Delete incomplete file if writing to a file (not stdout)
-		if outName != "-" {
-			if err := os.Remove(outName); err != nil && !os.IsNotExist(err) {
-				logger.Warn("remove_incomplete_failed", "file", outName, "error", err)
-			}
-		}
-		return nil, fmt.Errorf("incomplete download: received %s, expected %s (Content-Length)", util.HumanReadableBytes(downloaded), util.HumanReadableBytes(total))
+		return nil, &NetworkError{Err: fmt.Errorf("incomplete download: received %s, expected %s (Content-Length)", util.HumanReadableBytes(downloaded), util.HumanReadableBytes(total))}
 	}
 
 	result := &Result{
 		BytesDownloaded: downloaded,
 		HashMatched:     true,
+		SpeedPeak:       peakSpeed,
 	}
 
 	// Hash verification
@@ -357,16 +843,19 @@ func downloadWithProgress(ctx context.Context, writer io.Writer, reader io.Reade
 		computed := hex.EncodeToString(sum)
 		if computed != expectedHash {
 			result.HashMatched = false
-			// Delete corrupted file if writing to a file (not stdout)
-			if outName != "-" {
-				if err := os.Remove(outName); err != nil && !os.IsNotExist(err) {
-					logger.Warn("remove_corrupted_failed", "file", outName, "error", err)
-				}
-			}
 			logger.Error("hash_mismatch", "algorithm", hashName, "expected", expectedHash, "computed", computed)
-			return result, fmt.Errorf("hash mismatch: expected %s, got %s", expectedHash, computed)
+			return result, fmt.Errorf("%w: expected %s, got %s", ErrHashMismatch, expectedHash, computed)
 		}
 		logger.Info("hash_verified", "algorithm", hashName)
+		result.HashAlgorithm, result.Hash = hashAlgorithm, computed
+	}
+
+	if len(digestAlgorithms) > 0 {
+		result.Digests = make(map[string]string, len(digestAlgorithms))
+		for _, algo := range digestAlgorithms {
+			algo = strings.ToLower(algo)
+			result.Digests[algo] = hex.EncodeToString(hashers[algo].Sum(nil))
+		}
 	}
 
 	logger.Info("download_complete",
@@ -0,0 +1,56 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// StaleError is returned when Last-Modified shows the resource is older than
+// Options.MaxAge
+type StaleError struct {
+	LastModified time.Time
+	Age          time.Duration
+	MaxAge       time.Duration
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("resource is stale: last modified %s (%s ago), more than %s", e.LastModified.UTC().Format(time.RFC3339), util.FormatDuration(e.Age), util.FormatDuration(e.MaxAge))
+}
+
+// resourceAge returns the age the response headers give the resource. The
+// server's Date is the reference when present, so clock skew between the
+// server and this machine does not count.
+func resourceAge(h http.Header, now time.Time) (lastModified time.Time, age time.Duration, ok bool) {
+	lastModified, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		now = date
+	}
+	return lastModified, max(now.Sub(lastModified), 0), true
+}
+
+// checkFreshness enforces maxAge on a response. A resource without a usable
+// Last-Modified cannot be judged, so it only logs a warning.
+func checkFreshness(logger *slog.Logger, h http.Header, maxAge time.Duration, warnOnly bool) error {
+	lastModified, age, ok := resourceAge(h, time.Now())
+	if !ok {
+		logger.Warn("resource_age_unknown", "hint", "the response has no valid Last-Modified header, so its age cannot be checked")
+		return nil
+	}
+	if age <= maxAge {
+		logger.Debug("resource_age", "last_modified", lastModified.UTC().Format(time.RFC3339), "age", util.FormatDuration(age))
+		return nil
+	}
+	stale := &StaleError{LastModified: lastModified, Age: age, MaxAge: maxAge}
+	if !warnOnly {
+		return stale
+	}
+	logger.Warn("resource_stale", "last_modified", lastModified.UTC().Format(time.RFC3339), "age", util.FormatDuration(age), "max_age", util.FormatDuration(maxAge))
+	return nil
+}
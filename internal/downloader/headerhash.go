@@ -0,0 +1,76 @@
+package downloader
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// headerHashPreference orders the algorithms a server may advertise, strongest first
+var headerHashPreference = []string{"sha256", "sha1", "md5", "crc32c", "crc32"}
+
+// headerDigestSize is the raw digest size of each advertised algorithm
+var headerDigestSize = map[string]int{"sha256": 32, "sha1": 20, "md5": 16, "crc32c": 4, "crc32": 4}
+
+// headerHashFIPS lists the advertised algorithms usable in FIPS mode
+var headerHashFIPS = map[string]bool{"sha256": true}
+
+// hashFromHeaders picks the strongest digest of the body advertised in the
+// response headers: GCS x-goog-hash, S3 x-amz-checksum-* or Content-MD5.
+// It returns the algorithm, the hex digest and the header it came from, or
+// empty strings when none is usable.
+func hashFromHeaders(h http.Header, fips bool) (algo, digest, source string) {
+	found := make(map[string][2]string) // algorithm -> {hex digest, header}
+
+	// x-goog-hash: crc32c=<base64>, md5=<base64> (one or more headers)
+	for _, value := range h.Values("X-Goog-Hash") {
+		for _, part := range strings.Split(value, ",") {
+			name, encoded, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok {
+				continue
+			}
+			name = strings.ToLower(name)
+			if d := decodeHeaderDigest(name, encoded); d != "" {
+				found[name] = [2]string{d, "X-Goog-Hash"}
+			}
+		}
+	}
+
+	// x-amz-checksum-<algo>: <base64>. Multipart composites ("<base64>-<parts>") do not cover the whole body.
+	for _, a := range []string{"sha256", "sha1", "crc32c", "crc32"} {
+		header := "X-Amz-Checksum-" + strings.ToUpper(a[:1]) + a[1:]
+		value := h.Get(header)
+		if value == "" || strings.Contains(value, "-") {
+			continue
+		}
+		if d := decodeHeaderDigest(a, value); d != "" {
+			found[a] = [2]string{d, header}
+		}
+	}
+
+	// Content-MD5 (RFC 1864)
+	if d := decodeHeaderDigest("md5", h.Get("Content-MD5")); d != "" {
+		found["md5"] = [2]string{d, "Content-MD5"}
+	}
+
+	for _, a := range headerHashPreference {
+		if fips && !headerHashFIPS[a] {
+			continue
+		}
+		if f, ok := found[a]; ok {
+			return a, f[0], f[1]
+		}
+	}
+	return "", "", ""
+}
+
+// decodeHeaderDigest converts a base64 digest from a header to hex. Unknown
+// algorithms and digests of the wrong size yield "".
+func decodeHeaderDigest(algo, value string) string {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(raw) != headerDigestSize[algo] {
+		return ""
+	}
+	return hex.EncodeToString(raw)
+}
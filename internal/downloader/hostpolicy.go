@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// ErrHostNotAllowed is wrapped by the errors of requests a HostPolicy refuses
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+// HostPolicy restricts the hosts requests may go to. It is checked on the
+// requested URL and on every redirect hop. Patterns are globs matched
+// against the host name without port, ignoring case, where '*' also matches
+// dots: "*.example.com" matches "a.b.example.com" but not "example.com".
+type HostPolicy struct {
+	Allow []string // When not empty, hosts must match one of these
+	Deny  []string // Hosts must match none of these, even if allowed
+}
+
+// ValidateHostPattern checks that pattern can match a host name
+func ValidateHostPattern(pattern string) error {
+	if pattern == "" || strings.ContainsAny(pattern, "/:@ ") {
+		return fmt.Errorf("invalid host pattern %q: must be a host name glob such as *.example.com", pattern)
+	}
+	return nil
+}
+
+// Check returns an error wrapping ErrHostNotAllowed if u is on a host the
+// policy refuses. A nil policy allows every host.
+func (p *HostPolicy) Check(u *url.URL) error {
+	if p == nil {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, pattern := range p.Deny {
+		if util.MatchGlob(strings.ToLower(pattern), host) {
+			return fmt.Errorf("%w: %s matches denied host pattern %q", ErrHostNotAllowed, host, pattern)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range p.Allow {
+		if util.MatchGlob(strings.ToLower(pattern), host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s matches no allowed host pattern", ErrHostNotAllowed, host)
+}
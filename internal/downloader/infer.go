@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/archive"
+)
+
+// inferPeekSize is how much of the body is buffered to sniff its format.
+// Compressed tarballs need enough input to decompress a tar header.
+const inferPeekSize = 64 * 1024
+
+// contentTypeExtensions maps Content-Types to the extension used when the
+// body's magic bytes are not recognized. Kept explicit because
+// mime.ExtensionsByType depends on the host's mime tables.
+var contentTypeExtensions = map[string]string{
+	"application/zip":              ".zip",
+	"application/x-zip-compressed": ".zip",
+	"application/x-tar":            ".tar",
+	"application/gzip":             ".gz",
+	"application/x-gzip":           ".gz",
+	"application/x-gtar":           ".tar.gz",
+	"application/x-bzip2":          ".bz2",
+	"application/x-xz":             ".xz",
+	"application/zstd":             ".zst",
+	"application/x-lz4":            ".lz4",
+	"application/x-iso9660-image":  ".iso",
+	"application/json":             ".json",
+	"application/pdf":              ".pdf",
+	"application/xml":              ".xml",
+	"application/x-sh":             ".sh",
+	"text/plain":                   ".txt",
+	"text/html":                    ".html",
+	"text/csv":                     ".csv",
+	"image/png":                    ".png",
+	"image/jpeg":                   ".jpg",
+}
+
+// archiveExtensions maps detected archive types to their extension, as the
+// compressed-only form and the form used when they wrap a tar archive
+var archiveExtensions = map[archive.Type][2]string{
+	archive.Gzip:   {".gz", ".tar.gz"},
+	archive.Bzip2:  {".bz2", ".tar.bz2"},
+	archive.Xz:     {".xz", ".tar.xz"},
+	archive.Zstd:   {".zst", ".tar.zst"},
+	archive.Lz4:    {".lz4", ".tar.lz4"},
+	archive.Brotli: {".br", ".tar.br"},
+}
+
+// inferExtension picks an extension for an extension-less output from the
+// body's leading bytes, falling back to the Content-Type. It returns the
+// extension and what it was inferred from, or "" when nothing matched.
+func inferExtension(contentType string, head []byte) (ext, source string) {
+	switch t := archive.DetectBytes(head); t {
+	case archive.Zip:
+		return ".zip", "magic"
+	case archive.Tar:
+		return ".tar", "magic"
+	case archive.ISO9660:
+		return ".iso", "magic"
+	case archive.Unknown:
+	default:
+		exts := archiveExtensions[t]
+		if archive.CompressedTar(t, head) {
+			return exts[1], "magic"
+		}
+		return exts[0], "magic"
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", ""
+	}
+	if ext, ok := contentTypeExtensions[strings.ToLower(mediaType)]; ok {
+		return ext, "content_type"
+	}
+	return "", ""
+}
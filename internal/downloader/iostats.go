@@ -0,0 +1,132 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// ioStatsInterval is how often io_stats records are logged during a transfer
+const ioStatsInterval = 2 * time.Second
+
+// transferStats times the network reads, output writes and hashing of a
+// download, and logs them at debug level to show which one limits it
+type transferStats struct {
+	logger    *slog.Logger
+	start     time.Time
+	lastLog   time.Time
+	reads     int64
+	bytesRead int64
+	readWait  time.Duration
+	written   int64
+	writeTime time.Duration
+	hashed    int64
+	hashTime  time.Duration
+}
+
+// newTransferStats returns nil unless debug logging is enabled, so the
+// timing costs nothing otherwise
+func newTransferStats(ctx context.Context, logger *slog.Logger) *transferStats {
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return nil
+	}
+	now := time.Now()
+	return &transferStats{logger: logger, start: now, lastLog: now}
+}
+
+// wrap times reads from r, writes to w and hashing into h. h may be nil.
+func (s *transferStats) wrap(r io.Reader, w io.Writer, h io.Writer) (io.Reader, io.Writer, io.Writer) {
+	if s == nil {
+		return r, w, h
+	}
+	r = &timedReader{r: r, reads: &s.reads, n: &s.bytesRead, d: &s.readWait}
+	w = &timedWriter{w: w, n: &s.written, d: &s.writeTime}
+	if h != nil {
+		h = &timedWriter{w: h, n: &s.hashed, d: &s.hashTime}
+	}
+	return r, w, h
+}
+
+// tick logs a record once per ioStatsInterval
+func (s *transferStats) tick() {
+	if s == nil || time.Since(s.lastLog) < ioStatsInterval {
+		return
+	}
+	s.lastLog = time.Now()
+	s.log("io_stats")
+}
+
+// finish logs the totals for the whole transfer
+func (s *transferStats) finish() {
+	if s != nil {
+		s.log("io_stats_total")
+	}
+}
+
+func (s *transferStats) log(msg string) {
+	bottleneck := "network"
+	if s.writeTime > s.readWait && s.writeTime >= s.hashTime {
+		bottleneck = "disk"
+	} else if s.hashTime > s.readWait && s.hashTime > s.writeTime {
+		bottleneck = "cpu"
+	}
+	attrs := []any{
+		"elapsed", time.Since(s.start).Round(time.Millisecond).String(),
+		"reads", s.reads,
+		"bytes_read", s.bytesRead,
+		"read_wait", s.readWait.Round(time.Millisecond).String(),
+		"bytes_written", s.written,
+		"write_time", s.writeTime.Round(time.Millisecond).String(),
+		"write_speed", util.HumanReadableBytes(rate(s.written, s.writeTime)) + "/s",
+	}
+	if s.hashed > 0 {
+		attrs = append(attrs,
+			"hash_time", s.hashTime.Round(time.Millisecond).String(),
+			"hash_speed", util.HumanReadableBytes(rate(s.hashed, s.hashTime))+"/s",
+		)
+	}
+	s.logger.Debug(msg, append(attrs, "bottleneck", bottleneck)...)
+}
+
+// rate returns bytes per second, or 0 when no time was spent
+func rate(n int64, d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(float64(n) / d.Seconds())
+}
+
+// timedReader counts the reads and bytes of r and the time spent waiting on it
+type timedReader struct {
+	r     io.Reader
+	reads *int64
+	n     *int64
+	d     *time.Duration
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	*t.d += time.Since(start)
+	*t.reads++
+	*t.n += int64(n)
+	return n, err
+}
+
+// timedWriter counts the bytes written to w and the time spent writing
+type timedWriter struct {
+	w io.Writer
+	n *int64
+	d *time.Duration
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	*t.d += time.Since(start)
+	*t.n += int64(n)
+	return n, err
+}
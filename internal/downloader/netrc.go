@@ -0,0 +1,34 @@
+package downloader
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/lucrnz/ripvex/internal/netrc"
+	"github.com/lucrnz/ripvex/internal/redact"
+)
+
+// applyNetrc sends the .netrc credentials of the request host as basic auth.
+// Explicit credentials win: an Authorization header or a password in the
+// URL. A user name in the URL picks the entry for that user. Like any
+// Authorization header, the credentials are dropped by newCheckRedirect
+// when a redirect leaves the origin.
+func applyNetrc(req *http.Request, f *netrc.File, logger *slog.Logger) {
+	if f == nil || req.Header.Get("Authorization") != "" {
+		return
+	}
+	var login string
+	if u := req.URL.User; u != nil {
+		if _, ok := u.Password(); ok {
+			return
+		}
+		login = u.Username()
+	}
+	m := f.Lookup(req.URL.Hostname(), login)
+	if m == nil || m.Login == "" {
+		return
+	}
+	redact.Register(m.Password)
+	req.SetBasicAuth(m.Login, m.Password)
+	logger.Debug("netrc_credentials", "host", req.URL.Hostname(), "login", m.Login)
+}
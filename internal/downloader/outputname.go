@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// headerPlaceholder starts an output name placeholder resolved from a
+// response header, e.g. "tool-{header:X-Artifact-Version}.tar.gz"
+const headerPlaceholder = "{header:"
+
+// maxHeaderValueLen caps how much of a header value ends up in a file name
+const maxHeaderValueLen = 128
+
+// expandHeaderPlaceholders replaces every {header:Name} in name with the
+// sanitized value of that response header. A missing or empty header is an
+// error, so a misconfigured server never produces a file named "tool-.tar.gz".
+func expandHeaderPlaceholders(name string, h http.Header) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(name, headerPlaceholder)
+		if start == -1 {
+			b.WriteString(name)
+			break
+		}
+		end := strings.IndexByte(name[start:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("unterminated %s placeholder in output name", headerPlaceholder)
+		}
+		end += start
+
+		key := strings.TrimSpace(name[start+len(headerPlaceholder) : end])
+		if key == "" {
+			return "", fmt.Errorf("empty header name in output placeholder")
+		}
+		value := sanitizeNameComponent(h.Get(key))
+		if value == "" {
+			return "", fmt.Errorf("output name needs the %s response header, but it is missing or empty", key)
+		}
+
+		b.WriteString(name[:start])
+		b.WriteString(value)
+		name = name[end+1:]
+	}
+	return b.String(), nil
+}
+
+// sanitizeNameComponent makes a server-controlled value safe to use inside a
+// single path component: separators and control characters become '_', and
+// values that would be "." or ".." are rejected
+func sanitizeNameComponent(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) > maxHeaderValueLen {
+		v = v[:maxHeaderValueLen]
+	}
+	v = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == ':' || r == 0:
+			return '_'
+		case unicode.IsControl(r):
+			return '_'
+		}
+		return r
+	}, v)
+	if strings.Trim(v, ".") == "" {
+		return ""
+	}
+	return v
+}
@@ -0,0 +1,261 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Suffixes of the files an Options.Partial download keeps next to its output
+const (
+	partialSuffix     = ".part"
+	partialMetaSuffix = ".part.json"
+)
+
+// partialMeta is what the metadata file of a partial download records,
+// enough to ask the server for the rest and to tell whether it changed. It
+// is written when the body starts and again when the download fails; the
+// size of the part, not Bytes, says where to resume, so a part left by a
+// killed process is resumed too.
+type partialMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Bytes        int64  `json:"bytes"`           // Size of the part when the metadata was written
+	Total        int64  `json:"total,omitempty"` // Full size, 0 if unknown
+}
+
+// validator returns the If-Range value that makes the server send the rest
+// only if the resource is unchanged: a strong ETag, or else Last-Modified
+func (m partialMeta) validator() string {
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// partialDownload is an Options.Partial download. The body is written to
+// path, which is renamed to the output once complete; a failed download
+// leaves path and metaPath behind for the next run to resume from offset.
+type partialDownload struct {
+	url      string // Requested URL, before redirects
+	path     string
+	metaPath string
+	meta     partialMeta // Of the part being resumed
+	offset   int64       // Bytes kept from the previous run; 0 starts over
+}
+
+// loadPartial prepares the partial download of url to output, resuming the
+// part a previous run left if its metadata matches. Without a validator the
+// part is only resumed when the result is hash-verified.
+func loadPartial(output, url string, verified bool, logger *slog.Logger) *partialDownload {
+	p := &partialDownload{url: url, path: output + partialSuffix, metaPath: output + partialMetaSuffix}
+	data, err := os.ReadFile(p.metaPath)
+	if err != nil {
+		return p
+	}
+
+	var meta partialMeta
+	info, statErr := os.Stat(p.path)
+	var reason string
+	switch {
+	case json.Unmarshal(data, &meta) != nil:
+		reason = "metadata file is not valid JSON"
+	case meta.URL != url:
+		reason = "part was downloaded from a different URL"
+	case statErr != nil || info.Size() == 0:
+		reason = "part file is missing or empty"
+	case meta.validator() == "" && !verified:
+		reason = "no strong ETag or Last-Modified to tell whether the file changed"
+	}
+	if reason != "" {
+		logger.Info("partial_discarded", "file", p.path, "reason", reason)
+		return p
+	}
+	p.meta, p.offset = meta, info.Size()
+	logger.Info("partial_resume", "file", p.path, "offset", p.offset, "total", meta.Total)
+	return p
+}
+
+// request asks for the bytes after the part, if there is one to resume
+func (p *partialDownload) request(req *http.Request) {
+	if p.offset == 0 {
+		return
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(p.offset, 10)+"-")
+	if v := p.meta.validator(); v != "" {
+		req.Header.Set("If-Range", v)
+	}
+}
+
+// accept checks how the server answered a resumed request: 206 continues
+// the part, 200 means the resource changed or ranges are not supported, and
+// the download starts over. A 416 drops the part, so a range the server
+// cannot satisfy is not asked for again; other statuses fail with an
+// HTTPError and keep the part for the next run.
+func (p *partialDownload) accept(resp *http.Response, logger *slog.Logger) error {
+	if p.offset == 0 {
+		return nil
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, ok := contentRangeStart(resp.Header.Get("Content-Range"))
+		if !ok || start != p.offset {
+			p.discard()
+			return &NetworkError{Err: fmt.Errorf("server resumed at an unexpected position (Content-Range %q, expected offset %d)", resp.Header.Get("Content-Range"), p.offset)}
+		}
+		return nil
+	case http.StatusOK:
+		logger.Info("partial_restart", "file", p.path, "reason", "server sent the whole file")
+	case http.StatusRequestedRangeNotSatisfiable:
+		p.discard()
+	default:
+		return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	p.meta, p.offset = partialMeta{}, 0
+	return nil
+}
+
+// contentRangeStart returns the first byte position of a Content-Range
+// header such as "bytes 100-199/200"
+func contentRangeStart(header string) (int64, bool) {
+	rest, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
+}
+
+// open opens the part for writing after the kept bytes. When resuming, it
+// also returns the part opened for reading: the kept bytes go through
+// hashing and the size limit again, but are not rewritten.
+func (p *partialDownload) open() (file, prefix *os.File, err error) {
+	if p.offset == 0 {
+		file, err := os.Create(p.path)
+		return file, nil, err
+	}
+	if prefix, err = os.Open(p.path); err != nil {
+		return nil, nil, err
+	}
+	if file, err = os.OpenFile(p.path, os.O_WRONLY, 0); err == nil {
+		_, err = file.Seek(p.offset, io.SeekStart)
+	}
+	if err != nil {
+		prefix.Close()
+		if file != nil {
+			file.Close()
+		}
+		return nil, nil, err
+	}
+	return file, prefix, nil
+}
+
+// begin records the metadata of the part before the body is written
+func (p *partialDownload) begin(resp *http.Response, logger *slog.Logger) {
+	if resp.Uncompressed {
+		os.Remove(p.metaPath) // See keep
+		return
+	}
+	if p.offset == 0 {
+		p.meta = partialMeta{Total: max(resp.ContentLength, 0)}
+	}
+	p.meta.URL = p.url
+	// A 206 may leave out the validators the previous run recorded
+	if etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"); etag != "" || lastModified != "" {
+		p.meta.ETag, p.meta.LastModified = etag, lastModified
+	}
+	p.save(p.offset, logger)
+}
+
+// keep records the part left by a failed download for the next run. Parts
+// that cannot be resumed are removed instead: bodies that failed
+// verification or the size limit, and bodies Go decompressed on the fly,
+// whose offsets do not match the encoded resource.
+func (p *partialDownload) keep(resp *http.Response, downloadErr error, logger *slog.Logger) {
+	info, err := os.Stat(p.path)
+	if err != nil || info.Size() == 0 || resp.Uncompressed || errors.Is(downloadErr, ErrHashMismatch) || errors.Is(downloadErr, ErrMaxBytes) {
+		p.discard()
+		return
+	}
+	if p.save(info.Size(), logger) {
+		logger.Info("partial_saved", "file", p.path, "bytes", info.Size(), "total", p.meta.Total)
+	}
+}
+
+// save writes the metadata file for a part of size bytes
+func (p *partialDownload) save(size int64, logger *slog.Logger) bool {
+	p.meta.Bytes = size
+	data, _ := json.Marshal(p.meta)
+	if err := os.WriteFile(p.metaPath, data, 0644); err != nil {
+		logger.Warn("partial_save_failed", "file", p.metaPath, "error", err)
+		return false
+	}
+	return true
+}
+
+// complete moves the finished part to output and drops its metadata
+func (p *partialDownload) complete(output string) error {
+	if err := os.Rename(p.path, output); err != nil {
+		return fmt.Errorf("error moving %s into place: %w", p.path, err)
+	}
+	os.Remove(p.metaPath)
+	return nil
+}
+
+// discard removes the part and its metadata
+func (p *partialDownload) discard() {
+	os.Remove(p.path)
+	os.Remove(p.metaPath)
+}
+
+// PartialSize returns the size of the part an Options.Partial download of
+// output left, or 0 if there is none
+func PartialSize(output string) int64 {
+	info, err := os.Stat(output + partialSuffix)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// DiscardPartial removes the part an Options.Partial download of output
+// left and its metadata
+func DiscardPartial(output string) {
+	(&partialDownload{path: output + partialSuffix, metaPath: output + partialMetaSuffix}).discard()
+}
+
+// writer returns the writer for the body read back from the start of the
+// part: the kept bytes are already in w, so they are skipped
+func (p *partialDownload) writer(w io.Writer) io.Writer {
+	if p.offset == 0 {
+		return w
+	}
+	return &skipWriter{w: w, skip: p.offset}
+}
+
+// skipWriter drops the first skip bytes written to it
+type skipWriter struct {
+	w    io.Writer
+	skip int64
+}
+
+func (s *skipWriter) Write(b []byte) (int, error) {
+	skipped := int(min(s.skip, int64(len(b))))
+	s.skip -= int64(skipped)
+	if skipped == len(b) {
+		return skipped, nil
+	}
+	n, err := s.w.Write(b[skipped:])
+	return skipped + n, err
+}
@@ -0,0 +1,31 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// preallocate reserves the disk space for the bytes of a total-byte body
+// still to be written to file after offset, when Options.Preallocate is set
+// and the size is known. A filesystem that cannot preallocate is not an
+// error, but running out of space is: it would only fail later.
+func preallocate(file *os.File, offset, total int64, opts Options, logger *slog.Logger) error {
+	if !opts.Preallocate || total <= offset || (opts.MaxBytes > 0 && total > opts.MaxBytes) {
+		return nil
+	}
+	err := util.Preallocate(file, offset, total-offset)
+	switch {
+	case err == nil:
+		logger.Debug("output_preallocated", "file", file.Name(), "bytes", total-offset)
+	case errors.Is(err, syscall.ENOSPC):
+		return fmt.Errorf("not enough disk space for %s: %w", util.HumanReadableBytes(total-offset), err)
+	default:
+		logger.Debug("output_preallocate_skipped", "file", file.Name(), "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/lucrnz/ripvex/internal/logging"
+)
+
+// ProbeResult is what a HEAD request reveals about a download
+type ProbeResult struct {
+	URL           string // Effective URL after redirects
+	HTTPCode      int    // Status code of the final response
+	ContentLength int64  // Content-Length of the final response (-1 if unknown)
+}
+
+// Probe sends a HEAD request for opts.URL with the same TLS, redirect and
+// header settings as Download. The status code is not checked; servers that
+// do not support HEAD are the caller's call.
+func Probe(ctx context.Context, opts Options) (*ProbeResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	logger := logging.FromContext(ctx)
+
+	client, err := newClient(opts, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, opts.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	if err := opts.HostPolicy.Check(req.URL); err != nil {
+		return nil, &NetworkError{Err: err}
+	}
+	if opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+	for key, value := range opts.Headers {
+		req.Header.Set(key, value)
+	}
+	if err := applyAuthHelper(ctx, req, opts.AuthHelper, logger); err != nil {
+		return nil, err
+	}
+	applyNetrc(req, opts.Netrc, logger)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &NetworkError{Err: fmt.Errorf("error probing URL: %w", err)}
+	}
+	resp.Body.Close()
+
+	return &ProbeResult{
+		URL:           resp.Request.URL.String(),
+		HTTPCode:      resp.StatusCode,
+		ContentLength: resp.ContentLength,
+	}, nil
+}
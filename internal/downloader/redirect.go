@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lucrnz/ripvex/internal/redact"
+)
+
+// Redirect policies accepted by Options.RedirectPolicy
+const (
+	RedirectAny              = "any"
+	RedirectSameHost         = "same-host"
+	RedirectSameOrigin       = "same-origin"
+	RedirectHTTPSUpgradeOnly = "https-upgrade-only"
+)
+
+// RedirectPolicies lists the supported redirect policies in display order
+var RedirectPolicies = []string{RedirectAny, RedirectSameHost, RedirectSameOrigin, RedirectHTTPSUpgradeOnly}
+
+// ValidateRedirectPolicy returns an error if policy is not a supported redirect policy
+func ValidateRedirectPolicy(policy string) error {
+	for _, p := range RedirectPolicies {
+		if policy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported redirect policy %q: must be one of %s", policy, strings.Join(RedirectPolicies, ", "))
+}
+
+// newCheckRedirect builds the http.Client CheckRedirect hook enforcing the
+// redirect limit and policy. Authorization headers are dropped whenever a hop
+// leaves the origin of the initial request, so credentials never reach a
+// third-party redirect target.
+func newCheckRedirect(maxRedirects int, policy string, logger *slog.Logger) func(*http.Request, []*http.Request) error {
+	if policy == "" {
+		policy = RedirectAny
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if maxRedirects >= 0 && len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+
+		initial := via[0].URL
+		prev := via[len(via)-1].URL
+		if err := checkRedirectPolicy(policy, prev, req.URL); err != nil {
+			return err
+		}
+
+		logger.Debug("redirect", "from", redact.URL(prev), "to", redact.URL(req.URL), "hop", len(via))
+
+		if !sameOrigin(initial, req.URL) && req.Header.Get("Authorization") != "" {
+			req.Header.Del("Authorization")
+			logger.Debug("redirect_auth_stripped", "to", redact.URL(req.URL))
+		}
+		return nil
+	}
+}
+
+// checkRedirectPolicy validates a single redirect hop from prev to next
+func checkRedirectPolicy(policy string, prev, next *url.URL) error {
+	switch policy {
+	case RedirectAny:
+		return nil
+	case RedirectSameHost:
+		if !strings.EqualFold(prev.Hostname(), next.Hostname()) {
+			return fmt.Errorf("redirect to %s not allowed by %s policy", next.Host, policy)
+		}
+	case RedirectSameOrigin:
+		if !sameOrigin(prev, next) {
+			return fmt.Errorf("redirect to %s://%s not allowed by %s policy", next.Scheme, next.Host, policy)
+		}
+	case RedirectHTTPSUpgradeOnly:
+		// Only same-host hops that keep or upgrade to HTTPS are allowed
+		if !strings.EqualFold(prev.Hostname(), next.Hostname()) || next.Scheme != "https" {
+			return fmt.Errorf("redirect to %s://%s not allowed by %s policy", next.Scheme, next.Host, policy)
+		}
+	default:
+		return ValidateRedirectPolicy(policy)
+	}
+	return nil
+}
+
+// sameOrigin reports whether two URLs share scheme, host and port
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		strings.EqualFold(a.Hostname(), b.Hostname()) &&
+		effectivePort(a) == effectivePort(b)
+}
+
+// effectivePort returns the explicit port of u or the scheme default
+func effectivePort(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/lucrnz/ripvex/internal/util"
+)
+
+// syncWriter flushes the file it writes to every interval bytes, so a very
+// large download does not leave gigabytes of dirty pages to write back at
+// the end, and a crash loses at most interval bytes of a kept part
+type syncWriter struct {
+	file     *os.File
+	interval int64
+	pending  int64 // Bytes written since the last flush
+}
+
+func (s *syncWriter) Write(b []byte) (int, error) {
+	n, err := s.file.Write(b)
+	s.pending += int64(n)
+	if err == nil && s.pending >= s.interval {
+		s.pending = 0
+		if err := s.file.Sync(); err != nil {
+			return n, fmt.Errorf("error syncing output file: %w", err)
+		}
+	}
+	return n, err
+}
+
+// outputWriter returns the writer for the output file: the file itself, or
+// a syncWriter with Options.FsyncIntervalBytes
+func outputWriter(file *os.File, opts Options) io.Writer {
+	if opts.FsyncIntervalBytes <= 0 {
+		return file
+	}
+	return &syncWriter{file: file, interval: opts.FsyncIntervalBytes}
+}
+
+// syncOutput flushes a completely written output file to stable storage,
+// before it is closed
+func syncOutput(file *os.File, opts Options) error {
+	if !opts.Sync {
+		return nil
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("error syncing output file: %w", err)
+	}
+	return nil
+}
+
+// syncOutputDir flushes the directory of output once the file is in place,
+// so its name survives a crash too
+func syncOutputDir(output string, opts Options, logger *slog.Logger) error {
+	if !opts.Sync {
+		return nil
+	}
+	if err := util.SyncDir(output); err != nil {
+		return fmt.Errorf("error syncing output directory: %w", err)
+	}
+	logger.Debug("output_synced", "file", output)
+	return nil
+}
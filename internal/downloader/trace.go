@@ -0,0 +1,130 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/logging"
+	"github.com/lucrnz/ripvex/internal/redact"
+)
+
+// wireTracer writes one JSON object per connection/request/response event to
+// a trace file, with the time elapsed since the download started. It is meant
+// for diagnosing slow or failing mirrors after the fact.
+type wireTracer struct {
+	logger *slog.Logger
+	start  time.Time
+}
+
+func newWireTracer(w io.Writer) *wireTracer {
+	return &wireTracer{logger: logging.NewEventLogger(w), start: time.Now()}
+}
+
+func (t *wireTracer) event(name string, args ...any) {
+	elapsed := time.Since(t.start)
+	args = append([]any{"elapsed_ms", float64(elapsed.Microseconds()) / 1000}, args...)
+	t.logger.Log(context.Background(), slog.LevelInfo, name, args...)
+}
+
+// clientTrace returns the httptrace hooks feeding this tracer
+func (t *wireTracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			t.event("get_conn", "host_port", hostPort)
+		},
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			t.event("dns_start", "host", info.Host)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			addrs := make([]string, 0, len(info.Addrs))
+			for _, a := range info.Addrs {
+				addrs = append(addrs, a.String())
+			}
+			t.event("dns_done", "addrs", addrs, "coalesced", info.Coalesced, "error", errString(info.Err))
+		},
+		ConnectStart: func(network, addr string) {
+			t.event("connect_start", "network", network, "addr", addr)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.event("connect_done", "network", network, "addr", addr, "error", errString(err))
+		},
+		TLSHandshakeStart: func() {
+			t.event("tls_handshake_start")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			args := []any{"error", errString(err)}
+			if err == nil {
+				args = append(args,
+					"version", tls.VersionName(state.Version),
+					"cipher", tls.CipherSuiteName(state.CipherSuite),
+					"alpn", state.NegotiatedProtocol,
+					"resumed", state.DidResume,
+				)
+				if len(state.PeerCertificates) > 0 {
+					cert := state.PeerCertificates[0]
+					args = append(args, "cert_subject", cert.Subject.String(), "cert_issuer", cert.Issuer.String(), "cert_not_after", cert.NotAfter.UTC())
+				}
+			}
+			t.event("tls_handshake_done", args...)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.event("got_conn", "remote_addr", info.Conn.RemoteAddr().String(), "reused", info.Reused, "was_idle", info.WasIdle)
+		},
+		WroteHeaderField: func(key string, value []string) {
+			for _, v := range value {
+				t.event("request_header", "name", key, "value", redact.Header(key, v))
+			}
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			t.event("wrote_request", "error", errString(info.Err))
+		},
+		GotFirstResponseByte: func() {
+			t.event("first_response_byte")
+		},
+	}
+}
+
+// request records the start of a request (initial or redirect hop)
+func (t *wireTracer) request(req *http.Request) {
+	t.event("request", "method", req.Method, "url", redact.URL(req.URL))
+}
+
+// response records the status and headers of a response
+func (t *wireTracer) response(resp *http.Response) {
+	headers := make(map[string]string, len(resp.Header))
+	for k, v := range resp.Header {
+		redacted := make([]string, len(v))
+		for i, value := range v {
+			redacted[i] = redact.Header(k, value)
+		}
+		headers[k] = strings.Join(redacted, ", ")
+	}
+	t.event("response", "proto", resp.Proto, "status", resp.StatusCode, "content_length", resp.ContentLength, "headers", headers)
+}
+
+// redirect records a redirect hop; req.Response holds the redirect response
+func (t *wireTracer) redirect(req *http.Request, via []*http.Request) {
+	if req.Response != nil {
+		t.response(req.Response)
+	}
+	t.event("redirect", "hop", len(via), "to", redact.URL(req.URL))
+	t.request(req)
+}
+
+// done records the end of the transfer
+func (t *wireTracer) done(bytes int64, err error) {
+	t.event("done", "bytes", bytes, "error", errString(err))
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,144 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lucrnz/ripvex/internal/redact"
+)
+
+// verboseTracer prints curl -v style request/response details.
+// Level 1 shows request and response headers, redirect hops and TLS details;
+// level 2 and above also shows DNS and connection events.
+type verboseTracer struct {
+	w     io.Writer
+	level int
+
+	mu            sync.Mutex
+	current       *http.Request
+	headerStarted bool
+}
+
+func newVerboseTracer(w io.Writer, level int, req *http.Request) *verboseTracer {
+	return &verboseTracer{w: w, level: level, current: req}
+}
+
+func (t *verboseTracer) printf(format string, args ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, format, args...)
+}
+
+// clientTrace returns the httptrace hooks feeding this tracer
+func (t *verboseTracer) clientTrace() *httptrace.ClientTrace {
+	ct := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.printf("* Reusing connection to %s\n", info.Conn.RemoteAddr())
+			} else {
+				t.printf("* Connected to %s\n", info.Conn.RemoteAddr())
+			}
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				t.printf("* TLS handshake failed: %v\n", err)
+				return
+			}
+			t.printf("* %s, cipher %s\n", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+			if state.NegotiatedProtocol != "" {
+				t.printf("* ALPN: %s\n", state.NegotiatedProtocol)
+			}
+			if len(state.PeerCertificates) > 0 {
+				cert := state.PeerCertificates[0]
+				t.printf("* Server certificate: subject=%q issuer=%q expires=%s\n",
+					cert.Subject.String(), cert.Issuer.String(), cert.NotAfter.UTC().Format("2006-01-02"))
+			}
+		},
+		WroteHeaderField: func(key string, value []string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if !t.headerStarted {
+				t.headerStarted = true
+				if t.current != nil {
+					proto := t.current.Proto
+					if proto == "" {
+						proto = "HTTP/1.1" // redirect requests leave Proto unset
+					}
+					fmt.Fprintf(t.w, "> %s %s %s\n", t.current.Method, redact.RequestURI(t.current.URL), proto)
+				}
+			}
+			fmt.Fprintf(t.w, "> %s: %s\n", key, redact.Header(key, strings.Join(value, ", ")))
+		},
+		WroteHeaders: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			fmt.Fprintln(t.w, ">")
+			t.headerStarted = false
+		},
+	}
+
+	if t.level >= 2 {
+		ct.DNSStart = func(info httptrace.DNSStartInfo) {
+			t.printf("* Resolving %s\n", info.Host)
+		}
+		ct.DNSDone = func(info httptrace.DNSDoneInfo) {
+			if info.Err != nil {
+				t.printf("* DNS lookup failed: %v\n", info.Err)
+				return
+			}
+			addrs := make([]string, 0, len(info.Addrs))
+			for _, a := range info.Addrs {
+				addrs = append(addrs, a.String())
+			}
+			t.printf("* Resolved to %s\n", strings.Join(addrs, ", "))
+		}
+		ct.ConnectStart = func(network, addr string) {
+			t.printf("* Trying %s (%s)\n", addr, network)
+		}
+		ct.ConnectDone = func(network, addr string, err error) {
+			if err != nil {
+				t.printf("* Connect to %s failed: %v\n", addr, err)
+			}
+		}
+		ct.TLSHandshakeStart = func() {
+			t.printf("* TLS handshake started\n")
+		}
+	}
+
+	return ct
+}
+
+// redirect records a redirect hop; req.Response holds the redirect response
+func (t *verboseTracer) redirect(req *http.Request, via []*http.Request) {
+	if req.Response != nil {
+		t.response(req.Response)
+	}
+	t.printf("* Redirect %d to %s\n", len(via), redact.URL(req.URL))
+	t.mu.Lock()
+	t.current = req
+	t.mu.Unlock()
+}
+
+// response prints the status line and headers of resp
+func (t *verboseTracer) response(resp *http.Response) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "< %s %s\n", resp.Proto, resp.Status)
+	keys := make([]string, 0, len(resp.Header))
+	for k := range resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range resp.Header[k] {
+			fmt.Fprintf(t.w, "< %s: %s\n", k, redact.Header(k, v))
+		}
+	}
+	fmt.Fprintln(t.w, "<")
+}
@@ -0,0 +1,35 @@
+package fips
+
+import (
+	"crypto/fips140"
+	"crypto/tls"
+)
+
+// tlsCipherSuites are the FIPS-approved TLS 1.2 cipher suites (ECDHE with
+// AES-GCM). TLS 1.3 suites are not configurable in crypto/tls; when the Go
+// FIPS module is active it restricts them to AES-GCM itself.
+var tlsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// tlsCurves are the FIPS-approved key exchange groups (NIST P-curves)
+var tlsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// ModuleEnabled reports whether the Go FIPS 140-3 cryptographic module is
+// active, either because the binary was built with GOFIPS140 or because it
+// runs with GODEBUG=fips140=on.
+func ModuleEnabled() bool {
+	return fips140.Enabled()
+}
+
+// ApplyTLS restricts cfg to TLS 1.2+ with FIPS-approved cipher suites and curves
+func ApplyTLS(cfg *tls.Config) {
+	if cfg.MinVersion < tls.VersionTLS12 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	cfg.CipherSuites = tlsCipherSuites
+	cfg.CurvePreferences = tlsCurves
+}
@@ -0,0 +1,357 @@
+// Package jobserver implements the HTTP API of `ripvex serve`: download
+// jobs are queued, run by a bounded pool of workers, and can be listed,
+// watched and canceled while they run.
+package jobserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/downloader"
+	"github.com/lucrnz/ripvex/internal/progress"
+)
+
+// States of a job
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateFailed    = "failed"
+	StateCanceled  = "canceled"
+)
+
+// maxFinished is how many finished jobs are kept for GET /jobs; older ones
+// are forgotten so a long-running server does not grow without bound
+const maxFinished = 1000
+
+// progressInterval is how often a running job's progress is updated
+const progressInterval = 500 * time.Millisecond
+
+// maxRequestBytes bounds the body of POST /jobs
+const maxRequestBytes = 64 * 1024
+
+// Request is the body of POST /jobs
+type Request struct {
+	URL    string `json:"url"`
+	Output string `json:"output,omitempty"` // Path below the download directory; named after the URL by default
+	Hash   string `json:"hash,omitempty"`   // Expected hash in --hash format
+}
+
+// Job is a job as the API reports it
+type Job struct {
+	ID         string     `json:"id"`
+	State      string     `json:"state"`
+	URL        string     `json:"url"`
+	Output     string     `json:"output"`
+	Hash       string     `json:"hash,omitempty"`
+	Bytes      int64      `json:"bytes"`
+	Total      int64      `json:"total"` // -1 until known, or if the server does not say
+	Percent    float64    `json:"percent"`
+	Speed      int64      `json:"speed"` // Bytes per second over the last progress interval
+	Digest     string     `json:"digest,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	ExitCode   int        `json:"exit_code,omitempty"` // What the ripvex command would have exited with
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Config configures a Server
+type Config struct {
+	Workers   int    // Jobs run at the same time
+	QueueSize int    // Jobs waiting for a worker; POST /jobs answers 503 beyond it
+	Token     string // Bearer token every request must carry; empty disables authentication
+	Logger    *slog.Logger
+
+	// Hosts are the Host headers requests may carry, the listen address and
+	// its names. Others are refused, so a web page whose DNS name is rebound
+	// to the listen address cannot reach the API. Empty accepts any host.
+	Hosts []string
+
+	// Prepare validates a request before it is queued and resolves its
+	// output path. Its error is returned to the client as a 400.
+	Prepare func(req Request) (Request, error)
+	// Run downloads a prepared request, reporting progress through the
+	// factory. It returns the digest recorded for the job.
+	Run func(ctx context.Context, req Request, progress downloader.ProgressFactory) (digest string, err error)
+	// ExitCode maps an error of Run to a ripvex exit code
+	ExitCode func(err error) int
+}
+
+// Server queues jobs and serves the API
+type Server struct {
+	cfg   Config
+	mux   *http.ServeMux
+	queue chan *job
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	order    []*job // By creation, for listing and pruning
+	finished int
+}
+
+// job is a Job with what the server needs to run and cancel it
+type job struct {
+	Job
+	req    Request
+	cancel context.CancelFunc // Set while running
+}
+
+// New creates a Server. Call Run to start its workers.
+func New(cfg Config) *Server {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	s := &Server{
+		cfg:   cfg,
+		mux:   http.NewServeMux(),
+		queue: make(chan *job, cfg.QueueSize),
+		jobs:  make(map[string]*job),
+	}
+	s.mux.HandleFunc("POST /jobs", s.handleCreate)
+	s.mux.HandleFunc("GET /jobs", s.handleList)
+	s.mux.HandleFunc("GET /jobs/{id}", s.handleGet)
+	s.mux.HandleFunc("DELETE /jobs/{id}", s.handleCancel)
+	return s
+}
+
+// Run runs queued jobs on Config.Workers workers until ctx is done, then
+// waits for the running jobs, which are canceled with it
+func (s *Server) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range s.cfg.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case j := <-s.queue:
+					s.run(ctx, j)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ServeHTTP implements http.Handler. The API is for programs, not
+// browsers: requests for another host and requests carrying an Origin
+// header, which browsers add to cross-origin requests, are refused.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(s.cfg.Hosts) > 0 && !slices.Contains(s.cfg.Hosts, r.Host) {
+		writeError(w, http.StatusMisdirectedRequest, fmt.Sprintf("host %q is not served here", r.Host))
+		return
+	}
+	if r.Header.Get("Origin") != "" {
+		writeError(w, http.StatusForbidden, "cross-origin requests are not allowed")
+		return
+	}
+	if s.cfg.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ripvex"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	// Browsers send simple cross-origin POSTs without a preflight, but
+	// never with a JSON content type
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return
+	}
+	var req Request
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid job: %v", err))
+		return
+	}
+	req, err := s.cfg.Prepare(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	for _, other := range s.order {
+		if other.Output == req.Output && (other.State == StateQueued || other.State == StateRunning) {
+			s.mu.Unlock()
+			writeError(w, http.StatusConflict, fmt.Sprintf("job %s already downloads to %s", other.ID, req.Output))
+			return
+		}
+	}
+	j := &job{
+		Job: Job{ID: newID(), State: StateQueued, URL: req.URL, Output: req.Output, Hash: req.Hash, Total: -1, CreatedAt: time.Now().UTC()},
+		req: req,
+	}
+	select {
+	case s.queue <- j:
+	default:
+		s.mu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, "job queue is full")
+		return
+	}
+	s.jobs[j.ID] = j
+	s.order = append(s.order, j)
+	snapshot := j.Job
+	s.mu.Unlock()
+
+	s.cfg.Logger.Info("job_queued", "id", j.ID, "url", j.URL, "output", j.Output)
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	list := make([]Job, 0, len(s.order))
+	for _, j := range s.order {
+		list = append(list, j.Job)
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string][]Job{"jobs": list})
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	var snapshot Job
+	if ok {
+		snapshot = j.Job
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such job")
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// handleCancel cancels a queued or running job. A queued job is canceled at
+// once; a running one once its download has stopped.
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "no such job")
+		return
+	}
+	switch j.State {
+	case StateQueued:
+		s.finish(j, StateCanceled, "", nil)
+	case StateRunning:
+		j.cancel()
+	default:
+		state := j.State
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, "job is already "+state)
+		return
+	}
+	snapshot := j.Job
+	s.mu.Unlock()
+	s.cfg.Logger.Info("job_cancel_requested", "id", j.ID)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+// run runs j on the calling worker, unless it was canceled while queued
+func (s *Server) run(ctx context.Context, j *job) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	if j.State != StateQueued {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now().UTC()
+	j.State, j.StartedAt, j.cancel = StateRunning, &now, cancel
+	s.mu.Unlock()
+	s.cfg.Logger.Info("job_start", "id", j.ID, "url", j.URL, "output", j.Output)
+
+	digest, err := s.cfg.Run(ctx, j.req, func(total int64) downloader.ProgressReporter {
+		s.mu.Lock()
+		j.Total = total
+		s.mu.Unlock()
+		return &progress.Callback{Total: total, Interval: progressInterval, Func: func(e progress.Event) {
+			s.mu.Lock()
+			j.Bytes, j.Percent, j.Speed = e.Bytes, e.Percent, e.Speed
+			s.mu.Unlock()
+		}}
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err == nil:
+		s.finish(j, StateCompleted, digest, nil)
+		s.cfg.Logger.Info("job_complete", "id", j.ID, "output", j.Output, "bytes", j.Bytes)
+	case ctx.Err() != nil:
+		s.finish(j, StateCanceled, "", nil)
+		s.cfg.Logger.Info("job_canceled", "id", j.ID)
+	default:
+		s.finish(j, StateFailed, "", err)
+		s.cfg.Logger.Warn("job_failed", "id", j.ID, "error", err)
+	}
+}
+
+// finish records the end of j and forgets the oldest finished jobs beyond
+// maxFinished. s.mu must be held.
+func (s *Server) finish(j *job, state, digest string, err error) {
+	now := time.Now().UTC()
+	j.State, j.Digest, j.FinishedAt, j.cancel = state, digest, &now, nil
+	j.Speed = 0
+	if err != nil {
+		j.Error = err.Error()
+		if s.cfg.ExitCode != nil {
+			j.ExitCode = s.cfg.ExitCode(err)
+		}
+	}
+	s.finished++
+	for i := 0; s.finished > maxFinished && i < len(s.order); {
+		old := s.order[i]
+		if old.State == StateQueued || old.State == StateRunning {
+			i++
+			continue
+		}
+		delete(s.jobs, old.ID)
+		s.order = append(s.order[:i], s.order[i+1:]...)
+		s.finished--
+	}
+}
+
+// newID returns a random job ID
+func newID() string {
+	return strings.ToLower(rand.Text()[:16])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// Stderr is the diagnostic stream shared by logs, verbose tracing and progress
+// output. If stderr is closed (e.g. the parent process died), output is
+// silently dropped so the download itself can run to completion.
+var Stderr = NewFailsafeWriter(os.Stderr)
+
+// FailsafeWriter forwards writes to w until the first error, then discards
+// everything. It never reports an error to the caller.
+type FailsafeWriter struct {
+	w      io.Writer
+	failed atomic.Bool
+}
+
+// NewFailsafeWriter wraps w
+func NewFailsafeWriter(w io.Writer) *FailsafeWriter {
+	return &FailsafeWriter{w: w}
+}
+
+func (f *FailsafeWriter) Write(p []byte) (int, error) {
+	if f.failed.Load() {
+		return len(p), nil
+	}
+	if _, err := f.w.Write(p); err != nil {
+		f.failed.Store(true)
+	}
+	return len(p), nil
+}
+
+// Failed reports whether a write has failed and output is being discarded
+func (f *FailsafeWriter) Failed() bool {
+	return f.failed.Load()
+}
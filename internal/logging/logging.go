@@ -3,15 +3,22 @@ package logging
 import (
 	"context"
 	"errors"
+	"io"
 	"log/slog"
-	"os"
 	"strings"
+
+	"github.com/lucrnz/ripvex/internal/redact"
 )
 
 type ctxKey struct{}
 
-// New constructs a slog.Logger with the given level and format writing to stderr.
+// New constructs a slog.Logger with the given level and format writing to Stderr.
 func New(level, format string) (*slog.Logger, error) {
+	return NewWriter(level, format, Stderr)
+}
+
+// NewWriter constructs a slog.Logger with the given level and format writing to w.
+func NewWriter(level, format string, w io.Writer) (*slog.Logger, error) {
 	lvl, err := parseLevel(level)
 	if err != nil {
 		return nil, err
@@ -21,14 +28,32 @@ func New(level, format string) (*slog.Logger, error) {
 	opts := &slog.HandlerOptions{Level: lvl}
 	switch strings.ToLower(format) {
 	case "json":
-		handler = slog.NewJSONHandler(os.Stderr, opts)
+		handler = slog.NewJSONHandler(w, opts)
 	case "text", "":
-		handler = slog.NewTextHandler(os.Stderr, opts)
+		handler = slog.NewTextHandler(w, opts)
 	default:
 		return nil, errors.New("unsupported log format: " + format)
 	}
 
-	return slog.New(handler), nil
+	return slog.New(redact.Handler(handler)), nil
+}
+
+// NewEventLogger constructs a slog.Logger writing one JSON object per line to w,
+// for machine consumers. Records carry no level and the message is emitted as
+// "event".
+func NewEventLogger(w io.Writer) *slog.Logger {
+	var handler slog.Handler = slog.NewJSONHandler(w, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.LevelKey {
+				return slog.Attr{}
+			}
+			if len(groups) == 0 && a.Key == slog.MessageKey {
+				a.Key = "event"
+			}
+			return a
+		},
+	})
+	return slog.New(redact.Handler(handler))
 }
 
 // WithContext attaches a logger to the context.
@@ -0,0 +1,131 @@
+// Package netrc reads the .netrc file curl, wget and ftp take login
+// credentials from: "machine <host> login <user> password <secret>" entries,
+// and a "default" entry for any other host.
+package netrc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Machine is the credentials of one host, or of any host for the default entry
+type Machine struct {
+	Name     string // Host name; empty for the default entry
+	Login    string
+	Password string
+}
+
+// File is a parsed .netrc file
+type File struct {
+	machines []Machine
+	def      *Machine
+}
+
+// DefaultPath returns $NETRC, or else .netrc in the home directory (_netrc on
+// Windows), as curl does
+func DefaultPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// Load reads and parses the file at path
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Parse parses the contents of a .netrc file. Comments run from # to the end
+// of the line, and macdef bodies, which end at a blank line, are skipped.
+func Parse(data string) (*File, error) {
+	f := &File{}
+	var current *Machine
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			token := fields[j]
+			if strings.HasPrefix(token, "#") {
+				break
+			}
+			// Every keyword but default takes a value
+			var value string
+			if token != "default" {
+				if j+1 >= len(fields) {
+					return nil, fmt.Errorf("line %d: %s has no value", i+1, token)
+				}
+				j++
+				value = fields[j]
+			}
+			switch token {
+			case "machine":
+				f.machines = append(f.machines, Machine{Name: value})
+				current = &f.machines[len(f.machines)-1]
+			case "default":
+				f.def = &Machine{}
+				current = f.def
+			case "login", "password":
+				if current == nil {
+					return nil, fmt.Errorf("line %d: %s outside a machine entry", i+1, token)
+				}
+				if token == "login" {
+					current.Login = value
+				} else {
+					current.Password = value
+				}
+			case "account", "port":
+				// Not used for HTTP
+			case "macdef":
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			default:
+				return nil, fmt.Errorf("line %d: unknown keyword %q", i+1, token)
+			}
+		}
+	}
+	return f, nil
+}
+
+// Lookup returns the credentials for host, or nil if there are none. When
+// login is not empty, only an entry for that user matches. The default entry
+// applies to hosts without an entry of their own.
+func (f *File) Lookup(host, login string) *Machine {
+	if f == nil {
+		return nil
+	}
+	named := false
+	for i := range f.machines {
+		m := &f.machines[i]
+		if !strings.EqualFold(m.Name, host) {
+			continue
+		}
+		named = true
+		if login == "" || m.Login == login {
+			return m
+		}
+	}
+	if named || f.def == nil || (login != "" && f.def.Login != login) {
+		return nil
+	}
+	return f.def
+}
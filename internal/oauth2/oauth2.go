@@ -0,0 +1,284 @@
+// Package oauth2 fetches bearer tokens from an OAuth 2.0 token endpoint with
+// the client credentials grant (RFC 6749 section 4.4) or the device
+// authorization grant (RFC 8628), and caches them until they expire.
+package oauth2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/redact"
+)
+
+// Grants a Config can use
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// expiryMargin is how long before it expires a cached token is replaced, so
+// it does not expire during the download
+const expiryMargin = 60 * time.Second
+
+// maxResponseBytes bounds a token endpoint response
+const maxResponseBytes = 1 << 20
+
+// Config describes where and how to get a token
+type Config struct {
+	Grant         string // GrantClientCredentials or GrantDeviceCode
+	TokenURL      string
+	DeviceAuthURL string // Device authorization endpoint, for GrantDeviceCode
+	ClientID      string
+	ClientSecret  string // Optional for GrantDeviceCode, which public clients use
+	Scope         string // Space-separated scopes; empty for the server default
+	Client        *http.Client
+	CacheDir      string    // Directory tokens are cached in; empty disables the cache
+	Prompt        io.Writer // Where the device flow tells the user what to open
+	Logger        *slog.Logger
+}
+
+// token is a token endpoint response, and what the cache records
+type token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	ExpiresIn    int64     `json:"expires_in,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitzero"`
+}
+
+// Error is an error response of the token or device authorization endpoint
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// Token returns a bearer token: a cached one while it is valid, else one
+// refreshed with a cached refresh token, else a new one from the grant
+func (c Config) Token(ctx context.Context) (string, error) {
+	cachePath := c.cachePath()
+	cached := c.readCache(cachePath)
+	if cached != nil {
+		redact.Register(cached.AccessToken, cached.RefreshToken)
+	}
+	if cached != nil && cached.AccessToken != "" && time.Until(cached.ExpiresAt) > expiryMargin {
+		c.Logger.Debug("oauth2_token_cached", "token_url", c.TokenURL)
+		return cached.AccessToken, nil
+	}
+
+	var tok *token
+	var err error
+	if cached != nil && cached.RefreshToken != "" {
+		tok, err = c.request(ctx, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {cached.RefreshToken}})
+		if err != nil {
+			c.Logger.Info("oauth2_refresh_failed", "token_url", c.TokenURL, "error", err)
+		} else if tok.RefreshToken == "" {
+			// Servers that do not rotate refresh tokens leave them out
+			tok.RefreshToken = cached.RefreshToken
+		}
+	}
+	if tok == nil {
+		switch c.Grant {
+		case GrantClientCredentials:
+			tok, err = c.request(ctx, url.Values{"grant_type": {GrantClientCredentials}})
+		case GrantDeviceCode:
+			tok, err = c.device(ctx)
+		default:
+			return "", fmt.Errorf("unsupported grant %q", c.Grant)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	redact.Register(tok.AccessToken, tok.RefreshToken)
+	c.Logger.Info("oauth2_token_acquired", "token_url", c.TokenURL, "expires_in", tok.ExpiresIn)
+	c.writeCache(cachePath, tok)
+	return tok.AccessToken, nil
+}
+
+// request posts a token request and decodes the token it returns
+func (c Config) request(ctx context.Context, form url.Values) (*token, error) {
+	var tok token
+	if err := c.post(ctx, c.TokenURL, form, &tok); err != nil {
+		return nil, err
+	}
+	if tok.AccessToken == "" {
+		return nil, fmt.Errorf("token endpoint returned no access_token")
+	}
+	if tok.TokenType != "" && !strings.EqualFold(tok.TokenType, "bearer") {
+		return nil, fmt.Errorf("token endpoint returned a %q token, only bearer tokens are supported", tok.TokenType)
+	}
+	if tok.ExpiresIn > 0 {
+		tok.ExpiresAt = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	}
+	return &tok, nil
+}
+
+// post sends form to endpoint, authenticating the client with HTTP Basic
+// when it has a secret, and decodes the JSON response into v
+func (c Config) post(ctx context.Context, endpoint string, form url.Values, v any) error {
+	if c.Scope != "" && form.Get("grant_type") != GrantDeviceCode {
+		form.Set("scope", c.Scope)
+	}
+	if c.ClientSecret == "" {
+		form.Set("client_id", c.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error requesting %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return fmt.Errorf("error reading response of %s: %w", endpoint, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr Error
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Code != "" {
+			return &oauthErr
+		}
+		return fmt.Errorf("%s returned HTTP %d", endpoint, resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid JSON response from %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// deviceAuth is a device authorization response
+type deviceAuth struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// device runs the device authorization grant: it asks the user to approve
+// the request in a browser and polls the token endpoint until they do
+func (c Config) device(ctx context.Context) (*token, error) {
+	var auth deviceAuth
+	if err := c.post(ctx, c.DeviceAuthURL, url.Values{}, &auth); err != nil {
+		return nil, err
+	}
+	if auth.DeviceCode == "" || auth.VerificationURI == "" {
+		return nil, fmt.Errorf("device authorization endpoint returned no device_code or verification_uri")
+	}
+
+	if auth.VerificationURIComplete != "" {
+		fmt.Fprintf(c.Prompt, "To authorize this download, open %s\n(or open %s and enter the code %s)\n", auth.VerificationURIComplete, auth.VerificationURI, auth.UserCode)
+	} else {
+		fmt.Fprintf(c.Prompt, "To authorize this download, open %s and enter the code %s\n", auth.VerificationURI, auth.UserCode)
+	}
+
+	// Servers that do not say how often to poll expect every 5 seconds
+	interval := 5 * time.Second
+	if auth.Interval > 0 {
+		interval = time.Duration(auth.Interval) * time.Second
+	}
+	expires := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	for auth.ExpiresIn <= 0 || time.Now().Before(expires) {
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-time.After(interval):
+		}
+		tok, err := c.request(ctx, url.Values{"grant_type": {GrantDeviceCode}, "device_code": {auth.DeviceCode}})
+		var oauthErr *Error
+		switch {
+		case err == nil:
+			return tok, nil
+		case errors.As(err, &oauthErr) && oauthErr.Code == "authorization_pending":
+		case errors.As(err, &oauthErr) && oauthErr.Code == "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("the device code expired before the request was authorized")
+}
+
+// cachePath returns the cache file of this configuration: tokens of other
+// endpoints, clients or scopes are cached apart
+func (c Config) cachePath() string {
+	if c.CacheDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{c.Grant, c.TokenURL, c.ClientID, c.Scope}, "\n")))
+	return filepath.Join(c.CacheDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+func (c Config) readCache(path string) *token {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var tok token
+	if err := json.Unmarshal(data, &tok); err != nil {
+		c.Logger.Warn("oauth2_cache_invalid", "file", path, "error", err)
+		return nil
+	}
+	return &tok
+}
+
+// writeCache records tok for later runs. Tokens that do not say when they
+// expire are not cached unless they come with a refresh token.
+func (c Config) writeCache(path string, tok *token) {
+	if path == "" || (tok.ExpiresAt.IsZero() && tok.RefreshToken == "") {
+		return
+	}
+	data, _ := json.Marshal(tok)
+	if err := os.MkdirAll(c.CacheDir, 0700); err != nil {
+		c.Logger.Warn("oauth2_cache_failed", "file", path, "error", err)
+		return
+	}
+	// CreateTemp makes the file readable by its owner only
+	tmp, err := os.CreateTemp(c.CacheDir, ".token-*")
+	if err == nil {
+		_, err = tmp.Write(data)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		c.Logger.Warn("oauth2_cache_failed", "file", path, "error", err)
+	}
+}
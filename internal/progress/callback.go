@@ -0,0 +1,68 @@
+package progress
+
+import "time"
+
+// Event is a snapshot of a transfer passed to Callback.Func
+type Event struct {
+	Bytes   int64   // Transferred so far
+	Total   int64   // Expected size; 0 or less if unknown
+	Percent float64 // 0 when Total is unknown
+	Speed   int64   // Bytes per second since the previous event
+	Elapsed time.Duration
+	Done    bool // Last event of the transfer
+}
+
+// Callback reports progress by calling Func instead of logging or drawing
+// it, for programs embedding the downloader with their own UI. Func runs on
+// the transferring goroutine, so it should return quickly.
+type Callback struct {
+	Total    int64
+	Interval time.Duration // Minimum time between events; 0 sends one per update
+	Func     func(Event)
+
+	bytes     int64
+	start     time.Time
+	last      time.Time
+	lastBytes int64
+	stopped   bool
+}
+
+// Start records the start of the transfer
+func (c *Callback) Start() {
+	c.start = time.Now()
+	c.last = c.start
+}
+
+// Update records n more bytes and sends an event if Interval has passed
+func (c *Callback) Update(n int64) {
+	if n <= 0 {
+		return
+	}
+	c.bytes += n
+	if now := time.Now(); now.Sub(c.last) >= c.Interval {
+		c.emit(now, false)
+	}
+}
+
+// Stop sends the final event, once
+func (c *Callback) Stop() {
+	if c.stopped {
+		return
+	}
+	c.stopped = true
+	c.emit(time.Now(), true)
+}
+
+func (c *Callback) emit(now time.Time, done bool) {
+	e := Event{Bytes: c.bytes, Total: c.Total, Elapsed: now.Sub(c.start), Done: done}
+	if c.Total > 0 {
+		e.Percent = min(float64(c.bytes)/float64(c.Total)*100, 100)
+	}
+	if elapsed := now.Sub(c.last).Seconds(); elapsed > 0 {
+		e.Speed = int64(float64(c.bytes-c.lastBytes) / elapsed)
+	}
+	c.last, c.lastBytes = now, c.bytes
+	if c.Func != nil {
+		c.Func(e)
+	}
+}
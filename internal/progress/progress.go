@@ -2,6 +2,8 @@ package progress
 
 import (
 	"log/slog"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/lucrnz/ripvex/internal/util"
@@ -15,13 +17,19 @@ type Bar struct {
 	RenderInterval time.Duration // interval for interval-based logs
 	Logger         *slog.Logger
 	Quiet          bool
+	Phase          string   // "download" or "extract"; prefixes the log event name
+	Terminal       *os.File // When set, draw an interactive bar here instead of logging
 
 	downloaded        int64
 	nextMilestone     int
 	nextByteLog       int64
 	done              chan struct{} // signals completion
+	stopOnce          sync.Once
+	finished          chan struct{} // closed once the final progress has been written
 	lastIntervalBytes int64
 	lastIntervalTime  time.Time
+	startTime         time.Time
+	terminalFailed    bool
 }
 
 // New creates a progress bar instance with sane defaults.
@@ -52,6 +60,7 @@ func New(total int64, step int, byteStep int64, interval time.Duration, logger *
 		RenderInterval: interval,
 		Logger:         logger,
 		Quiet:          quiet,
+		Phase:          "download",
 		nextMilestone:  next,
 		nextByteLog:    nextBytes,
 		done:           make(chan struct{}),
@@ -65,7 +74,7 @@ func (b *Bar) Update(n int64) {
 	}
 	b.downloaded += n
 
-	if !b.Quiet {
+	if !b.Quiet && b.Terminal == nil {
 		if b.Total > 0 {
 			b.maybeLogMilestone()
 		} else {
@@ -74,31 +83,49 @@ func (b *Bar) Update(n int64) {
 	}
 }
 
-// Start begins interval-based logging in a goroutine
+// Start begins interval-based logging (or redrawing, with Terminal) in a goroutine
 func (b *Bar) Start() {
-	if b.Quiet || b.Logger == nil || b.RenderInterval <= 0 {
+	b.startTime = time.Now()
+	b.lastIntervalTime = b.startTime
+	if b.Quiet || (b.Logger == nil && b.Terminal == nil) || b.RenderInterval <= 0 {
 		return
 	}
+	b.finished = make(chan struct{})
 	go func() {
+		defer close(b.finished)
 		ticker := time.NewTicker(b.RenderInterval)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				b.logCurrentProgress()
+				if b.Terminal != nil {
+					b.render(time.Now(), false)
+				} else {
+					b.logCurrentProgress()
+				}
 			case <-b.done:
 				// Log final progress before stopping
-				b.logCurrentProgress()
+				if b.Terminal != nil {
+					b.render(time.Now(), true)
+				} else {
+					b.logCurrentProgress()
+				}
 				return
 			}
 		}
 	}()
 }
 
-// Stop ends interval-based logging
+// Stop ends interval-based logging and waits for the final progress output,
+// so later log lines never interleave with it
 func (b *Bar) Stop() {
-	if b.done != nil {
-		close(b.done)
+	b.stopOnce.Do(func() {
+		if b.done != nil {
+			close(b.done)
+		}
+	})
+	if b.finished != nil {
+		<-b.finished
 	}
 }
 
@@ -109,20 +136,12 @@ func (b *Bar) logCurrentProgress() {
 	}
 
 	now := time.Now()
-	var speedBytesPerSec int64
-	if !b.lastIntervalTime.IsZero() {
-		elapsed := now.Sub(b.lastIntervalTime).Seconds()
-		if elapsed > 0 {
-			speedBytesPerSec = int64(float64(b.downloaded-b.lastIntervalBytes) / elapsed)
-			if speedBytesPerSec < 0 {
-				speedBytesPerSec = 0
-			}
-		}
-	}
+	speedBytesPerSec := b.intervalSpeed(now)
 	speedHuman := util.HumanReadableBytes(speedBytesPerSec) + "/s"
 
 	if b.Total > 0 {
-		b.Logger.Info("download_progress",
+		b.Logger.Info(b.event(),
+			"phase", b.Phase,
 			"percent", int(b.percent()),
 			"downloaded_bytes", b.downloaded,
 			"downloaded", util.HumanReadableBytes(b.downloaded),
@@ -130,17 +149,35 @@ func (b *Bar) logCurrentProgress() {
 			"total", util.HumanReadableBytes(b.Total),
 			"speed_bytes_per_sec", speedBytesPerSec,
 			"speed", speedHuman,
+			"eta_seconds", b.etaSeconds(now),
 		)
 	} else {
-		b.Logger.Info("download_progress",
+		b.Logger.Info(b.event(),
+			"phase", b.Phase,
 			"downloaded_bytes", b.downloaded,
 			"downloaded", util.HumanReadableBytes(b.downloaded),
 			"speed_bytes_per_sec", speedBytesPerSec,
 			"speed", speedHuman,
 		)
 	}
+}
+
+// intervalSpeed returns the transfer rate since the previous interval and
+// starts a new interval at now
+func (b *Bar) intervalSpeed(now time.Time) int64 {
+	var speedBytesPerSec int64
+	if !b.lastIntervalTime.IsZero() {
+		elapsed := now.Sub(b.lastIntervalTime).Seconds()
+		if elapsed > 0 {
+			speedBytesPerSec = int64(float64(b.downloaded-b.lastIntervalBytes) / elapsed)
+			if speedBytesPerSec < 0 {
+				speedBytesPerSec = 0
+			}
+		}
+	}
 	b.lastIntervalTime = now
 	b.lastIntervalBytes = b.downloaded
+	return speedBytesPerSec
 }
 
 func (b *Bar) maybeLogMilestone() {
@@ -149,12 +186,14 @@ func (b *Bar) maybeLogMilestone() {
 	}
 	pct := int(b.percent())
 	for pct >= b.nextMilestone && b.nextMilestone <= 100 {
-		b.Logger.Info("download_progress",
+		b.Logger.Info(b.event(),
+			"phase", b.Phase,
 			"percent", b.nextMilestone,
 			"downloaded_bytes", b.downloaded,
 			"downloaded", util.HumanReadableBytes(b.downloaded),
 			"total_bytes", b.Total,
 			"total", util.HumanReadableBytes(b.Total),
+			"eta_seconds", b.etaSeconds(time.Now()),
 		)
 		b.nextMilestone += b.MilestoneStep
 	}
@@ -165,7 +204,8 @@ func (b *Bar) maybeLogBytes() {
 		return
 	}
 	for b.downloaded >= b.nextByteLog {
-		b.Logger.Info("download_progress",
+		b.Logger.Info(b.event(),
+			"phase", b.Phase,
 			"downloaded_bytes", b.nextByteLog,
 			"downloaded", util.HumanReadableBytes(b.nextByteLog),
 		)
@@ -183,3 +223,28 @@ func (b *Bar) percent() float64 {
 	}
 	return p
 }
+
+func (b *Bar) event() string {
+	if b.Phase == "" {
+		return "download_progress"
+	}
+	return b.Phase + "_progress"
+}
+
+// etaSeconds estimates the remaining time from the average rate since Start.
+// It returns -1 when the total is unknown or no rate is available yet.
+func (b *Bar) etaSeconds(now time.Time) int64 {
+	if b.Total <= 0 || b.startTime.IsZero() || b.downloaded <= 0 {
+		return -1
+	}
+	elapsed := now.Sub(b.startTime).Seconds()
+	if elapsed <= 0 {
+		return -1
+	}
+	remaining := b.Total - b.downloaded
+	if remaining <= 0 {
+		return 0
+	}
+	rate := float64(b.downloaded) / elapsed
+	return int64(float64(remaining) / rate)
+}
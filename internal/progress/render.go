@@ -0,0 +1,99 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucrnz/ripvex/internal/util"
+	"golang.org/x/term"
+)
+
+const defaultWidth = 80
+
+// IsTerminal reports whether f is an interactive terminal
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// terminalWidth returns the current width of f, falling back to $COLUMNS and
+// then to 80 columns. It is queried on every redraw so the bar follows resizes.
+func terminalWidth(f *os.File) int {
+	if w, _, err := term.GetSize(int(f.Fd())); err == nil && w > 0 {
+		return w
+	}
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		return w
+	}
+	return defaultWidth
+}
+
+// render redraws the interactive progress line in place
+func (b *Bar) render(now time.Time, final bool) {
+	if b.terminalFailed {
+		return
+	}
+	speed := b.intervalSpeed(now)
+	width := terminalWidth(b.Terminal)
+
+	label := b.Phase
+	if label == "" {
+		label = "download"
+	}
+
+	var suffix string
+	if b.Total > 0 {
+		// Fixed-width fields keep the bar from jittering between frames
+		suffix = fmt.Sprintf(" %3d%% %10s / %-10s %10s/s", int(b.percent()),
+			util.HumanReadableBytes(b.downloaded), util.HumanReadableBytes(b.Total), util.HumanReadableBytes(speed))
+		if final {
+			suffix += fmt.Sprintf("  in %7s", formatETA(int64(now.Sub(b.startTime).Seconds())))
+		} else if eta := b.etaSeconds(now); eta >= 0 {
+			suffix += fmt.Sprintf(" ETA %7s", formatETA(eta))
+		} else {
+			suffix += fmt.Sprintf(" ETA %7s", "--:--")
+		}
+	} else {
+		suffix = fmt.Sprintf(" %10s %10s/s", util.HumanReadableBytes(b.downloaded), util.HumanReadableBytes(speed))
+	}
+
+	// Leave the last column free so the cursor never wraps
+	barWidth := width - 1 - len(label) - len(suffix) - 3
+	line := label + suffix
+	if b.Total > 0 && barWidth >= 10 {
+		filled := int(float64(barWidth) * b.percent() / 100)
+		bar := strings.Repeat("=", filled)
+		if filled < barWidth {
+			bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+		}
+		line = label + " [" + bar + "]" + suffix
+	}
+	if len(line) > width-1 {
+		line = line[:width-1]
+	}
+
+	// \r returns to column 0 and \x1b[K clears what the previous frame left behind
+	frame := "\r" + line + "\x1b[K"
+	if final {
+		frame += "\n"
+	}
+	if _, err := io.WriteString(b.Terminal, frame); err != nil {
+		// The terminal went away (closed stderr); stop drawing, keep downloading
+		b.terminalFailed = true
+	}
+}
+
+// formatETA renders seconds as m:ss or h:mm:ss
+func formatETA(seconds int64) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	h, m, s := seconds/3600, (seconds%3600)/60, seconds%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
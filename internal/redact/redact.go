@@ -0,0 +1,201 @@
+// Package redact hides credentials in diagnostic output: URL passwords and
+// token query parameters, credential-bearing headers, and secrets given on
+// the command line. Loggers built by the logging package apply it to every
+// record, so it also covers errors that quote a URL.
+package redact
+
+import (
+	"context"
+	"log/slog"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Placeholder replaces redacted header values and secrets
+const Placeholder = "[REDACTED]"
+
+// minSecretLen is the shortest secret Register accepts: shorter ones would
+// redact unrelated text
+const minSecretLen = 4
+
+// sensitiveHeaders are credential-bearing headers, besides those whose name
+// contains a sensitiveWords entry
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// sensitiveWords mark header and query parameter names holding credentials,
+// e.g. X-Api-Key, access_token or X-Amz-Signature
+var sensitiveWords = []string{"token", "secret", "password", "passwd", "signature", "credential", "apikey", "api_key", "api-key", "session"}
+
+// sensitiveParams are short query parameter names holding credentials
+var sensitiveParams = map[string]bool{"key": true, "sig": true, "auth": true}
+
+// urlPattern finds URLs in free text, stopping at the quotes Go puts around
+// them in errors
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+
+var (
+	mu      sync.RWMutex
+	secrets []string
+)
+
+// Register adds secrets to hide wherever they appear in Text. Values
+// shorter than four bytes are ignored.
+func Register(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, v := range values {
+		if len(v) >= minSecretLen {
+			secrets = append(secrets, v)
+		}
+	}
+}
+
+// Header returns the value of header name, or Placeholder if the header
+// carries credentials
+func Header(name, value string) string {
+	if SensitiveHeader(name) {
+		return Placeholder
+	}
+	return value
+}
+
+// SensitiveHeader reports whether header name carries credentials
+func SensitiveHeader(name string) bool {
+	return sensitiveHeaders[textproto.CanonicalMIMEHeaderKey(name)] || containsWord(name)
+}
+
+// URL returns u with its password, or a user name given alone, and the
+// values of credential query parameters replaced
+func URL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	c := *u
+	if c.User != nil {
+		if _, ok := c.User.Password(); ok {
+			c.User = url.UserPassword(c.User.Username(), "xxxxx")
+		} else {
+			// A lone user name is usually a token, as in https://<token>@github.com
+			c.User = url.User("xxxxx")
+		}
+	}
+	c.RawQuery = query(c.RawQuery)
+	return c.String()
+}
+
+// RequestURI returns u.RequestURI with credential query parameters replaced
+func RequestURI(u *url.URL) string {
+	c := *u
+	c.RawQuery = query(c.RawQuery)
+	return c.RequestURI()
+}
+
+// Text redacts the URLs in s and every registered secret
+func Text(s string) string {
+	if strings.Contains(s, "://") {
+		s = urlPattern.ReplaceAllStringFunc(s, func(raw string) string {
+			u, err := url.Parse(raw)
+			if err != nil || (u.User == nil && u.RawQuery == "") {
+				return raw
+			}
+			return URL(u)
+		})
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Placeholder)
+	}
+	return s
+}
+
+// query replaces the values of credential parameters in a raw query,
+// keeping the others byte for byte
+func query(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	parts := strings.Split(raw, "&")
+	for i, part := range parts {
+		name, _, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if sensitiveParams[strings.ToLower(name)] || containsWord(name) {
+			parts[i] = part[:strings.IndexByte(part, '=')+1] + "REDACTED"
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func containsWord(name string) bool {
+	lower := strings.ToLower(name)
+	for _, word := range sensitiveWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler wraps h so that the message and the string and error attributes
+// of every record pass through Text
+func Handler(h slog.Handler) slog.Handler {
+	return &handler{h}
+}
+
+type handler struct {
+	slog.Handler
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, Text(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(attr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = attr(a)
+	}
+	return &handler{h.Handler.WithAttrs(redacted)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{h.Handler.WithGroup(name)}
+}
+
+func attr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(Text(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, g := range group {
+			redacted[i] = attr(g)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			a.Value = slog.StringValue(Text(err.Error()))
+		}
+	}
+	return a
+}
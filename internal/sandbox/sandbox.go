@@ -0,0 +1,11 @@
+// Package sandbox confines the current process to the files it works on,
+// for code that parses untrusted input, such as archive decoders. On Linux
+// it uses Landlock and a seccomp filter, on OpenBSD unveil and pledge.
+package sandbox
+
+// Paths are the filesystem access a confined process keeps. Every other
+// path becomes inaccessible.
+type Paths struct {
+	ReadFiles []string // Files that stay readable, such as an archive
+	WriteDirs []string // Directories that stay readable and writable, with everything below them
+}
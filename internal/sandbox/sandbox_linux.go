@@ -0,0 +1,192 @@
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// landlockMinABI is the oldest Landlock ABI Restrict accepts. ABI 1 denies
+// linking and renaming files across directories, which hard links in
+// archives need; ABI 2 (Linux 5.19) lets them through with the REFER right.
+const landlockMinABI = 2
+
+// landlockABIRights are the filesystem access rights each Landlock ABI
+// version adds
+var landlockABIRights = map[int]uint64{
+	1: unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM,
+	2: unix.LANDLOCK_ACCESS_FS_REFER,
+	3: unix.LANDLOCK_ACCESS_FS_TRUNCATE,
+	5: unix.LANDLOCK_ACCESS_FS_IOCTL_DEV,
+}
+
+// Rights granted below Paths.WriteDirs and on Paths.ReadFiles. Devices,
+// FIFOs, sockets and execution stay denied everywhere.
+const (
+	writeDirRights = unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SYM | unix.LANDLOCK_ACCESS_FS_REFER |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	readFileRights = unix.LANDLOCK_ACCESS_FS_READ_FILE
+)
+
+// auditArch identifies the syscall ABI of GOARCH to the seccomp filter.
+// Architectures that may reach sockets through socketcall(2) are left out:
+// the filter would have to decode its arguments.
+var auditArch = map[string]uint32{
+	"amd64":   unix.AUDIT_ARCH_X86_64,
+	"arm64":   unix.AUDIT_ARCH_AARCH64,
+	"riscv64": unix.AUDIT_ARCH_RISCV64,
+	"loong64": unix.AUDIT_ARCH_LOONGARCH64,
+}
+
+// deniedSyscalls fail with EPERM in a confined process: networking,
+// running programs, io_uring, whose queued operations never pass through
+// the filter, and reaching into other processes or the mount table
+var deniedSyscalls = []uint32{
+	unix.SYS_SOCKET, unix.SYS_CONNECT, unix.SYS_BIND, unix.SYS_LISTEN, unix.SYS_ACCEPT4,
+	unix.SYS_EXECVE, unix.SYS_EXECVEAT,
+	unix.SYS_IO_URING_SETUP, unix.SYS_IO_URING_ENTER, unix.SYS_IO_URING_REGISTER,
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_KEXEC_LOAD, unix.SYS_BPF,
+}
+
+// x32SyscallBit marks the syscalls of the x32 ABI, which share the x86-64
+// audit architecture and would bypass the syscall numbers above
+const x32SyscallBit = 0x40000000
+
+// Available returns why Restrict cannot confine this process, or nil
+func Available() error {
+	if _, ok := auditArch[runtime.GOARCH]; !ok {
+		return fmt.Errorf("seccomp filtering is not supported on %s", runtime.GOARCH)
+	}
+	// Restrict needs every thread of the process, which Go cannot reach in
+	// programs using cgo
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_GET_NO_NEW_PRIVS, 0, 0); errno == syscall.ENOTSUP {
+		return fmt.Errorf("sandboxing needs a ripvex binary built with CGO_ENABLED=0")
+	}
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+	if abi < landlockMinABI {
+		return fmt.Errorf("landlock ABI %d is too old, %d or newer (Linux 5.19) is needed", abi, landlockMinABI)
+	}
+	return nil
+}
+
+// landlockABI returns the Landlock ABI version of the running kernel
+func landlockABI() (int, error) {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0, fmt.Errorf("landlock is not available: %w", errno)
+	}
+	return int(abi), nil
+}
+
+// Restrict confines every thread of the process to paths with Landlock,
+// then installs a seccomp filter denying deniedSyscalls. It cannot be
+// undone.
+func Restrict(paths Paths) error {
+	if err := Available(); err != nil {
+		return err
+	}
+	abi, _ := landlockABI()
+	var handled uint64
+	for version, rights := range landlockABIRights {
+		if version <= abi {
+			handled |= rights
+		}
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	for _, path := range paths.ReadFiles {
+		if err := addPathRule(int(fd), path, readFileRights&handled); err != nil {
+			return err
+		}
+	}
+	for _, path := range paths.WriteDirs {
+		if err := addPathRule(int(fd), path, writeDirRights&handled); err != nil {
+			return err
+		}
+	}
+
+	// Landlock and seccomp apply to the calling thread only, and Go runs
+	// goroutines on many: every thread has to restrict itself
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); errno != 0 {
+		return fmt.Errorf("failed to set no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce landlock ruleset: %w", errno)
+	}
+	return installSeccompFilter(auditArch[runtime.GOARCH])
+}
+
+// addPathRule grants rights below path, or on path if it is a file
+func addPathRule(rulesetFd int, path string, rights uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for landlock: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: rights, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(rulesetFd), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to add landlock rule for %s: %w", path, errno)
+	}
+	return nil
+}
+
+// installSeccompFilter denies deniedSyscalls to every thread, and kills the
+// process on syscalls of another architecture
+func installSeccompFilter(arch uint32) error {
+	const (
+		offsetNr   = 0 // struct seccomp_data
+		offsetArch = 4
+	)
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+
+	filter := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNr),
+	}
+	// Each check jumps to the EPERM return after the ALLOW one
+	checks := len(deniedSyscalls) + 1
+	filter = append(filter, jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, uint8(checks), 0))
+	for i, nr := range deniedSyscalls {
+		filter = append(filter, jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, uint8(checks-1-i), 0))
+	}
+	filter = append(filter,
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)),
+	)
+
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	runtime.KeepAlive(filter)
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %w", errno)
+	}
+	return nil
+}
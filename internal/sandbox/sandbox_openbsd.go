@@ -0,0 +1,35 @@
+package sandbox
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Available returns why Restrict cannot confine this process, or nil
+func Available() error {
+	return nil
+}
+
+// Restrict hides every path but paths with unveil, then pledges the
+// process to file I/O, which rules out networking and running programs.
+// It cannot be undone.
+func Restrict(paths Paths) error {
+	for _, path := range paths.ReadFiles {
+		if err := unix.Unveil(path, "r"); err != nil {
+			return fmt.Errorf("failed to unveil %s: %w", path, err)
+		}
+	}
+	for _, path := range paths.WriteDirs {
+		if err := unix.Unveil(path, "rwc"); err != nil {
+			return fmt.Errorf("failed to unveil %s: %w", path, err)
+		}
+	}
+	if err := unix.UnveilBlock(); err != nil {
+		return fmt.Errorf("failed to lock unveil: %w", err)
+	}
+	if err := unix.Pledge("stdio rpath wpath cpath fattr", ""); err != nil {
+		return fmt.Errorf("failed to pledge: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !openbsd
+
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Available returns why Restrict cannot confine this process, or nil
+func Available() error {
+	return fmt.Errorf("sandboxing is not supported on %s", runtime.GOOS)
+}
+
+// Restrict is not supported on this platform
+func Restrict(paths Paths) error {
+	return Available()
+}
@@ -12,3 +12,9 @@ import (
 func ParseDuration(s string) (time.Duration, error) {
 	return str2duration.ParseDuration(s)
 }
+
+// FormatDuration formats a duration the way ParseDuration reads it, with
+// days and weeks, e.g. "1w2d3h"
+func FormatDuration(d time.Duration) string {
+	return str2duration.String(d)
+}
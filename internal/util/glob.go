@@ -0,0 +1,32 @@
+package util
+
+// MatchGlob reports whether s matches pattern, where '*' matches any
+// sequence of characters (including none and including '/') and '?' matches
+// exactly one character. All other characters match literally.
+func MatchGlob(pattern, s string) bool {
+	p := []rune(pattern)
+	str := []rune(s)
+	pi, si := 0, 0
+	starPi, starSi := -1, 0
+
+	for si < len(str) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == str[si]):
+			pi++
+			si++
+		case pi < len(p) && p[pi] == '*':
+			starPi, starSi = pi, si
+			pi++
+		case starPi != -1:
+			// Backtrack: let the last '*' absorb one more character
+			starSi++
+			pi, si = starPi+1, starSi
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
@@ -0,0 +1,20 @@
+//go:build (!unix && !windows) || aix
+
+package util
+
+import (
+	"errors"
+	"os"
+)
+
+// TryLock takes an exclusive advisory lock on f without waiting, and
+// reports whether it got it. It returns an error wrapping
+// errors.ErrUnsupported if the filesystem has no locks.
+func TryLock(f *os.File) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+// Unlock releases a lock taken with TryLock
+func Unlock(f *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,31 @@
+//go:build unix && !aix
+
+package util
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// TryLock takes an exclusive advisory lock on f without waiting, and
+// reports whether it got it. It returns an error wrapping
+// errors.ErrUnsupported if the filesystem has no locks.
+func TryLock(f *os.File) (bool, error) {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, unix.EWOULDBLOCK):
+		return false, nil
+	case errors.Is(err, unix.ENOLCK) || errors.Is(err, unix.EOPNOTSUPP):
+		return false, errors.Join(errors.ErrUnsupported, err)
+	}
+	return false, err
+}
+
+// Unlock releases a lock taken with TryLock
+func Unlock(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
@@ -0,0 +1,26 @@
+package util
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Preallocate reserves size bytes of disk space for f from offset, without
+// changing its size. It returns an error wrapping errors.ErrUnsupported if
+// the filesystem cannot preallocate.
+func Preallocate(f *os.File, offset, size int64) error {
+	// F_PREALLOCATE allocates from the end of the allocated space, not from
+	// offset; a contiguous allocation is tried first
+	store := unix.Fstore_t{Flags: unix.F_ALLOCATECONTIG | unix.F_ALLOCATEALL, Posmode: unix.F_PEOFPOSMODE, Length: size}
+	err := unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store)
+	if err != nil {
+		store.Flags = unix.F_ALLOCATEALL
+		err = unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store)
+	}
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EINVAL) {
+		return errors.Join(errors.ErrUnsupported, err)
+	}
+	return err
+}
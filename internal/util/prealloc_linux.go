@@ -0,0 +1,19 @@
+package util
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Preallocate reserves size bytes of disk space for f from offset, without
+// changing its size. It returns an error wrapping errors.ErrUnsupported if
+// the filesystem cannot preallocate.
+func Preallocate(f *os.File, offset, size int64) error {
+	err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, offset, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return errors.Join(errors.ErrUnsupported, err)
+	}
+	return err
+}
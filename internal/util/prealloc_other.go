@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package util
+
+import (
+	"errors"
+	"os"
+)
+
+// Preallocate reserves size bytes of disk space for f from offset, without
+// changing its size. It returns an error wrapping errors.ErrUnsupported if
+// the filesystem cannot preallocate.
+func Preallocate(f *os.File, offset, size int64) error {
+	return errors.ErrUnsupported
+}
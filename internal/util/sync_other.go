@@ -0,0 +1,16 @@
+//go:build !unix
+
+package util
+
+// SyncFile does nothing on platforms without unix semantics: Windows cannot
+// flush a file opened read-only, and the changes made after writing it only
+// touch its attributes
+func SyncFile(path string) error {
+	return nil
+}
+
+// SyncDir does nothing on platforms without unix semantics, where
+// directories cannot be opened to flush them and NTFS journals their entries
+func SyncDir(path string) error {
+	return nil
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// SyncFile flushes the data and metadata of the file at path, e.g. a mode
+// changed after it was written, to stable storage
+func SyncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// SyncDir flushes the directory containing path, so that creating or
+// renaming path survives a crash
+func SyncDir(path string) error {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package util
+
+import "os"
+
+// Umask returns the file mode creation mask of the process, which is
+// always empty on platforms without one
+func Umask() os.FileMode {
+	return 0
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package util
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Umask returns the file mode creation mask of the process. Reading it means
+// setting it, so call it before starting to create files concurrently.
+func Umask() os.FileMode {
+	mask := unix.Umask(0)
+	unix.Umask(mask)
+	return os.FileMode(mask)
+}
@@ -0,0 +1,93 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// windowsIllegalChars are the printable characters Windows rejects in file
+// names, besides the path separators
+const windowsIllegalChars = `<>:"|?*`
+
+// windowsReservedNames are the device names Windows reserves in every
+// directory, with or without an extension
+var windowsReservedNames = []string{
+	"CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$",
+	"COM0", "COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9", "COM¹", "COM²", "COM³",
+	"LPT0", "LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9", "LPT¹", "LPT²", "LPT³",
+}
+
+// windowsReservedName reports whether name is a reserved device name, such
+// as "NUL" or "con.txt"
+func windowsReservedName(name string) bool {
+	stem, _, _ := strings.Cut(name, ".")
+	stem = strings.TrimRight(stem, " ")
+	for _, reserved := range windowsReservedNames {
+		if strings.EqualFold(stem, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// WindowsNameProblem returns why name, a single path component, is not a
+// valid Windows file name, or "" if it is. "." and ".." are valid.
+func WindowsNameProblem(name string) string {
+	if name == "." || name == ".." {
+		return ""
+	}
+	for _, r := range name {
+		if r < 0x20 {
+			return "contains a control character"
+		}
+		if strings.ContainsRune(windowsIllegalChars, r) {
+			return fmt.Sprintf("contains %q", r)
+		}
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return "ends with a dot or a space"
+	}
+	if windowsReservedName(name) {
+		return "is a reserved name"
+	}
+	return ""
+}
+
+// SanitizeWindowsName returns name, a single path component, as a valid
+// Windows file name: illegal characters and trailing dots and spaces are
+// replaced by underscores, and reserved names get an underscore prefix
+func SanitizeWindowsName(name string) string {
+	if WindowsNameProblem(name) == "" {
+		return name
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(windowsIllegalChars, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	trimmed := strings.TrimRight(name, ". ")
+	name = trimmed + strings.Repeat("_", len(name)-len(trimmed))
+	if windowsReservedName(name) {
+		name = "_" + name
+	}
+	return name
+}
+
+// MapPathComponents applies f to each component of a path separated by
+// slashes or backslashes, keeping the separators as they are
+func MapPathComponents(path string, f func(string) string) string {
+	var b strings.Builder
+	start := 0
+	for i := 0; i <= len(path); i++ {
+		if i < len(path) && path[i] != '/' && path[i] != '\\' {
+			continue
+		}
+		b.WriteString(f(path[start:i]))
+		if i < len(path) {
+			b.WriteByte(path[i])
+		}
+		start = i + 1
+	}
+	return b.String()
+}
@@ -0,0 +1,13 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package util
+
+import "golang.org/x/sys/unix"
+
+// XattrSupported reports whether SetXattr works on this platform
+const XattrSupported = true
+
+// SetXattr sets the extended attribute name of the file at path
+func SetXattr(path, name string, value []byte) error {
+	return unix.Setxattr(path, name, value, 0)
+}